@@ -1,16 +1,46 @@
 package main
 
 import (
-	"log"
+	"context"
+	"opamp-backend/internal/logging"
 	"opamp-backend/internal/server"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "tokens" {
+		os.Exit(runTokens(os.Args[2:]))
+	}
+
+	logger := logging.For("main")
+
 	srv, err := server.NewServer("config/backend.yaml")
 	if err != nil {
-		log.Fatalf("Failed to initialize server: %v", err)
+		logger.Error("Failed to initialize server", "error", err)
+		os.Exit(1)
 	}
 
-	log.Println("OpAMP Backend Server running...")
-	srv.Start()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// SIGHUP reloads backend.yaml and the TLS certificates.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			logger.Info("Received SIGHUP, reloading configuration")
+			srv.ReloadConfig()
+		}
+	}()
+
+	go srv.Start()
+	logger.Info("OpAMP Backend Server running")
+
+	<-ctx.Done()
+	stop()
+	signal.Stop(hup)
+	logger.Info("Received shutdown signal, stopping server")
+	srv.Stop()
 }
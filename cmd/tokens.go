@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/apitokens"
+	"opamp-backend/internal/config"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const tokensUsage = `Usage: opamp-backend tokens <command> [flags]
+
+Commands:
+  mint    -name NAME -scopes read,loglevel [-expires-in 720h] [-agent-ids ID,...] [-agent-labels k=v,...]
+  list
+  expire  -id ID [-at RFC3339]
+  revoke  -id ID
+  hash    reads a token from stdin and prints a token_hash for backend.yaml
+
+Every command except hash accepts -config (default config/backend.yaml) and
+manages the file named by api.token_store.
+`
+
+// runTokens implements the "tokens" subcommand and returns the exit code.
+func runTokens(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, tokensUsage)
+		return 2
+	}
+	if args[0] == "hash" {
+		return hashToken()
+	}
+
+	fs := flag.NewFlagSet("tokens "+args[0], flag.ContinueOnError)
+	configPath := fs.String("config", "config/backend.yaml", "path to backend.yaml")
+	name := fs.String("name", "", "token name (mint)")
+	scopes := fs.String("scopes", "", "comma-separated scopes (mint)")
+	expiresIn := fs.Duration("expires-in", 0, "lifetime of the token; 0 never expires (mint)")
+	agentIDs := fs.String("agent-ids", "", "comma-separated agent IDs the token is limited to (mint)")
+	agentLabels := fs.String("agent-labels", "", "comma-separated key=value labels the token is limited to (mint)")
+	id := fs.String("id", "", "token ID (expire, revoke)")
+	at := fs.String("at", "", "expiry time in RFC 3339; default now (expire)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	store, err := openTokenStore(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch args[0] {
+	case "mint":
+		scopeList := splitList(*scopes)
+		if err := config.ValidateScopes(scopeList); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -scopes: %v\n", err)
+			return 2
+		}
+		selector := agents.Selector{IDs: splitList(*agentIDs)}
+		for _, label := range splitList(*agentLabels) {
+			key, value, ok := strings.Cut(label, "=")
+			if !ok {
+				fmt.Fprintf(os.Stderr, "invalid -agent-labels entry %q (expected key=value)\n", label)
+				return 2
+			}
+			if selector.Labels == nil {
+				selector.Labels = make(map[string]string)
+			}
+			selector.Labels[key] = value
+		}
+		var expiresAt *time.Time
+		if *expiresIn > 0 {
+			t := time.Now().Add(*expiresIn).UTC()
+			expiresAt = &t
+		}
+		secret, token, err := store.Mint(*name, scopeList, selector, expiresAt)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Minted token %s (%s). Store the token now; it cannot be shown again.\n", token.ID, token.Name)
+		fmt.Println(secret)
+
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSCOPES\tCREATED\tEXPIRES\tSTATUS")
+		now := time.Now()
+		for _, t := range store.List() {
+			status := "active"
+			switch {
+			case t.RevokedAt != nil:
+				status = "revoked"
+			case !t.Active(now):
+				status = "expired"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", t.ID, t.Name, strings.Join(t.Scopes, ","),
+				t.CreatedAt.Format(time.RFC3339), formatExpiry(t.ExpiresAt), status)
+		}
+		w.Flush()
+
+	case "expire":
+		when := time.Now()
+		if *at != "" {
+			if when, err = time.Parse(time.RFC3339, *at); err != nil {
+				fmt.Fprintln(os.Stderr, "invalid -at (expected RFC 3339)")
+				return 2
+			}
+		}
+		return printToken(store.Expire(*id, when))
+
+	case "revoke":
+		return printToken(store.Revoke(*id))
+
+	default:
+		fmt.Fprint(os.Stderr, tokensUsage)
+		return 2
+	}
+	return 0
+}
+
+func openTokenStore(configPath string) (*apitokens.Store, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.API.TokenStore == "" {
+		return nil, fmt.Errorf("%s does not set api.token_store", configPath)
+	}
+	return apitokens.Open(cfg.API.TokenStore)
+}
+
+// hashToken prints the token_hash for a secret read from stdin, so that
+// configured tokens need not be stored in clear.
+func hashToken() int {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	secret := strings.TrimSpace(line)
+	if secret == "" {
+		fmt.Fprintln(os.Stderr, "expected a token on stdin:", err)
+		return 2
+	}
+	hash, err := apitokens.NewHash(secret)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(hash.String())
+	return 0
+}
+
+func printToken(token apitokens.Token, err error) int {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(token)
+	return 0
+}
+
+func formatExpiry(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
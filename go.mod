@@ -5,7 +5,6 @@ go 1.22.1
 require (
 	github.com/gorilla/websocket v1.5.3
 	github.com/open-telemetry/opamp-go v0.19.0
+	google.golang.org/protobuf v1.36.2
 	gopkg.in/yaml.v2 v2.4.0
 )
-
-require google.golang.org/protobuf v1.36.2 // indirect
@@ -0,0 +1,153 @@
+// Package agentauth authenticates agents connecting to the OpAMP endpoint
+// using the credentials configured under opamp.auth.
+package agentauth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"opamp-backend/internal/config"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Authorization schemes accepted from agents.
+const (
+	SchemeNone         = "none"
+	SchemeSharedSecret = "Secret-Key"
+	SchemeEnrollment   = "Enrollment-Token"
+	SchemeBearer       = "Bearer"
+)
+
+// Rejection reasons, used as the reason label on rejection metrics.
+const (
+	ReasonMissingCredentials = "missing_credentials"
+	ReasonUnsupportedScheme  = "unsupported_scheme"
+	ReasonInvalidCredentials = "invalid_credentials"
+	ReasonExpiredCredentials = "expired_credentials"
+	ReasonAgentIDMismatch    = "agent_id_mismatch"
+)
+
+// Identity describes the credential an agent authenticated with.
+type Identity struct {
+	Scheme  string
+	Name    string // token name, if the credential has one
+	AgentID string // the only agent ID this credential may be used by, if bound
+}
+
+// String returns a compact description suitable for logs and the agent record.
+func (i Identity) String() string {
+	if i.Name == "" {
+		return i.Scheme
+	}
+	return i.Scheme + ":" + i.Name
+}
+
+// CheckAgentID verifies that a credential bound to an agent is used by that agent.
+func (i Identity) CheckAgentID(agentID string) error {
+	if i.AgentID != "" && i.AgentID != agentID {
+		return reject(http.StatusForbidden, ReasonAgentIDMismatch, "credential %s is bound to agent %s, not %s", i, i.AgentID, agentID)
+	}
+	return nil
+}
+
+// CheckSession verifies that a request continuing the session of agentID
+// authenticated the way that session did: with the credential recorded as
+// authIdentity and the client certificate subject certSubject, if any.
+func (i Identity) CheckSession(agentID, authIdentity, certSubject string, cert *ClientCert) error {
+	subject := ""
+	if cert != nil {
+		subject = cert.Subject
+	}
+	if i.String() != authIdentity || subject != certSubject {
+		return reject(http.StatusForbidden, ReasonAgentIDMismatch, "credential %s does not match the session of agent %s", i, agentID)
+	}
+	return nil
+}
+
+// Error is returned when authentication fails.
+type Error struct {
+	Status int    // HTTP status to return to the agent
+	Reason string // one of the Reason constants
+	msg    string
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+func reject(status int, reason, format string, args ...interface{}) *Error {
+	return &Error{Status: status, Reason: reason, msg: fmt.Sprintf(format, args...)}
+}
+
+// Authenticator checks agent credentials. Its configuration can be replaced
+// at runtime with Update.
+type Authenticator struct {
+	cfg atomic.Pointer[config.AgentAuthConfig]
+}
+
+// New creates an Authenticator for the given configuration.
+func New(cfg config.AgentAuthConfig) *Authenticator {
+	a := &Authenticator{}
+	a.Update(cfg)
+	return a
+}
+
+// Update replaces the accepted credentials.
+func (a *Authenticator) Update(cfg config.AgentAuthConfig) {
+	a.cfg.Store(&cfg)
+}
+
+// Authenticate checks the Authorization header of an agent's connection
+// request. When authentication is disabled every request is accepted.
+func (a *Authenticator) Authenticate(r *http.Request) (Identity, error) {
+	cfg := a.cfg.Load()
+	if !cfg.Enabled {
+		return Identity{Scheme: SchemeNone}, nil
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return Identity{}, reject(http.StatusUnauthorized, ReasonMissingCredentials, "missing Authorization header")
+	}
+	scheme, credential, found := strings.Cut(header, " ")
+	credential = strings.TrimSpace(credential)
+	if !found || credential == "" {
+		return Identity{}, reject(http.StatusUnauthorized, ReasonMissingCredentials, "malformed Authorization header")
+	}
+
+	switch {
+	case strings.EqualFold(scheme, SchemeSharedSecret):
+		for _, secret := range cfg.SharedSecrets {
+			if secureEqual(credential, secret) {
+				return Identity{Scheme: SchemeSharedSecret}, nil
+			}
+		}
+	case strings.EqualFold(scheme, SchemeEnrollment):
+		for _, token := range cfg.EnrollmentTokens {
+			if !secureEqual(credential, token.Token) {
+				continue
+			}
+			if !token.ExpiresAt.IsZero() && time.Now().After(token.ExpiresAt) {
+				return Identity{}, reject(http.StatusForbidden, ReasonExpiredCredentials, "enrollment token %q has expired", token.Name)
+			}
+			return Identity{Scheme: SchemeEnrollment, Name: token.Name, AgentID: token.AgentID}, nil
+		}
+	case strings.EqualFold(scheme, SchemeBearer):
+		for _, token := range cfg.BearerTokens {
+			if secureEqual(credential, token.Token) {
+				return Identity{Scheme: SchemeBearer, Name: token.Name}, nil
+			}
+		}
+	default:
+		return Identity{}, reject(http.StatusUnauthorized, ReasonUnsupportedScheme, "unsupported authorization scheme %q", scheme)
+	}
+
+	return Identity{}, reject(http.StatusUnauthorized, ReasonInvalidCredentials, "invalid %s credentials", scheme)
+}
+
+// secureEqual compares two secrets in constant time.
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,116 @@
+package agentauth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"opamp-backend/internal/config"
+	"testing"
+	"time"
+)
+
+func testAuthenticator() *Authenticator {
+	return New(config.AgentAuthConfig{
+		Enabled:       true,
+		SharedSecrets: []string{"shared"},
+		EnrollmentTokens: []config.EnrollmentToken{
+			{Name: "agent-1", Token: "enroll-1", AgentID: "0102"},
+			{Name: "old", Token: "enroll-old", ExpiresAt: time.Now().Add(-time.Hour)},
+		},
+		BearerTokens: []config.BearerToken{{Name: "fleet", Token: "bearer-1"}},
+	})
+}
+
+func authenticate(a *Authenticator, header string) (Identity, error) {
+	req := httptest.NewRequest("GET", "/v1/opamp", nil)
+	if header != "" {
+		req.Header.Set("Authorization", header)
+	}
+	return a.Authenticate(req)
+}
+
+func TestAuthenticate_Disabled(t *testing.T) {
+	a := New(config.AgentAuthConfig{})
+	identity, err := authenticate(a, "")
+	if err != nil {
+		t.Fatalf("expected request to be accepted, got %v", err)
+	}
+	if identity.Scheme != SchemeNone {
+		t.Errorf("expected scheme %q, got %q", SchemeNone, identity.Scheme)
+	}
+}
+
+func TestAuthenticate_Schemes(t *testing.T) {
+	a := testAuthenticator()
+
+	tests := []struct {
+		header string
+		scheme string
+		status int
+	}{
+		{"Secret-Key shared", SchemeSharedSecret, 0},
+		{"Enrollment-Token enroll-1", SchemeEnrollment, 0},
+		{"Bearer bearer-1", SchemeBearer, 0},
+		{"", "", http.StatusUnauthorized},
+		{"Secret-Key wrong", "", http.StatusUnauthorized},
+		{"Basic dXNlcjpwYXNz", "", http.StatusUnauthorized},
+		{"Enrollment-Token enroll-old", "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		identity, err := authenticate(a, tt.header)
+		if tt.status == 0 {
+			if err != nil {
+				t.Errorf("%q: expected success, got %v", tt.header, err)
+			} else if identity.Scheme != tt.scheme {
+				t.Errorf("%q: expected scheme %q, got %q", tt.header, tt.scheme, identity.Scheme)
+			}
+			continue
+		}
+		var authErr *Error
+		if !errors.As(err, &authErr) || authErr.Status != tt.status {
+			t.Errorf("%q: expected status %d, got %v", tt.header, tt.status, err)
+		}
+	}
+}
+
+func TestIdentity_CheckAgentID(t *testing.T) {
+	identity, err := authenticate(testAuthenticator(), "Enrollment-Token enroll-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := identity.CheckAgentID("0102"); err != nil {
+		t.Errorf("expected bound agent to be accepted, got %v", err)
+	}
+	if err := identity.CheckAgentID("0304"); err == nil {
+		t.Error("expected other agent to be rejected")
+	}
+}
+
+func TestIdentity_CheckSession(t *testing.T) {
+	identity := Identity{Scheme: SchemeBearer, Name: "agent-a"}
+	cert := &ClientCert{Subject: "CN=0102"}
+
+	tests := []struct {
+		name         string
+		authIdentity string
+		certSubject  string
+		cert         *ClientCert
+		ok           bool
+	}{
+		{name: "same credential", authIdentity: "Bearer:agent-a", ok: true},
+		{name: "same credential and certificate", authIdentity: "Bearer:agent-a", certSubject: "CN=0102", cert: cert, ok: true},
+		{name: "other credential", authIdentity: "Bearer:agent-b"},
+		{name: "certificate missing", authIdentity: "Bearer:agent-a", certSubject: "CN=0102"},
+		{name: "other certificate", authIdentity: "Bearer:agent-a", certSubject: "CN=0304", cert: cert},
+	}
+	for _, tt := range tests {
+		err := identity.CheckSession("0102", tt.authIdentity, tt.certSubject, tt.cert)
+		if tt.ok && err != nil {
+			t.Errorf("%s: expected the session to continue, got %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: expected the session to be refused", tt.name)
+		}
+	}
+}
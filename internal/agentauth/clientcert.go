@@ -0,0 +1,100 @@
+package agentauth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"opamp-backend/internal/config"
+	"slices"
+)
+
+// ReasonClientCertMismatch is used when a client certificate does not match
+// the agent presenting it.
+const ReasonClientCertMismatch = "client_cert_mismatch"
+
+// ClientCert is the identity carried by a verified client certificate.
+type ClientCert struct {
+	Subject    string   // full subject distinguished name
+	CommonName string   // subject common name
+	SANs       []string // DNS names, email addresses, IP addresses and URIs
+}
+
+// ClientCertFromRequest returns the identity of the client certificate that
+// was verified during the TLS handshake, or nil if none was presented.
+func ClientCertFromRequest(r *http.Request) *ClientCert {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return clientCertFromX509(r.TLS.VerifiedChains[0][0])
+}
+
+func clientCertFromX509(cert *x509.Certificate) *ClientCert {
+	id := &ClientCert{
+		Subject:    cert.Subject.String(),
+		CommonName: cert.Subject.CommonName,
+	}
+	id.SANs = append(id.SANs, cert.DNSNames...)
+	id.SANs = append(id.SANs, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		id.SANs = append(id.SANs, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		id.SANs = append(id.SANs, uri.String())
+	}
+	return id
+}
+
+// Names returns every value the certificate can be matched on: its SANs and
+// common name.
+func (c *ClientCert) Names() []string {
+	if c == nil {
+		return nil
+	}
+	names := append([]string(nil), c.SANs...)
+	if c.CommonName != "" {
+		names = append(names, c.CommonName)
+	}
+	return names
+}
+
+// VerifyClientCert checks that the certificate's common name or one of its
+// SANs identifies the agent according to rule. instanceUID is the raw
+// instance UID reported by the agent and labels its description attributes.
+func VerifyClientCert(rule config.ClientIdentity, cert *ClientCert, instanceUID []byte, labels map[string]string) error {
+	var expected []string
+	switch rule.Match {
+	case config.IdentityMatchNone:
+		return nil
+	case config.IdentityMatchInstanceUID:
+		expected = instanceUIDForms(instanceUID)
+	case config.IdentityMatchLabel:
+		value := labels[rule.Label]
+		if value == "" {
+			return reject(http.StatusForbidden, ReasonClientCertMismatch, "agent did not report the %q attribute required to verify its client certificate", rule.Label)
+		}
+		expected = []string{value}
+	default:
+		return reject(http.StatusForbidden, ReasonClientCertMismatch, "unsupported identity match %q", rule.Match)
+	}
+
+	if cert == nil {
+		return reject(http.StatusForbidden, ReasonClientCertMismatch, "a verified client certificate is required")
+	}
+	for _, name := range cert.Names() {
+		if slices.Contains(expected, name) {
+			return nil
+		}
+	}
+	return reject(http.StatusForbidden, ReasonClientCertMismatch, "client certificate %q does not match agent identity %v", cert.Subject, expected)
+}
+
+// instanceUIDForms returns the textual forms an instance UID may take in a
+// certificate: hex, UUID and urn:uuid.
+func instanceUIDForms(uid []byte) []string {
+	forms := []string{fmt.Sprintf("%x", uid)}
+	if len(uid) == 16 {
+		uuid := fmt.Sprintf("%x-%x-%x-%x-%x", uid[0:4], uid[4:6], uid[6:8], uid[8:10], uid[10:16])
+		forms = append(forms, uuid, "urn:uuid:"+uuid)
+	}
+	return forms
+}
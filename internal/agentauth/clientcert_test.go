@@ -0,0 +1,53 @@
+package agentauth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/url"
+	"opamp-backend/internal/config"
+	"testing"
+)
+
+func TestVerifyClientCert(t *testing.T) {
+	uid := []byte{0x01, 0x90, 0xe5, 0xf1, 0xc7, 0xa2, 0x7f, 0x3c, 0x9e, 0x1a, 0x6b, 0x5d, 0x4c, 0x3b, 0x2a, 0x10}
+	urn, _ := url.Parse("urn:uuid:0190e5f1-c7a2-7f3c-9e1a-6b5d4c3b2a10")
+
+	byCN := clientCertFromX509(&x509.Certificate{Subject: pkix.Name{CommonName: "0190e5f1c7a27f3c9e1a6b5d4c3b2a10"}})
+	byURI := clientCertFromX509(&x509.Certificate{Subject: pkix.Name{CommonName: "agent"}, URIs: []*url.URL{urn}})
+	byDNS := clientCertFromX509(&x509.Certificate{Subject: pkix.Name{CommonName: "agent"}, DNSNames: []string{"edge-1.example.com"}})
+
+	instanceRule := config.ClientIdentity{Match: config.IdentityMatchInstanceUID}
+	labelRule := config.ClientIdentity{Match: config.IdentityMatchLabel, Label: "host.name"}
+
+	tests := []struct {
+		name   string
+		rule   config.ClientIdentity
+		cert   *ClientCert
+		labels map[string]string
+		ok     bool
+	}{
+		{"no rule", config.ClientIdentity{}, nil, nil, true},
+		{"instance uid in common name", instanceRule, byCN, nil, true},
+		{"instance uid as urn SAN", instanceRule, byURI, nil, true},
+		{"instance uid mismatch", instanceRule, byDNS, nil, false},
+		{"missing certificate", instanceRule, nil, nil, false},
+		{"label matches SAN", labelRule, byDNS, map[string]string{"host.name": "edge-1.example.com"}, true},
+		{"label mismatch", labelRule, byDNS, map[string]string{"host.name": "edge-2.example.com"}, false},
+		{"label not reported", labelRule, byDNS, nil, false},
+	}
+
+	for _, tt := range tests {
+		err := VerifyClientCert(tt.rule, tt.cert, uid, tt.labels)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("%s: expected match, got %v", tt.name, err)
+			}
+			continue
+		}
+		var authErr *Error
+		if !errors.As(err, &authErr) || authErr.Reason != ReasonClientCertMismatch {
+			t.Errorf("%s: expected %s error, got %v", tt.name, ReasonClientCertMismatch, err)
+		}
+	}
+}
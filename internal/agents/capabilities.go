@@ -0,0 +1,78 @@
+package agents
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+// HasCapability reports whether the agent advertised capability in its last
+// message.
+func (a *Agent) HasCapability(capability protobufs.AgentCapabilities) bool {
+	return a.Capabilities&uint64(capability) != 0
+}
+
+// ErrUnsupported is wrapped by errors for messages an agent has not
+// advertised the capability to accept.
+var ErrUnsupported = errors.New("not supported by the agent")
+
+// RequireCapability returns an error wrapping ErrUnsupported unless the agent
+// advertised capability.
+func (a *Agent) RequireCapability(capability protobufs.AgentCapabilities) error {
+	if a.HasCapability(capability) {
+		return nil
+	}
+	name := strings.TrimPrefix(capability.String(), "AgentCapabilities_")
+	return fmt.Errorf("agent %s does not advertise %s: %w", a.ID, name, ErrUnsupported)
+}
+
+// RequiredCapabilities returns the capabilities an agent must have advertised
+// to be sent message.
+func RequiredCapabilities(message *protobufs.ServerToAgent) []protobufs.AgentCapabilities {
+	var required []protobufs.AgentCapabilities
+	if message.RemoteConfig != nil {
+		required = append(required, protobufs.AgentCapabilities_AgentCapabilities_AcceptsRemoteConfig)
+	}
+	if message.PackagesAvailable != nil {
+		required = append(required, protobufs.AgentCapabilities_AgentCapabilities_AcceptsPackages)
+	}
+	if settings := message.ConnectionSettings; settings != nil {
+		if settings.Opamp != nil {
+			required = append(required, protobufs.AgentCapabilities_AgentCapabilities_AcceptsOpAMPConnectionSettings)
+		}
+		if settings.OwnMetrics != nil {
+			required = append(required, protobufs.AgentCapabilities_AgentCapabilities_ReportsOwnMetrics)
+		}
+		if settings.OwnTraces != nil {
+			required = append(required, protobufs.AgentCapabilities_AgentCapabilities_ReportsOwnTraces)
+		}
+		if settings.OwnLogs != nil {
+			required = append(required, protobufs.AgentCapabilities_AgentCapabilities_ReportsOwnLogs)
+		}
+		if len(settings.OtherConnections) > 0 {
+			required = append(required, protobufs.AgentCapabilities_AgentCapabilities_AcceptsOtherConnectionSettings)
+		}
+	}
+	if message.Command != nil && message.Command.Type == protobufs.CommandType_CommandType_Restart {
+		required = append(required, protobufs.AgentCapabilities_AgentCapabilities_AcceptsRestartCommand)
+	}
+	return required
+}
+
+// CapabilityNames lists the names of the capabilities the agent advertised,
+// without their enum prefix, e.g. "AcceptsRemoteConfig".
+func (a *Agent) CapabilityNames() []string {
+	var names []string
+	caps := a.Capabilities
+	for caps != 0 {
+		bit := uint64(1) << bits.TrailingZeros64(caps)
+		caps &^= bit
+		if name, ok := protobufs.AgentCapabilities_name[int32(bit)]; ok {
+			names = append(names, strings.TrimPrefix(name, "AgentCapabilities_"))
+		}
+	}
+	return names
+}
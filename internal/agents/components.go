@@ -0,0 +1,98 @@
+package agents
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrMissingComponents is wrapped by the errors returned for configurations
+// that use components the agent's binary does not include.
+var ErrMissingComponents = errors.New("configuration uses components the agent does not include")
+
+// componentKinds are the collector configuration sections that reference
+// components, as reported in AvailableComponents.
+var componentKinds = []string{"receivers", "processors", "exporters", "extensions", "connectors"}
+
+// UpdateAgentAvailableComponents stores the components an agent reported.
+// Agents may report only the hash of their components; it returns true if
+// that hash is not the one of the components already stored, in which case
+// the stored list is cleared and the agent should be asked for the full list.
+func (m *Manager) UpdateAgentAvailableComponents(agentID string, components *protobufs.AvailableComponents) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return false, fmt.Errorf("agent %s not found", agentID)
+	}
+
+	if len(components.Components) == 0 {
+		if agent.AvailableComponents != nil && bytes.Equal(agent.AvailableComponents.Hash, components.Hash) {
+			return false, nil
+		}
+		// The stored list no longer describes the agent's binary, so forget
+		// it until the full list arrives.
+		agent.AvailableComponents = nil
+		return true, nil
+	}
+	agent.AvailableComponents = components
+	return false, nil
+}
+
+// ComponentTypes returns the component types the agent reported by kind,
+// e.g. {"receivers": ["otlp"]}, or nil if it did not report any.
+func (a *Agent) ComponentTypes() map[string][]string {
+	if a.AvailableComponents == nil {
+		return nil
+	}
+	types := make(map[string][]string, len(a.AvailableComponents.Components))
+	for kind, details := range a.AvailableComponents.Components {
+		names := make([]string, 0, len(details.GetSubComponentMap()))
+		for name := range details.GetSubComponentMap() {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		types[kind] = names
+	}
+	return types
+}
+
+// CheckComponents returns an error wrapping ErrMissingComponents if a
+// configuration file in config references components the agent reported it
+// does not include. Agents that have not reported their components, and
+// files that are not collector YAML, are not checked.
+func (a *Agent) CheckComponents(config *protobufs.AgentRemoteConfig) error {
+	available := a.ComponentTypes()
+	if available == nil || config.GetConfig() == nil {
+		return nil
+	}
+
+	var missing []string
+	for _, file := range config.GetConfig().GetConfigMap() {
+		var sections map[string]map[string]interface{}
+		if err := yaml.Unmarshal(file.GetBody(), &sections); err != nil {
+			continue
+		}
+		for _, kind := range componentKinds {
+			for id := range sections[kind] {
+				// Components are named "type" or "type/name"
+				componentType, _, _ := strings.Cut(id, "/")
+				name := kind + "/" + componentType
+				if !slices.Contains(available[kind], componentType) && !slices.Contains(missing, name) {
+					missing = append(missing, name)
+				}
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	slices.Sort(missing)
+	return fmt.Errorf("agent %s lacks %s: %w", a.ID, strings.Join(missing, ", "), ErrMissingComponents)
+}
@@ -0,0 +1,86 @@
+package agents
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+func TestCheckComponents(t *testing.T) {
+	available := &protobufs.AvailableComponents{Components: map[string]*protobufs.ComponentDetails{
+		"receivers": {SubComponentMap: map[string]*protobufs.ComponentDetails{"otlp": {}}},
+		"exporters": {SubComponentMap: map[string]*protobufs.ComponentDetails{"debug": {}}},
+	}}
+	remoteConfig := func(body string) *protobufs.AgentRemoteConfig {
+		return &protobufs.AgentRemoteConfig{Config: &protobufs.AgentConfigMap{ConfigMap: map[string]*protobufs.AgentConfigFile{
+			"collector": {Body: []byte(body), ContentType: "text/yaml"},
+		}}}
+	}
+
+	tests := []struct {
+		name       string
+		components *protobufs.AvailableComponents
+		config     *protobufs.AgentRemoteConfig
+		missing    string // the missing components named in the error, if any
+	}{
+		{name: "all available", components: available, config: remoteConfig("receivers:\n  otlp:\nexporters:\n  debug:\n")},
+		{name: "named component", components: available, config: remoteConfig("receivers:\n  otlp/grpc:\n")},
+		{name: "missing components", components: available, config: remoteConfig("processors:\n  batch:\n  batch/2:\nexporters:\n  otlphttp:\n"),
+			missing: "exporters/otlphttp, processors/batch"},
+		{name: "components not reported", config: remoteConfig("processors:\n  batch:\n")},
+		{name: "not YAML", components: available, config: remoteConfig("{")},
+		{name: "no remote config", components: available},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := &Agent{ID: "a", AvailableComponents: tt.components}
+			err := agent.CheckComponents(tt.config)
+			if tt.missing == "" {
+				if err != nil {
+					t.Errorf("CheckComponents() = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrMissingComponents) || !strings.Contains(err.Error(), "lacks "+tt.missing+":") {
+				t.Errorf("CheckComponents() = %v, want missing %s", err, tt.missing)
+			}
+		})
+	}
+}
+
+func TestUpdateAgentAvailableComponents(t *testing.T) {
+	m := NewManager()
+	m.RegisterAgent(&Agent{ID: "a"})
+	full := &protobufs.AvailableComponents{Hash: []byte("v1"), Components: map[string]*protobufs.ComponentDetails{
+		"receivers": {SubComponentMap: map[string]*protobufs.ComponentDetails{"otlp": {}, "filelog": {}}},
+	}}
+
+	steps := []struct {
+		name       string
+		components *protobufs.AvailableComponents
+		needsFull  bool
+		receivers  []string
+	}{
+		{name: "unknown hash", components: &protobufs.AvailableComponents{Hash: []byte("v1")}, needsFull: true},
+		{name: "full list", components: full, receivers: []string{"filelog", "otlp"}},
+		{name: "known hash", components: &protobufs.AvailableComponents{Hash: []byte("v1")}, receivers: []string{"filelog", "otlp"}},
+		{name: "changed hash", components: &protobufs.AvailableComponents{Hash: []byte("v2")}, needsFull: true},
+	}
+	for _, step := range steps {
+		needsFull, err := m.UpdateAgentAvailableComponents("a", step.components)
+		if err != nil || needsFull != step.needsFull {
+			t.Errorf("%s: UpdateAgentAvailableComponents() = %v, %v, want %v", step.name, needsFull, err, step.needsFull)
+		}
+		agent, _ := m.GetAgent("a")
+		if types := agent.ComponentTypes()["receivers"]; !slices.Equal(types, step.receivers) {
+			t.Errorf("%s: receiver types = %v, want %v", step.name, types, step.receivers)
+		}
+	}
+
+	if _, err := m.UpdateAgentAvailableComponents("unknown", full); err == nil {
+		t.Error("Expected an error for an unregistered agent")
+	}
+}
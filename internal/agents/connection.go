@@ -0,0 +1,106 @@
+package agents
+
+import (
+	"bytes"
+	"encoding/hex"
+	"time"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+// ConnectionOffer tracks the connection settings last offered to an agent. It
+// outlives the agent record, since agents reconnect to apply new OpAMP
+// settings and must not be offered the same settings again.
+type ConnectionOffer struct {
+	AgentID   string    `json:"agent_id"`
+	Hash      string    `json:"hash"`
+	OfferedAt time.Time `json:"offered_at"`
+	// First connection of the agent after the offer and the credential it
+	// authenticated with
+	ReconnectedAt *time.Time `json:"reconnected_at,omitempty"`
+	AuthIdentity  string     `json:"auth_identity,omitempty"`
+	// State of the own telemetry destinations in the offer, judged by the
+	// first health the agent reports after it: OwnTelemetryOffered,
+	// OwnTelemetryHealthy or OwnTelemetryUnhealthy
+	OwnTelemetry      string     `json:"own_telemetry,omitempty"`
+	OwnTelemetryError string     `json:"own_telemetry_error,omitempty"`
+	VerifiedAt        *time.Time `json:"verified_at,omitempty"`
+
+	hash []byte
+}
+
+// Own telemetry states of a connection settings offer.
+const (
+	OwnTelemetryOffered   = "offered"
+	OwnTelemetryHealthy   = "healthy"
+	OwnTelemetryUnhealthy = "unhealthy"
+)
+
+// NeedsConnectionSettings reports whether an agent was not yet offered the
+// connection settings with hash.
+func (m *Manager) NeedsConnectionSettings(agentID string, hash []byte) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	offer, exists := m.connectionOffers[agentID]
+	return !exists || !bytes.Equal(offer.hash, hash)
+}
+
+// SetConnectionOffer records that the connection settings with hash were sent
+// to an agent. ownTelemetry tells whether they direct the agent's own
+// telemetry, which is then verified by the next health the agent reports.
+func (m *Manager) SetConnectionOffer(agentID string, hash []byte, ownTelemetry bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	offer := &ConnectionOffer{
+		AgentID:   agentID,
+		Hash:      hex.EncodeToString(hash),
+		OfferedAt: time.Now(),
+		hash:      hash,
+	}
+	if ownTelemetry {
+		offer.OwnTelemetry = OwnTelemetryOffered
+	}
+	m.connectionOffers[agentID] = offer
+}
+
+// ConnectionOffers returns the connection settings last offered to every
+// agent.
+func (m *Manager) ConnectionOffers() []ConnectionOffer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	offers := make([]ConnectionOffer, 0, len(m.connectionOffers))
+	for _, offer := range m.connectionOffers {
+		offers = append(offers, *offer)
+	}
+	return offers
+}
+
+// observeConnection records the first connection of an agent after it was
+// offered connection settings. The caller must hold m.mu.
+func (m *Manager) observeConnection(agent *Agent) {
+	if offer, exists := m.connectionOffers[agent.ID]; exists && offer.ReconnectedAt == nil {
+		now := time.Now()
+		offer.ReconnectedAt = &now
+		offer.AuthIdentity = agent.AuthIdentity
+		logger.Info("Agent reconnected after connection settings offer", "agent_id", agent.ID, "auth_identity", agent.AuthIdentity)
+	}
+}
+
+// verifyOwnTelemetry judges the own telemetry settings offered to an agent by
+// the health it reported. The caller must hold m.mu.
+func (m *Manager) verifyOwnTelemetry(agentID string, health *protobufs.ComponentHealth) {
+	offer, exists := m.connectionOffers[agentID]
+	if !exists || offer.OwnTelemetry != OwnTelemetryOffered || health == nil {
+		return
+	}
+	now := time.Now()
+	offer.VerifiedAt = &now
+	if health.Healthy {
+		offer.OwnTelemetry = OwnTelemetryHealthy
+		logger.Info("Agent is healthy after own telemetry offer", "agent_id", agentID)
+		return
+	}
+	offer.OwnTelemetry = OwnTelemetryUnhealthy
+	offer.OwnTelemetryError = health.LastError
+	logger.Warn("Agent is unhealthy after own telemetry offer", "agent_id", agentID, "error", health.LastError)
+}
@@ -0,0 +1,93 @@
+package agents
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+// MaxCustomMessages is how many of the custom messages received from an
+// agent are kept.
+const MaxCustomMessages = 20
+
+// ErrCustomMessagePending is returned when a custom message is queued for an
+// agent connected over plain HTTP that has not yet polled for the previous
+// one.
+var ErrCustomMessagePending = errors.New("a custom message is already waiting for the agent's next poll")
+
+// CustomMessage is a custom message received from an agent.
+type CustomMessage struct {
+	Capability string    `json:"capability"`
+	Type       string    `json:"type"`
+	Data       []byte    `json:"data"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// HasCustomCapability reports whether the agent declared a custom
+// capability, e.g. "io.opentelemetry.pprof".
+func (a *Agent) HasCustomCapability(capability string) bool {
+	return slices.Contains(a.CustomCapabilities, capability)
+}
+
+// RequireCustomCapability returns an error wrapping ErrUnsupported unless the
+// agent declared a custom capability.
+func (a *Agent) RequireCustomCapability(capability string) error {
+	if a.HasCustomCapability(capability) {
+		return nil
+	}
+	return fmt.Errorf("agent %s does not declare custom capability %s: %w", a.ID, capability, ErrUnsupported)
+}
+
+// UpdateAgentCustomCapabilities stores the custom capabilities an agent
+// declared.
+func (m *Manager) UpdateAgentCustomCapabilities(agentID string, capabilities []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return fmt.Errorf("agent %s not found", agentID)
+	}
+
+	agent.CustomCapabilities = capabilities
+	return nil
+}
+
+// AddCustomMessage records a custom message received from an agent, keeping
+// the last MaxCustomMessages.
+func (m *Manager) AddCustomMessage(agentID string, message *protobufs.CustomMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return fmt.Errorf("agent %s not found", agentID)
+	}
+
+	agent.customMessages = append(agent.customMessages, CustomMessage{
+		Capability: message.Capability,
+		Type:       message.Type,
+		Data:       message.Data,
+		ReceivedAt: time.Now(),
+	})
+	if extra := len(agent.customMessages) - MaxCustomMessages; extra > 0 {
+		agent.customMessages = slices.Delete(agent.customMessages, 0, extra)
+	}
+	return nil
+}
+
+// CustomMessages returns the custom messages last received from an agent,
+// oldest first.
+func (m *Manager) CustomMessages(agentID string) ([]CustomMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return nil, fmt.Errorf("agent %s not found", agentID)
+	}
+	return slices.Clone(agent.customMessages), nil
+}
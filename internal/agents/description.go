@@ -0,0 +1,41 @@
+package agents
+
+import (
+	"fmt"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+// LabelsFromDescription flattens the identifying and non-identifying
+// attributes of an AgentDescription into a map. Identifying attributes take
+// precedence if a key appears in both.
+func LabelsFromDescription(desc *protobufs.AgentDescription) map[string]string {
+	labels := make(map[string]string)
+	if desc == nil {
+		return labels
+	}
+	for _, kv := range desc.GetNonIdentifyingAttributes() {
+		labels[kv.GetKey()] = anyValueString(kv.GetValue())
+	}
+	for _, kv := range desc.GetIdentifyingAttributes() {
+		labels[kv.GetKey()] = anyValueString(kv.GetValue())
+	}
+	return labels
+}
+
+func anyValueString(v *protobufs.AnyValue) string {
+	switch value := v.GetValue().(type) {
+	case *protobufs.AnyValue_StringValue:
+		return value.StringValue
+	case *protobufs.AnyValue_IntValue:
+		return fmt.Sprintf("%d", value.IntValue)
+	case *protobufs.AnyValue_DoubleValue:
+		return fmt.Sprintf("%g", value.DoubleValue)
+	case *protobufs.AnyValue_BoolValue:
+		return fmt.Sprintf("%t", value.BoolValue)
+	case *protobufs.AnyValue_BytesValue:
+		return fmt.Sprintf("%x", value.BytesValue)
+	default:
+		return ""
+	}
+}
@@ -1,9 +1,21 @@
 package agents
 
 import (
+	"bytes"
 	"fmt"
-	"log"
+	"opamp-backend/internal/logging"
 	"sync"
+	"time"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+var logger = logging.For(logging.SubsystemAgents)
+
+// Transports over which an agent can be connected.
+const (
+	TransportWebSocket = "websocket"
+	TransportHTTP      = "http"
 )
 
 // Agent represents an agent and its configuration, along with its connection.
@@ -14,18 +26,52 @@ type Agent struct {
 	Config          string      // Stores complete configuration
 	EffectiveConfig string      // Stores what the agent reports as its active config
 	Conn            interface{} // Stores the agent's connection
+	Transport       string      // TransportWebSocket or TransportHTTP
+	AuthIdentity    string      // Credential the agent authenticated with
+
+	// Identity from a verified client certificate, if one was presented
+	ClientCertSubject string
+	ClientCertSANs    []string
+
+	Labels      map[string]string // Attributes from the agent's AgentDescription
+	Description *protobufs.AgentDescription
+	Health      *protobufs.ComponentHealth
+	Pending     bool      // Awaiting enrollment approval; receives no configuration
+	LastSeen    time.Time // When the agent last sent a message
+	SequenceNum uint64    // Sequence number of the agent's last message
+	// Capabilities holds the AgentCapabilities bits the agent advertised
+	Capabilities uint64
+	// PackageStatuses are the package statuses the agent last reported
+	PackageStatuses *protobufs.PackageStatuses
+	// CustomCapabilities are the custom capabilities the agent declared
+	CustomCapabilities []string
+	// AvailableComponents are the components the agent's binary includes
+	AvailableComponents *protobufs.AvailableComponents
+
+	sequenceSeen bool
+	// AllPackagesHash of the PackagesAvailable last sent to the agent
+	offeredPackagesHash []byte
+
+	// Message waiting for the next poll of an agent connected over plain HTTP
+	queued *protobufs.ServerToAgent
+	// Custom messages last received from the agent
+	customMessages []CustomMessage
 }
 
 // Manager handles agent registration and information.
 type Manager struct {
-	mu     sync.RWMutex
-	agents map[string]*Agent
+	mu               sync.RWMutex
+	agents           map[string]*Agent
+	restarts         map[string]*Restart
+	connectionOffers map[string]*ConnectionOffer
 }
 
 // NewManager creates a new agent manager.
 func NewManager() *Manager {
 	return &Manager{
-		agents: make(map[string]*Agent),
+		agents:           make(map[string]*Agent),
+		restarts:         make(map[string]*Restart),
+		connectionOffers: make(map[string]*ConnectionOffer),
 	}
 }
 
@@ -35,11 +81,12 @@ func (m *Manager) RegisterAgent(agent *Agent) {
 	defer m.mu.Unlock()
 
 	if _, exists := m.agents[agent.ID]; exists {
-		log.Printf("Replacing existing agent with ID: %s", agent.ID)
+		logger.Info("Replacing existing agent", "agent_id", agent.ID)
 		// We could implement additional cleanup for the existing agent if needed
 	}
 
 	m.agents[agent.ID] = agent
+	m.observeConnection(agent)
 }
 
 // DeregisterAgent removes an agent.
@@ -50,12 +97,13 @@ func (m *Manager) DeregisterAgent(agentID string) bool {
 
 	if _, exists := m.agents[agentID]; !exists {
 		// Log if agent doesn't exist
-		log.Printf("Attempted to deregister non-existent agent: %s", agentID)
+		logger.Debug("Attempted to deregister non-existent agent", "agent_id", agentID)
 		return false
 	}
 
 	delete(m.agents, agentID)
-	log.Printf("Agent deregistered: %s", agentID)
+	m.markDisconnected(agentID)
+	logger.Info("Agent deregistered", "agent_id", agentID)
 	return true
 }
 
@@ -95,6 +143,204 @@ func (m *Manager) UpdateAgentEffectiveConfig(agentID string, config string) erro
 	return nil
 }
 
+// UpdateAgentDescription stores an agent's AgentDescription and the labels
+// derived from it.
+func (m *Manager) UpdateAgentDescription(agentID string, desc *protobufs.AgentDescription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return fmt.Errorf("agent %s not found", agentID)
+	}
+
+	agent.Description = desc
+	agent.Labels = LabelsFromDescription(desc)
+	return nil
+}
+
+// UpdateAgentCapabilities stores the AgentCapabilities an agent advertised.
+func (m *Manager) UpdateAgentCapabilities(agentID string, capabilities uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return fmt.Errorf("agent %s not found", agentID)
+	}
+
+	agent.Capabilities = capabilities
+	return nil
+}
+
+// UpdateAgentHealth stores the health an agent reported.
+func (m *Manager) UpdateAgentHealth(agentID string, health *protobufs.ComponentHealth) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return fmt.Errorf("agent %s not found", agentID)
+	}
+
+	agent.Health = health
+	m.verifyOwnTelemetry(agentID, health)
+	return nil
+}
+
+// UpdateAgentPackageStatuses stores the package statuses an agent reported.
+func (m *Manager) UpdateAgentPackageStatuses(agentID string, statuses *protobufs.PackageStatuses) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return fmt.Errorf("agent %s not found", agentID)
+	}
+
+	agent.PackageStatuses = statuses
+	return nil
+}
+
+// NeedsPackages reports whether an agent neither has nor was already
+// offered the packages with allPackagesHash.
+func (m *Manager) NeedsPackages(agentID string, allPackagesHash []byte) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return false
+	}
+	return !bytes.Equal(agent.offeredPackagesHash, allPackagesHash) &&
+		!bytes.Equal(agent.PackageStatuses.GetServerProvidedAllPackagesHash(), allPackagesHash)
+}
+
+// SetOfferedPackages records that the packages with allPackagesHash were sent
+// to an agent.
+func (m *Manager) SetOfferedPackages(agentID string, allPackagesHash []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if agent, exists := m.agents[agentID]; exists {
+		agent.offeredPackagesHash = allPackagesHash
+	}
+}
+
+// Reasons for requesting an agent's full state.
+const (
+	FullStateUnknownAgent = "unknown_agent"
+	FullStateSequenceGap  = "sequence_gap"
+)
+
+// ObserveSequenceNum records the sequence number of an agent's message. It
+// returns why the agent must report its full state, or "" if the message
+// follows the previous one. A first message numbered 0 is a fresh start and
+// already carries the full state.
+func (m *Manager) ObserveSequenceNum(agentID string, seq uint64) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return FullStateUnknownAgent
+	}
+
+	reason := ""
+	switch {
+	case !agent.sequenceSeen && seq != 0:
+		reason = FullStateUnknownAgent
+	case agent.sequenceSeen && seq != agent.SequenceNum+1:
+		reason = FullStateSequenceGap
+	}
+	agent.SequenceNum = seq
+	agent.sequenceSeen = true
+	return reason
+}
+
+// SetAgentPending records whether an agent is awaiting enrollment approval.
+func (m *Manager) SetAgentPending(agentID string, pending bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return fmt.Errorf("agent %s not found", agentID)
+	}
+
+	agent.Pending = pending
+	return nil
+}
+
+// TouchAgent records a message from an existing agent on conn. It returns
+// false if the agent is not registered.
+func (m *Manager) TouchAgent(agentID string, conn interface{}) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return false
+	}
+	agent.Conn = conn
+	agent.LastSeen = time.Now()
+	return true
+}
+
+// QueueMessage holds a message for an agent until it next polls, merging it
+// into any message already waiting. It returns ErrCustomMessagePending if
+// both carry a custom message.
+func (m *Manager) QueueMessage(agentID string, message *protobufs.ServerToAgent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return fmt.Errorf("agent %s not found", agentID)
+	}
+	if agent.queued == nil {
+		agent.queued = &protobufs.ServerToAgent{}
+	}
+	// Only one custom message fits in a response
+	if agent.queued.CustomMessage != nil && message.CustomMessage != nil {
+		return ErrCustomMessagePending
+	}
+	MergeServerToAgent(agent.queued, message)
+	return nil
+}
+
+// TakeQueuedMessage returns and clears the message waiting for an agent, or
+// nil if there is none.
+func (m *Manager) TakeQueuedMessage(agentID string) *protobufs.ServerToAgent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return nil
+	}
+	message := agent.queued
+	agent.queued = nil
+	return message
+}
+
+// RemoveIdleAgents deregisters agents on transport that have not sent a
+// message since before, returning their IDs.
+func (m *Manager) RemoveIdleAgents(transport string, before time.Time) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var removed []string
+	for id, agent := range m.agents {
+		if agent.Transport == transport && agent.LastSeen.Before(before) {
+			delete(m.agents, id)
+			m.markDisconnected(id)
+			removed = append(removed, id)
+		}
+	}
+	return removed
+}
+
 // GetAllAgents returns a slice of all registered agents.
 func (m *Manager) GetAllAgents() []*Agent {
 	m.mu.RLock()
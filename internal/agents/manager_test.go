@@ -0,0 +1,35 @@
+package agents
+
+import "testing"
+
+func TestObserveSequenceNum(t *testing.T) {
+	tests := []struct {
+		name string
+		seqs []uint64 // messages before the one checked
+		seq  uint64
+		want string
+	}{
+		{name: "first message from zero", seq: 0},
+		{name: "first message continuing a session", seq: 5, want: FullStateUnknownAgent},
+		{name: "next message", seqs: []uint64{0, 1}, seq: 2},
+		{name: "missed message", seqs: []uint64{0, 1}, seq: 3, want: FullStateSequenceGap},
+		{name: "repeated message", seqs: []uint64{0, 1}, seq: 1, want: FullStateSequenceGap},
+		{name: "after a gap was reported", seqs: []uint64{0, 7}, seq: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager()
+			m.RegisterAgent(&Agent{ID: "a"})
+			for _, seq := range tt.seqs {
+				m.ObserveSequenceNum("a", seq)
+			}
+			if got := m.ObserveSequenceNum("a", tt.seq); got != tt.want {
+				t.Errorf("ObserveSequenceNum(%d) = %q, want %q", tt.seq, got, tt.want)
+			}
+		})
+	}
+
+	if got := NewManager().ObserveSequenceNum("unknown", 0); got != FullStateUnknownAgent {
+		t.Errorf("Expected an unregistered agent to need its full state, got %q", got)
+	}
+}
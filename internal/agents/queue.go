@@ -0,0 +1,28 @@
+package agents
+
+import (
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// MergeServerToAgent folds src into dst so that a single response carries
+// both. Fields set in src replace those in dst, except that capability and
+// flag bits accumulate. The InstanceUid of dst is kept.
+func MergeServerToAgent(dst, src *protobufs.ServerToAgent) {
+	if src == nil {
+		return
+	}
+	capabilities := dst.Capabilities | src.Capabilities
+	flags := dst.Flags | src.Flags
+	instanceUid := dst.InstanceUid
+
+	d := dst.ProtoReflect()
+	src.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		d.Set(fd, v)
+		return true
+	})
+
+	dst.Capabilities = capabilities
+	dst.Flags = flags
+	dst.InstanceUid = instanceUid
+}
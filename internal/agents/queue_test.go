@@ -0,0 +1,52 @@
+package agents
+
+import (
+	"testing"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMergeServerToAgent(t *testing.T) {
+	restart := &protobufs.ServerToAgentCommand{Type: protobufs.CommandType_CommandType_Restart}
+	remoteConfig := &protobufs.AgentRemoteConfig{ConfigHash: []byte("new")}
+
+	tests := []struct {
+		name string
+		dst  *protobufs.ServerToAgent
+		src  *protobufs.ServerToAgent
+		want *protobufs.ServerToAgent
+	}{
+		{
+			name: "nil source",
+			dst:  &protobufs.ServerToAgent{InstanceUid: []byte("a"), Flags: 1},
+			want: &protobufs.ServerToAgent{InstanceUid: []byte("a"), Flags: 1},
+		},
+		{
+			name: "fields are added",
+			dst:  &protobufs.ServerToAgent{InstanceUid: []byte("a")},
+			src:  &protobufs.ServerToAgent{Command: restart},
+			want: &protobufs.ServerToAgent{InstanceUid: []byte("a"), Command: restart},
+		},
+		{
+			name: "fields are replaced",
+			dst:  &protobufs.ServerToAgent{RemoteConfig: &protobufs.AgentRemoteConfig{ConfigHash: []byte("old")}},
+			src:  &protobufs.ServerToAgent{RemoteConfig: remoteConfig},
+			want: &protobufs.ServerToAgent{RemoteConfig: remoteConfig},
+		},
+		{
+			name: "bits accumulate and the instance UID is kept",
+			dst:  &protobufs.ServerToAgent{InstanceUid: []byte("a"), Capabilities: 1, Flags: 1},
+			src:  &protobufs.ServerToAgent{InstanceUid: []byte("b"), Capabilities: 4, Flags: 2},
+			want: &protobufs.ServerToAgent{InstanceUid: []byte("a"), Capabilities: 5, Flags: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			MergeServerToAgent(tt.dst, tt.src)
+			if !proto.Equal(tt.dst, tt.want) {
+				t.Errorf("MergeServerToAgent() = %v, want %v", tt.dst, tt.want)
+			}
+		})
+	}
+}
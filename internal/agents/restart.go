@@ -0,0 +1,108 @@
+package agents
+
+import "time"
+
+// States of a restart requested with the restart command.
+const (
+	RestartRequested    = "requested"    // the command was sent and the agent has not gone away yet
+	RestartDisconnected = "disconnected" // the agent went away after the command
+	RestartCompleted    = "completed"    // the agent came back
+	RestartTimedOut     = "timed_out"    // the agent did not come back within RestartTimeout
+)
+
+// RestartTimeout is how long an agent has to come back after a restart
+// command before the restart is reported as timed out.
+const RestartTimeout = 5 * time.Minute
+
+// Restart tracks a restart command sent to an agent. It outlives the agent
+// record, which is removed while the agent is disconnected.
+type Restart struct {
+	AgentID        string     `json:"agent_id"`
+	State          string     `json:"state"`
+	RequestedAt    time.Time  `json:"requested_at"`
+	DisconnectedAt *time.Time `json:"disconnected_at,omitempty"`
+	ReconnectedAt  *time.Time `json:"reconnected_at,omitempty"`
+}
+
+// StartRestart records that a restart command is being sent to an agent,
+// replacing any earlier restart of it.
+func (m *Manager) StartRestart(agentID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.restarts[agentID] = &Restart{AgentID: agentID, State: RestartRequested, RequestedAt: time.Now()}
+}
+
+// CancelRestart forgets the restart of an agent whose command could not be
+// sent.
+func (m *Manager) CancelRestart(agentID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.restarts, agentID)
+}
+
+// ObserveRestart completes the restart of an agent that sent a message after
+// going away, or that started counting its sequence numbers from zero again.
+// It returns true if a restart was completed.
+func (m *Manager) ObserveRestart(agentID string, seq uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	restart, exists := m.restarts[agentID]
+	if !exists || restart.State == RestartCompleted {
+		return false
+	}
+	if restart.State == RestartRequested && seq != 0 {
+		return false
+	}
+	now := time.Now()
+	if restart.DisconnectedAt == nil {
+		restart.DisconnectedAt = &now
+	}
+	restart.ReconnectedAt = &now
+	restart.State = RestartCompleted
+	logger.Info("Agent came back after restart", "agent_id", agentID, "downtime", now.Sub(*restart.DisconnectedAt))
+	return true
+}
+
+// GetRestart returns the last restart requested for an agent.
+func (m *Manager) GetRestart(agentID string) (Restart, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	restart, exists := m.restarts[agentID]
+	if !exists {
+		return Restart{}, false
+	}
+	return restart.at(time.Now()), true
+}
+
+// Restarts returns the last restart requested for every agent.
+func (m *Manager) Restarts() []Restart {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	restarts := make([]Restart, 0, len(m.restarts))
+	for _, restart := range m.restarts {
+		restarts = append(restarts, restart.at(now))
+	}
+	return restarts
+}
+
+// at returns a copy of the restart as seen at now.
+func (r *Restart) at(now time.Time) Restart {
+	restart := *r
+	if restart.State != RestartCompleted && now.Sub(restart.RequestedAt) > RestartTimeout {
+		restart.State = RestartTimedOut
+	}
+	return restart
+}
+
+// markDisconnected records that an agent went away during its restart. The
+// caller must hold m.mu.
+func (m *Manager) markDisconnected(agentID string) {
+	if restart, exists := m.restarts[agentID]; exists && restart.State == RestartRequested {
+		now := time.Now()
+		restart.DisconnectedAt = &now
+		restart.State = RestartDisconnected
+	}
+}
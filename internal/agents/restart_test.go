@@ -0,0 +1,46 @@
+package agents
+
+import "testing"
+
+func TestObserveRestart(t *testing.T) {
+	tests := []struct {
+		name         string
+		disconnected bool // the agent went away after the command
+		seq          uint64
+		want         bool
+	}{
+		{name: "still connected", seq: 12, want: false},
+		{name: "sequence restarted while connected", seq: 0, want: true},
+		{name: "reconnected after going away", disconnected: true, seq: 13, want: true},
+		{name: "reconnected from zero", disconnected: true, seq: 0, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager()
+			m.RegisterAgent(&Agent{ID: "a"})
+			m.StartRestart("a")
+			if tt.disconnected {
+				m.DeregisterAgent("a")
+			}
+
+			if got := m.ObserveRestart("a", tt.seq); got != tt.want {
+				t.Errorf("ObserveRestart(%d) = %v, want %v", tt.seq, got, tt.want)
+			}
+			restart, _ := m.GetRestart("a")
+			if tt.want && (restart.State != RestartCompleted || restart.ReconnectedAt == nil || restart.DisconnectedAt == nil) {
+				t.Errorf("Expected a completed restart, got %+v", restart)
+			}
+			if !tt.want && restart.State != RestartRequested {
+				t.Errorf("Expected the restart to stay requested, got %+v", restart)
+			}
+			// A completed restart is not completed again
+			if tt.want && m.ObserveRestart("a", 1) {
+				t.Error("Expected a completed restart to be ignored")
+			}
+		})
+	}
+
+	if NewManager().ObserveRestart("a", 0) {
+		t.Error("Expected no restart for an agent that was not restarted")
+	}
+}
@@ -0,0 +1,32 @@
+package agents
+
+import "slices"
+
+// Selector picks agents by ID or by the labels from their AgentDescription.
+// An empty selector matches every agent; otherwise an agent must be listed in
+// IDs (if set) and carry every label in Labels (if set).
+type Selector struct {
+	IDs    []string          `yaml:"ids" json:"ids,omitempty"`
+	Labels map[string]string `yaml:"labels" json:"labels,omitempty"`
+}
+
+// IsEmpty reports whether the selector matches every agent.
+func (s Selector) IsEmpty() bool {
+	return len(s.IDs) == 0 && len(s.Labels) == 0
+}
+
+// Matches reports whether the agent is selected.
+func (s Selector) Matches(agent *Agent) bool {
+	if agent == nil {
+		return false
+	}
+	if len(s.IDs) > 0 && !slices.Contains(s.IDs, agent.ID) {
+		return false
+	}
+	for key, value := range s.Labels {
+		if agent.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
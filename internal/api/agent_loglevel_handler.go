@@ -2,9 +2,10 @@ package api
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"opamp-backend/internal/common"
+	"opamp-backend/internal/logging"
+	"opamp-backend/internal/middleware"
 )
 
 // AgentLogLevelUpdateRequest represents the request payload to update an agent's log level.
@@ -17,23 +18,24 @@ type AgentLogLevelUpdateRequest struct {
 
 func HandleAgentLogLevelUpdate() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Received agent log level update request")
+		ctx := r.Context()
 
 		var req AgentLogLevelUpdateRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			log.Printf("Failed to parse request body: %v", err)
+			logger.WarnContext(ctx, "Failed to parse request body", "operation", "agent_log_level", "error", err)
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
 
-		log.Printf("Agent log level update request for agent %s to level %s", req.AgentID, req.LogLevel)
+		ctx = logging.WithAttrs(ctx, "agent_id", req.AgentID, "operation", "agent_log_level")
+		logger.InfoContext(ctx, "Agent log level update requested", "log_level", req.LogLevel)
 
 		// Validate log level.
 		switch req.LogLevel {
 		case "debug", "info", "warn", "error":
 			// Valid log level.
 		default:
-			log.Printf("Invalid log level: %s", req.LogLevel)
+			logger.WarnContext(ctx, "Invalid log level", "log_level", req.LogLevel)
 			http.Error(w, "Invalid log level", http.StatusBadRequest)
 			return
 		}
@@ -41,11 +43,17 @@ func HandleAgentLogLevelUpdate() http.HandlerFunc {
 		// Get the server instance
 		srv := common.GetServerInstance()
 		if srv == nil {
-			log.Printf("Server not initialized")
+			logger.ErrorContext(ctx, "Server not initialized")
 			http.Error(w, "Server not initialized", http.StatusInternalServerError)
 			return
 		}
 
+		if agent, exists := srv.GetAgent(req.AgentID); exists && !middleware.AgentAllowed(ctx, agent) {
+			logger.WarnContext(ctx, "Caller may not change this agent")
+			http.Error(w, "Forbidden: agent is outside the token's agent selector", http.StatusForbidden)
+			return
+		}
+
 		// Update IP address and location information if available
 		// This requires modifying your Agent struct to include these fields
 		if req.IPAddress != "" || req.Location != "" {
@@ -54,26 +62,35 @@ func HandleAgentLogLevelUpdate() http.HandlerFunc {
 				if req.IPAddress != "" {
 					// If your Agent struct has an IP field:
 					agent.IP = req.IPAddress
-					log.Printf("Updated IP address for agent %s to %s", req.AgentID, req.IPAddress)
+					logger.InfoContext(ctx, "Updated agent IP address", "ip_address", req.IPAddress)
 				}
 				if req.Location != "" {
 					// If your Agent struct has a Location field:
 					agent.Location = req.Location
-					log.Printf("Updated location for agent %s to %s", req.AgentID, req.Location)
+					logger.InfoContext(ctx, "Updated agent location", "location", req.Location)
 				}
 			}
 		}
 
-		// Before calling UpdateAgentLogLevel:
-		log.Printf("Attempting to update log level for agent %s to %s", req.AgentID, req.LogLevel)
-		err := srv.UpdateAgentLogLevel(req.AgentID, req.LogLevel)
+		err := srv.UpdateAgentLogLevel(ctx, req.AgentID, req.LogLevel)
+		if result := pushResult(err); result == ResultUnsupported || result == ResultRejected {
+			logger.WarnContext(ctx, "Agent cannot apply the configuration", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]string{
+				"status":   result,
+				"agent_id": req.AgentID,
+				"message":  err.Error(),
+			})
+			return
+		}
 		if err != nil {
-			log.Printf("Failed to update agent log level: %v", err)
+			logger.ErrorContext(ctx, "Failed to update agent log level", "error", err)
 			http.Error(w, "Failed to update agent log level: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		log.Printf("Agent log level updated successfully for %s", req.AgentID)
+		logger.InfoContext(ctx, "Agent log level updated", "log_level", req.LogLevel)
 		w.WriteHeader(http.StatusOK)
 		response := map[string]string{
 			"status":    "success",
@@ -3,6 +3,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"opamp-backend/internal/agents"
@@ -13,7 +14,7 @@ import (
 // Mock server implementation
 type mockServerImpl struct{}
 
-func (m *mockServerImpl) UpdateAgentLogLevel(agentID string, logLevel string) error {
+func (m *mockServerImpl) UpdateAgentLogLevel(ctx context.Context, agentID string, logLevel string) error {
 	return nil // Just return success for tests
 }
 
@@ -61,3 +62,37 @@ func TestHandleAgentLogLevelUpdate_WithMetadata(t *testing.T) {
 		t.Errorf("expected response to contain 'Agent log level updated successfully', got %s", w.Body.String())
 	}
 }
+
+func TestHandleAgentLogLevelUpdate_Unsupported(t *testing.T) {
+	common.SetServerInstance(&mockUnsupportedServer{})
+	defer common.SetServerInstance(&mockServerImpl{})
+
+	payload := `{"agent_id": "incapable", "log_level": "warn"}`
+	req := httptest.NewRequest("PUT", "/api/agent/loglevel", bytes.NewBufferString(payload))
+	w := httptest.NewRecorder()
+	HandleAgentLogLevelUpdate()(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422, got %d", w.Code)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"status":"unsupported"`)) {
+		t.Errorf("expected an unsupported status, got %s", w.Body.String())
+	}
+}
+
+func TestHandleAgentLogLevelUpdate_MissingComponents(t *testing.T) {
+	common.SetServerInstance(&mockUnsupportedServer{})
+	defer common.SetServerInstance(&mockServerImpl{})
+
+	payload := `{"agent_id": "lacking", "log_level": "warn"}`
+	req := httptest.NewRequest("PUT", "/api/agent/loglevel", bytes.NewBufferString(payload))
+	w := httptest.NewRecorder()
+	HandleAgentLogLevelUpdate()(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422, got %d", w.Code)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"status":"rejected"`)) {
+		t.Errorf("expected a rejected status, got %s", w.Body.String())
+	}
+}
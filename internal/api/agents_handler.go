@@ -3,14 +3,65 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"opamp-backend/internal/agents"
 	"opamp-backend/internal/common"
+	"opamp-backend/internal/middleware"
+	"strings"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
 )
 
 // AgentInfo represents information about a connected agent.
 type AgentInfo struct {
-	AgentID   string `json:"agent_id"`
-	IPAddress string `json:"ip_address"`
-	Status    string `json:"status"`
+	AgentID           string            `json:"agent_id"`
+	IPAddress         string            `json:"ip_address"`
+	Status            string            `json:"status"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	ClientCertSubject string            `json:"client_cert_subject,omitempty"`
+	ClientCertSANs    []string          `json:"client_cert_sans,omitempty"`
+	Health            *AgentHealth      `json:"health,omitempty"`
+	SequenceNum       uint64            `json:"sequence_num"`
+	Capabilities      []string          `json:"capabilities,omitempty"`
+	// CustomCapabilities are the custom capabilities the agent declared
+	CustomCapabilities []string `json:"custom_capabilities,omitempty"`
+	// AvailableComponents are the component types the agent includes by kind
+	AvailableComponents map[string][]string    `json:"available_components,omitempty"`
+	Restart             *agents.Restart        `json:"restart,omitempty"` // the last restart requested
+	Packages            map[string]PackageInfo `json:"packages,omitempty"`
+	// ConnectionSettings are the connection settings last offered
+	ConnectionSettings *agents.ConnectionOffer `json:"connection_settings,omitempty"`
+}
+
+// PackageInfo is the status of a package as last reported by the agent.
+type PackageInfo struct {
+	Version        string `json:"version,omitempty"` // the version the agent has
+	OfferedVersion string `json:"offered_version,omitempty"`
+	Status         string `json:"status"` // e.g. Installed, Downloading or InstallFailed
+	Error          string `json:"error,omitempty"`
+}
+
+// packageInfos converts the package statuses an agent reported.
+func packageInfos(statuses *protobufs.PackageStatuses) map[string]PackageInfo {
+	if len(statuses.GetPackages()) == 0 {
+		return nil
+	}
+	infos := make(map[string]PackageInfo, len(statuses.Packages))
+	for name, status := range statuses.Packages {
+		infos[name] = PackageInfo{
+			Version:        status.AgentHasVersion,
+			OfferedVersion: status.ServerOfferedVersion,
+			Status:         strings.TrimPrefix(status.Status.String(), "PackageStatusEnum_"),
+			Error:          status.ErrorMessage,
+		}
+	}
+	return infos
+}
+
+// AgentHealth is the health an agent last reported.
+type AgentHealth struct {
+	Healthy   bool   `json:"healthy"`
+	Status    string `json:"status,omitempty"`
+	LastError string `json:"last_error,omitempty"`
 }
 
 // HandleListAgents returns a list of connected agents.
@@ -25,23 +76,70 @@ func HandleListAgents() http.HandlerFunc {
 
 		// Get actual agents from the agent manager
 		allAgents := srv.GetAllAgents()
+		restarts := make(map[string]agents.Restart)
+		if restartSrv, ok := srv.(RestartServer); ok {
+			for _, restart := range restartSrv.ListRestarts() {
+				restarts[restart.AgentID] = restart
+			}
+		}
+		connectionOffers := make(map[string]agents.ConnectionOffer)
+		if offerSrv, ok := srv.(ConnectionSettingsServer); ok {
+			for _, offer := range offerSrv.ListConnectionOffers() {
+				connectionOffers[offer.AgentID] = offer
+			}
+		}
 
 		// Convert to AgentInfo objects for the response
-		agents := make([]AgentInfo, 0, len(allAgents))
+		agentInfos := make([]AgentInfo, 0, len(allAgents))
 		for _, agent := range allAgents {
-			agents = append(agents, AgentInfo{
-				AgentID:   agent.ID, // Use the exact ID as stored
-				IPAddress: agent.IP,
-				Status:    "active",
+			// Tokens restricted to some agents only see those agents
+			if !middleware.AgentAllowed(r.Context(), agent) {
+				continue
+			}
+			status := "active"
+			if agent.Pending {
+				status = "pending"
+			}
+			var health *AgentHealth
+			if agent.Health != nil {
+				health = &AgentHealth{
+					Healthy:   agent.Health.Healthy,
+					Status:    agent.Health.Status,
+					LastError: agent.Health.LastError,
+				}
+			}
+			var restart *agents.Restart
+			if last, ok := restarts[agent.ID]; ok {
+				restart = &last
+			}
+			var connectionOffer *agents.ConnectionOffer
+			if offer, ok := connectionOffers[agent.ID]; ok {
+				connectionOffer = &offer
+			}
+			agentInfos = append(agentInfos, AgentInfo{
+				AgentID:             agent.ID, // Use the exact ID as stored
+				IPAddress:           agent.IP,
+				Status:              status,
+				Labels:              agent.Labels,
+				ClientCertSubject:   agent.ClientCertSubject,
+				ClientCertSANs:      agent.ClientCertSANs,
+				Health:              health,
+				SequenceNum:         agent.SequenceNum,
+				Capabilities:        agent.CapabilityNames(),
+				CustomCapabilities:  agent.CustomCapabilities,
+				AvailableComponents: agent.ComponentTypes(),
+				Restart:             restart,
+				Packages:            packageInfos(agent.PackageStatuses),
+				ConnectionSettings:  connectionOffer,
 			})
 		}
 
 		// If no agents found, return an empty array
-		if len(agents) == 0 {
-			agents = []AgentInfo{}
+		if len(agentInfos) == 0 {
+			agentInfos = []AgentInfo{}
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(agents)
+		json.NewEncoder(w).Encode(agentInfos)
 	}
 }
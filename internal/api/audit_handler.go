@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"opamp-backend/internal/audit"
+	"opamp-backend/internal/common"
+	"strconv"
+	"time"
+)
+
+// AuditServer is implemented by servers that keep an audit log.
+type AuditServer interface {
+	common.ServerInterface
+	QueryAudit(q audit.Query) ([]*audit.Entry, error)
+}
+
+// defaultAuditLimit is the number of entries returned when no limit is given.
+const defaultAuditLimit = 100
+
+// HandleAuditQuery returns audit log entries, newest first. Entries can be
+// filtered with the caller, agent_id, endpoint, since and until (RFC 3339)
+// query parameters; limit caps the number returned.
+func HandleAuditQuery() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := r.URL.Query()
+		q := audit.Query{
+			Caller:   params.Get("caller"),
+			AgentID:  params.Get("agent_id"),
+			Endpoint: params.Get("endpoint"),
+			Limit:    defaultAuditLimit,
+		}
+		var err error
+		if v := params.Get("since"); v != "" {
+			if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "Invalid since (expected RFC 3339)", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := params.Get("until"); v != "" {
+			if q.Until, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "Invalid until (expected RFC 3339)", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := params.Get("limit"); v != "" {
+			if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		srv, ok := common.GetServerInstance().(AuditServer)
+		if !ok {
+			http.Error(w, "Server not initialized", http.StatusInternalServerError)
+			return
+		}
+
+		entries, err := srv.QueryAudit(q)
+		if errors.Is(err, audit.ErrDisabled) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to query audit log", "operation", "audit_query", "error", err)
+			http.Error(w, "Failed to query audit log", http.StatusInternalServerError)
+			return
+		}
+		if entries == nil {
+			entries = []*audit.Entry{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"opamp-backend/internal/audit"
+	"opamp-backend/internal/common"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockAuditServer records the last query and answers it with err.
+type mockAuditServer struct {
+	stubServer
+	query audit.Query
+	err   error
+}
+
+func (m *mockAuditServer) QueryAudit(q audit.Query) ([]*audit.Entry, error) {
+	m.query = q
+	return nil, m.err
+}
+
+func TestHandleAuditQuery(t *testing.T) {
+	srv := &mockAuditServer{}
+	common.SetServerInstance(srv)
+	defer common.SetServerInstance(nil)
+
+	w := httptest.NewRecorder()
+	HandleAuditQuery()(w, httptest.NewRequest(http.MethodGet, "/api/audit?caller=ci&agent_id=a&since=2026-01-02T03:04:05Z", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("expected an empty list, got %d: %s", w.Code, w.Body.String())
+	}
+	want := audit.Query{Caller: "ci", AgentID: "a", Since: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), Limit: defaultAuditLimit}
+	if srv.query != want {
+		t.Errorf("expected query %+v, got %+v", want, srv.query)
+	}
+}
+
+func TestHandleAuditQuery_Errors(t *testing.T) {
+	srv := &mockAuditServer{}
+	common.SetServerInstance(srv)
+	defer common.SetServerInstance(nil)
+
+	tests := []struct {
+		method string
+		target string
+		status int
+	}{
+		{http.MethodGet, "/api/audit?since=yesterday", http.StatusBadRequest},
+		{http.MethodGet, "/api/audit?until=2026-13-01", http.StatusBadRequest},
+		{http.MethodGet, "/api/audit?limit=0", http.StatusBadRequest},
+		{http.MethodPost, "/api/audit", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		HandleAuditQuery()(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.target, tt.status, w.Code)
+		}
+	}
+
+	srv.err = audit.ErrDisabled
+	w := httptest.NewRecorder()
+	HandleAuditQuery()(w, httptest.NewRequest(http.MethodGet, "/api/audit", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without an audit log, got %d", w.Code)
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/audit"
+	"opamp-backend/internal/common"
+	"opamp-backend/internal/issuer"
+	"opamp-backend/internal/logging"
+	"opamp-backend/internal/middleware"
+)
+
+// CertificateServer is implemented by servers that issue agent client certificates.
+type CertificateServer interface {
+	common.ServerInterface
+	ListCertificates(state string) []issuer.Record
+	ApproveCertificate(ctx context.Context, agentID string) (issuer.Record, error)
+	RejectCertificate(ctx context.Context, agentID string) (issuer.Record, error)
+}
+
+// CertificateDecisionRequest identifies the agent whose certificate request
+// is approved or rejected.
+type CertificateDecisionRequest struct {
+	AgentID string `json:"agent_id"`
+}
+
+// HandleListCertificates returns the certificate requests of agents and the
+// certificates issued to them, optionally filtered by the state query
+// parameter (pending, issued or rejected).
+func HandleListCertificates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		state := r.URL.Query().Get("state")
+		switch state {
+		case "", issuer.StatePending, issuer.StateIssued, issuer.StateRejected:
+		default:
+			http.Error(w, "Invalid state", http.StatusBadRequest)
+			return
+		}
+
+		srv, ok := common.GetServerInstance().(CertificateServer)
+		if !ok {
+			http.Error(w, "Server not initialized", http.StatusInternalServerError)
+			return
+		}
+
+		records := []issuer.Record{}
+		for _, record := range srv.ListCertificates(state) {
+			if certificateAgentAllowed(r.Context(), srv, record.AgentID) {
+				records = append(records, record)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+	}
+}
+
+// HandleApproveCertificate signs the pending certificate request of an agent.
+func HandleApproveCertificate() http.HandlerFunc {
+	return handleCertificateDecision(issuer.StateIssued, func(ctx context.Context, srv CertificateServer, agentID string) (issuer.Record, error) {
+		return srv.ApproveCertificate(ctx, agentID)
+	})
+}
+
+// HandleRejectCertificate rejects the pending certificate request of an agent.
+func HandleRejectCertificate() http.HandlerFunc {
+	return handleCertificateDecision(issuer.StateRejected, func(ctx context.Context, srv CertificateServer, agentID string) (issuer.Record, error) {
+		return srv.RejectCertificate(ctx, agentID)
+	})
+}
+
+func handleCertificateDecision(state string, decide func(context.Context, CertificateServer, string) (issuer.Record, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req CertificateDecisionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AgentID == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		ctx := logging.WithAttrs(r.Context(), "agent_id", req.AgentID, "operation", "certificates")
+
+		srv, ok := common.GetServerInstance().(CertificateServer)
+		if !ok {
+			http.Error(w, "Server not initialized", http.StatusInternalServerError)
+			return
+		}
+		if !certificateAgentAllowed(ctx, srv, req.AgentID) {
+			http.Error(w, "Forbidden: agent is outside the token's agent selector", http.StatusForbidden)
+			return
+		}
+
+		record, err := decide(ctx, srv, req.AgentID)
+		audit.AddTarget(ctx, req.AgentID, "", record.SerialNumber, err)
+		switch {
+		case errors.Is(err, issuer.ErrDisabled), errors.Is(err, issuer.ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			logger.WarnContext(ctx, "Certificate decision failed", "state", state, "error", err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(record)
+	}
+}
+
+// certificateAgentAllowed reports whether the caller may see and decide the
+// certificate requests of an agent. Agents that are not connected are
+// checked by ID only.
+func certificateAgentAllowed(ctx context.Context, srv common.ServerInterface, agentID string) bool {
+	agent, exists := srv.GetAgent(agentID)
+	if !exists {
+		agent = &agents.Agent{ID: agentID}
+	}
+	return middleware.AgentAllowed(ctx, agent)
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/common"
+	"opamp-backend/internal/issuer"
+	"testing"
+)
+
+// mockCertificateServer holds a pending request from agent a and an issued
+// certificate of agent b; agent c has not requested one.
+type mockCertificateServer struct {
+	stubServer
+	decided []string
+}
+
+func (m *mockCertificateServer) ListCertificates(state string) []issuer.Record {
+	records := []issuer.Record{
+		{AgentID: "a", State: issuer.StatePending},
+		{AgentID: "b", State: issuer.StateIssued, SerialNumber: "01"},
+	}
+	filtered := []issuer.Record{}
+	for _, record := range records {
+		if state == "" || record.State == state {
+			filtered = append(filtered, record)
+		}
+	}
+	return filtered
+}
+
+func (m *mockCertificateServer) ApproveCertificate(ctx context.Context, agentID string) (issuer.Record, error) {
+	return m.decide(agentID, issuer.StateIssued)
+}
+
+func (m *mockCertificateServer) RejectCertificate(ctx context.Context, agentID string) (issuer.Record, error) {
+	return m.decide(agentID, issuer.StateRejected)
+}
+
+func (m *mockCertificateServer) decide(agentID, state string) (issuer.Record, error) {
+	switch agentID {
+	case "a":
+		m.decided = append(m.decided, agentID)
+		return issuer.Record{AgentID: agentID, State: state}, nil
+	case "b":
+		return issuer.Record{}, errors.New("certificate request is not pending")
+	default:
+		return issuer.Record{}, issuer.ErrNotFound
+	}
+}
+
+func TestHandleCertificateDecisions(t *testing.T) {
+	srv := &mockCertificateServer{}
+	common.SetServerInstance(srv)
+	defer common.SetServerInstance(nil)
+
+	tests := []struct {
+		handler http.HandlerFunc
+		method  string
+		body    string
+		status  int
+	}{
+		{HandleApproveCertificate(), http.MethodPost, `{"agent_id": "a"}`, http.StatusOK},
+		{HandleRejectCertificate(), http.MethodPost, `{"agent_id": "a"}`, http.StatusOK},
+		{HandleApproveCertificate(), http.MethodPost, `{"agent_id": "b"}`, http.StatusConflict},
+		{HandleApproveCertificate(), http.MethodPost, `{"agent_id": "c"}`, http.StatusNotFound},
+		{HandleApproveCertificate(), http.MethodPost, `{}`, http.StatusBadRequest},
+		{HandleRejectCertificate(), http.MethodGet, ``, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.handler(w, httptest.NewRequest(tt.method, "/api/certificates/approve", bytes.NewBufferString(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.body, tt.status, w.Code)
+		}
+	}
+	if len(srv.decided) != 2 {
+		t.Errorf("expected two decisions, got %v", srv.decided)
+	}
+}
+
+func TestHandleListCertificates(t *testing.T) {
+	common.SetServerInstance(&mockCertificateServer{})
+	defer common.SetServerInstance(nil)
+
+	w := httptest.NewRecorder()
+	HandleListCertificates()(w, httptest.NewRequest(http.MethodGet, "/api/certificates?state=issued", nil))
+	var records []issuer.Record
+	if err := json.NewDecoder(w.Body).Decode(&records); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(records) != 1 || records[0].AgentID != "b" {
+		t.Errorf("expected the issued certificate of agent b, got %+v", records)
+	}
+
+	w = httptest.NewRecorder()
+	HandleListCertificates()(w, httptest.NewRequest(http.MethodGet, "/api/certificates?state=unknown", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown state, got %d", w.Code)
+	}
+}
+
+func TestHandleCertificates_Selector(t *testing.T) {
+	srv := &mockCertificateServer{}
+	common.SetServerInstance(srv)
+	defer common.SetServerInstance(nil)
+	selector := agents.Selector{IDs: []string{"b"}}
+
+	w := serveLimited(t, selector, HandleListCertificates(), httptest.NewRequest(http.MethodGet, "/api/certificates", nil))
+	var records []issuer.Record
+	if err := json.NewDecoder(w.Body).Decode(&records); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(records) != 1 || records[0].AgentID != "b" {
+		t.Errorf("expected only the records of agent b, got %+v", records)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/certificates/approve", bytes.NewBufferString(`{"agent_id": "a"}`))
+	if w := serveLimited(t, selector, HandleApproveCertificate(), req); w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 outside the selector, got %d", w.Code)
+	}
+	if len(srv.decided) != 0 {
+		t.Errorf("expected no decision, got %v", srv.decided)
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/common"
+	"opamp-backend/internal/middleware"
+	"sort"
+)
+
+// ConnectionSettingsServer is implemented by servers that offer connection settings to agents.
+type ConnectionSettingsServer interface {
+	common.ServerInterface
+	ListConnectionOffers() []agents.ConnectionOffer
+}
+
+// HandleListConnectionOffers lists the connection settings last offered to
+// each agent and whether it has reconnected since, optionally filtered by
+// the agent_id query parameter. Agents that moved to another backend stay
+// listed.
+func HandleListConnectionOffers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		srv, ok := common.GetServerInstance().(ConnectionSettingsServer)
+		if !ok {
+			http.Error(w, "Server not initialized", http.StatusInternalServerError)
+			return
+		}
+
+		agentID := r.URL.Query().Get("agent_id")
+		offers := []agents.ConnectionOffer{}
+		for _, offer := range srv.ListConnectionOffers() {
+			if agentID != "" && offer.AgentID != agentID {
+				continue
+			}
+			// Agents that are no longer connected are checked by ID only
+			agent, exists := srv.GetAgent(offer.AgentID)
+			if !exists {
+				agent = &agents.Agent{ID: offer.AgentID}
+			}
+			if middleware.AgentAllowed(r.Context(), agent) {
+				offers = append(offers, offer)
+			}
+		}
+		sort.Slice(offers, func(i, j int) bool { return offers[i].AgentID < offers[j].AgentID })
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(offers)
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/common"
+	"testing"
+)
+
+// mockConnectionSettingsServer has offered connection settings to agents a
+// and b.
+type mockConnectionSettingsServer struct {
+	stubServer
+}
+
+func (m *mockConnectionSettingsServer) ListConnectionOffers() []agents.ConnectionOffer {
+	return []agents.ConnectionOffer{{AgentID: "b", Hash: "2"}, {AgentID: "a", Hash: "1"}}
+}
+
+func TestHandleListConnectionOffers(t *testing.T) {
+	common.SetServerInstance(&mockConnectionSettingsServer{})
+	defer common.SetServerInstance(nil)
+
+	list := func(w *httptest.ResponseRecorder) []agents.ConnectionOffer {
+		t.Helper()
+		var offers []agents.ConnectionOffer
+		if err := json.NewDecoder(w.Body).Decode(&offers); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return offers
+	}
+
+	w := httptest.NewRecorder()
+	HandleListConnectionOffers()(w, httptest.NewRequest(http.MethodGet, "/api/connection-settings", nil))
+	if offers := list(w); len(offers) != 2 || offers[0].AgentID != "a" || offers[1].AgentID != "b" {
+		t.Errorf("expected both offers sorted by agent, got %+v", offers)
+	}
+
+	w = httptest.NewRecorder()
+	HandleListConnectionOffers()(w, httptest.NewRequest(http.MethodGet, "/api/connection-settings?agent_id=b", nil))
+	if offers := list(w); len(offers) != 1 || offers[0].Hash != "2" {
+		t.Errorf("expected the offer to agent b, got %+v", offers)
+	}
+
+	w = serveLimited(t, agents.Selector{IDs: []string{"a"}}, HandleListConnectionOffers(), httptest.NewRequest(http.MethodGet, "/api/connection-settings", nil))
+	if offers := list(w); len(offers) != 1 || offers[0].AgentID != "a" {
+		t.Errorf("expected only the offer to agent a, got %+v", offers)
+	}
+
+	w = httptest.NewRecorder()
+	HandleListConnectionOffers()(w, httptest.NewRequest(http.MethodPost, "/api/connection-settings", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", w.Code)
+	}
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/common"
+	"opamp-backend/internal/logging"
+	"opamp-backend/internal/middleware"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+// CustomMessageServer is implemented by servers that exchange custom messages with agents.
+type CustomMessageServer interface {
+	common.ServerInterface
+	SendCustomMessage(ctx context.Context, agentID string, message *protobufs.CustomMessage) error
+	CustomMessages(agentID string) ([]agents.CustomMessage, error)
+}
+
+// CustomMessageRequest sends a custom message to the agents selected by
+// agent_id, by the agents selector, or both. Without a selection it goes to
+// every agent that declared the capability. Data is base64 encoded.
+type CustomMessageRequest struct {
+	AgentID    string          `json:"agent_id"`
+	Agents     agents.Selector `json:"agents"`
+	Capability string          `json:"capability"`
+	Type       string          `json:"type"`
+	Data       []byte          `json:"data"`
+}
+
+// ResultSent is the status of agents a custom message was sent to.
+const ResultSent = "sent"
+
+// HandleCustomMessages sends a custom message to agents (POST) or lists the
+// custom messages last received from the agent in the agent_id query
+// parameter (GET).
+func HandleCustomMessages() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		srv, ok := common.GetServerInstance().(CustomMessageServer)
+		if !ok {
+			http.Error(w, "Server not initialized", http.StatusInternalServerError)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			listCustomMessages(w, r, srv)
+		case http.MethodPost:
+			sendCustomMessages(w, r, srv)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func listCustomMessages(w http.ResponseWriter, r *http.Request, srv CustomMessageServer) {
+	agentID := r.URL.Query().Get("agent_id")
+	if agentID == "" {
+		http.Error(w, "agent_id is required", http.StatusBadRequest)
+		return
+	}
+	agent, exists := srv.GetAgent(agentID)
+	if !exists || !middleware.AgentAllowed(r.Context(), agent) {
+		http.Error(w, "Agent not found", http.StatusNotFound)
+		return
+	}
+	messages, err := srv.CustomMessages(agentID)
+	if err != nil {
+		http.Error(w, "Agent not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"agent_id":            agentID,
+		"custom_capabilities": agent.CustomCapabilities,
+		"messages":            messages,
+	})
+}
+
+func sendCustomMessages(w http.ResponseWriter, r *http.Request, srv CustomMessageServer) {
+	var req CustomMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Capability == "" || req.Type == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	ctx := logging.WithAttrs(r.Context(), "operation", "custom_message", "capability", req.Capability)
+
+	selector := req.Agents
+	if req.AgentID != "" {
+		selector.IDs = append(selector.IDs, req.AgentID)
+	}
+
+	results := make(map[string]AgentResult)
+	sent := 0
+	for _, agent := range srv.GetAllAgents() {
+		if !selector.Matches(agent) || !middleware.AgentAllowed(ctx, agent) {
+			continue
+		}
+		// Agents selected explicitly are reported even if they lack the capability
+		if selector.IsEmpty() && !agent.HasCustomCapability(req.Capability) {
+			continue
+		}
+		err := srv.SendCustomMessage(ctx, agent.ID, &protobufs.CustomMessage{
+			Capability: req.Capability,
+			Type:       req.Type,
+			Data:       req.Data,
+		})
+		result := AgentResult{Status: pushResult(err)}
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Status = ResultSent
+			sent++
+		}
+		results[agent.ID] = result
+	}
+	if len(results) == 0 {
+		http.Error(w, "No matching agents", http.StatusNotFound)
+		return
+	}
+
+	logger.InfoContext(ctx, "Custom message sent", "selected_agents", len(results), "sent", sent)
+
+	w.Header().Set("Content-Type", "application/json")
+	if sent < len(results) {
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sent":    sent,
+		"results": results,
+	})
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/common"
+	"testing"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+// mockCustomMessageServer has one agent that declares the pprof capability
+// and one that does not.
+type mockCustomMessageServer struct {
+	stubServer
+	agents []*agents.Agent
+	sent   map[string]*protobufs.CustomMessage
+}
+
+func newMockCustomMessageServer() *mockCustomMessageServer {
+	return &mockCustomMessageServer{
+		agents: []*agents.Agent{
+			{ID: "declares", CustomCapabilities: []string{"io.opentelemetry.pprof"}},
+			{ID: "plain"},
+		},
+		sent: make(map[string]*protobufs.CustomMessage),
+	}
+}
+
+func (m *mockCustomMessageServer) GetAllAgents() []*agents.Agent {
+	return m.agents
+}
+
+func (m *mockCustomMessageServer) SendCustomMessage(ctx context.Context, agentID string, message *protobufs.CustomMessage) error {
+	for _, agent := range m.agents {
+		if agent.ID == agentID {
+			if err := agent.RequireCustomCapability(message.Capability); err != nil {
+				return err
+			}
+		}
+	}
+	m.sent[agentID] = message
+	return nil
+}
+
+func (m *mockCustomMessageServer) CustomMessages(agentID string) ([]agents.CustomMessage, error) {
+	return nil, nil
+}
+
+func TestHandleCustomMessages_Send(t *testing.T) {
+	srv := newMockCustomMessageServer()
+	common.SetServerInstance(srv)
+	defer common.SetServerInstance(&mockLogLevelServer{})
+
+	post := func(body string) (*httptest.ResponseRecorder, map[string]AgentResult) {
+		req := httptest.NewRequest(http.MethodPost, "/api/agents/custom-messages", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		HandleCustomMessages()(w, req)
+		var response struct {
+			Results map[string]AgentResult `json:"results"`
+		}
+		json.NewDecoder(w.Body).Decode(&response)
+		return w, response.Results
+	}
+
+	// Without a selection only agents that declared the capability are sent to
+	w, results := post(`{"capability": "io.opentelemetry.pprof", "type": "start", "data": "c2Vjb25kcz0zMA=="}`)
+	if w.Code != http.StatusOK || len(results) != 1 || results["declares"].Status != ResultSent {
+		t.Fatalf("expected the message to be sent to the declaring agent, got %d %v", w.Code, results)
+	}
+	if string(srv.sent["declares"].Data) != "seconds=30" {
+		t.Errorf("expected the decoded data to be sent, got %q", srv.sent["declares"].Data)
+	}
+
+	w, results = post(`{"agent_id": "plain", "capability": "io.opentelemetry.pprof", "type": "start"}`)
+	if w.Code != http.StatusPartialContent || results["plain"].Status != ResultUnsupported {
+		t.Errorf("expected the selected agent to be reported unsupported, got %d %v", w.Code, results)
+	}
+
+	if w, _ := post(`{"capability": "io.opentelemetry.pprof"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a message type, got %d", w.Code)
+	}
+	if w, _ := post(`{"capability": "com.example.unknown", "type": "x"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 when no agent declares the capability, got %d", w.Code)
+	}
+}
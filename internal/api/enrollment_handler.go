@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"opamp-backend/internal/audit"
+	"opamp-backend/internal/common"
+	"opamp-backend/internal/enrollment"
+	"opamp-backend/internal/logging"
+)
+
+// EnrollmentServer is implemented by servers that queue agents for enrollment approval.
+type EnrollmentServer interface {
+	common.ServerInterface
+	ListEnrollments(state string) []common.EnrollmentRecord
+	ApproveAgent(agentID string) (common.EnrollmentRecord, error)
+	RejectAgent(agentID string) (common.EnrollmentRecord, error)
+}
+
+// EnrollmentDecisionRequest identifies the agent to approve or reject.
+type EnrollmentDecisionRequest struct {
+	AgentID string `json:"agent_id"`
+}
+
+// HandleListEnrollments returns enrollment records, optionally filtered by
+// the state query parameter (pending, approved or rejected).
+func HandleListEnrollments() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		state := r.URL.Query().Get("state")
+		switch state {
+		case "", enrollment.StatePending, enrollment.StateApproved, enrollment.StateRejected:
+		default:
+			http.Error(w, "Invalid state", http.StatusBadRequest)
+			return
+		}
+
+		srv, ok := common.GetServerInstance().(EnrollmentServer)
+		if !ok {
+			http.Error(w, "Server not initialized", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(srv.ListEnrollments(state))
+	}
+}
+
+// HandleApproveEnrollment approves a pending agent.
+func HandleApproveEnrollment() http.HandlerFunc {
+	return handleEnrollmentDecision(enrollment.StateApproved, func(srv EnrollmentServer, agentID string) (common.EnrollmentRecord, error) {
+		return srv.ApproveAgent(agentID)
+	})
+}
+
+// HandleRejectEnrollment rejects an agent and disconnects it.
+func HandleRejectEnrollment() http.HandlerFunc {
+	return handleEnrollmentDecision(enrollment.StateRejected, func(srv EnrollmentServer, agentID string) (common.EnrollmentRecord, error) {
+		return srv.RejectAgent(agentID)
+	})
+}
+
+func handleEnrollmentDecision(state string, decide func(EnrollmentServer, string) (common.EnrollmentRecord, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req EnrollmentDecisionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AgentID == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		ctx := logging.WithAttrs(r.Context(), "agent_id", req.AgentID, "operation", "enrollment")
+
+		srv, ok := common.GetServerInstance().(EnrollmentServer)
+		if !ok {
+			http.Error(w, "Server not initialized", http.StatusInternalServerError)
+			return
+		}
+
+		record, err := decide(srv, req.AgentID)
+		audit.AddTarget(ctx, req.AgentID, "", "", err)
+		if err != nil {
+			logger.WarnContext(ctx, "Enrollment decision failed", "state", state, "error", err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		logger.InfoContext(ctx, "Enrollment decision recorded", "state", state)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(record)
+	}
+}
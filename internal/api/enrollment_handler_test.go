@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"opamp-backend/internal/common"
+	"opamp-backend/internal/enrollment"
+	"testing"
+)
+
+// mockEnrollmentServer applies every decision it is asked to make.
+type mockEnrollmentServer struct {
+	stubServer
+}
+
+func (m *mockEnrollmentServer) ListEnrollments(state string) []common.EnrollmentRecord {
+	return []common.EnrollmentRecord{}
+}
+
+func (m *mockEnrollmentServer) ApproveAgent(agentID string) (common.EnrollmentRecord, error) {
+	return common.EnrollmentRecord{AgentID: agentID, State: enrollment.StateApproved}, nil
+}
+
+func (m *mockEnrollmentServer) RejectAgent(agentID string) (common.EnrollmentRecord, error) {
+	return common.EnrollmentRecord{AgentID: agentID, State: enrollment.StateRejected}, nil
+}
+
+func TestHandleApproveEnrollment(t *testing.T) {
+	common.SetServerInstance(&mockEnrollmentServer{})
+
+	handler := HandleApproveEnrollment()
+	req := httptest.NewRequest("POST", "/api/enrollments/approve", bytes.NewBufferString(`{"agent_id": "agent-123"}`))
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	var record common.EnrollmentRecord
+	if err := json.NewDecoder(w.Body).Decode(&record); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if record.AgentID != "agent-123" || record.State != enrollment.StateApproved {
+		t.Errorf("unexpected record %+v", record)
+	}
+}
+
+func TestHandleEnrollments_InvalidRequests(t *testing.T) {
+	common.SetServerInstance(&mockEnrollmentServer{})
+
+	tests := []struct {
+		handler http.HandlerFunc
+		method  string
+		target  string
+		body    string
+		status  int
+	}{
+		{HandleRejectEnrollment(), "POST", "/api/enrollments/reject", `{}`, http.StatusBadRequest},
+		{HandleRejectEnrollment(), "GET", "/api/enrollments/reject", ``, http.StatusMethodNotAllowed},
+		{HandleListEnrollments(), "GET", "/api/enrollments?state=unknown", ``, http.StatusBadRequest},
+		{HandleListEnrollments(), "GET", "/api/enrollments?state=pending", ``, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, bytes.NewBufferString(tt.body))
+		w := httptest.NewRecorder()
+
+		tt.handler(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.target, tt.status, w.Code)
+		}
+	}
+}
@@ -6,16 +6,24 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"opamp-backend/internal/logging"
+	"opamp-backend/internal/middleware"
 
 	"gopkg.in/yaml.v2"
 )
 
+var logger = logging.For(logging.SubsystemAPI)
+
 // HandleConfigUpdate creates a handler function for updating agent configurations
 func HandleConfigUpdate() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var cfg map[string]interface{}
 
 		body, err := io.ReadAll(r.Body)
+		if middleware.IsBodyTooLarge(err) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
@@ -36,10 +44,11 @@ func HandleConfigUpdate() http.HandlerFunc {
 		configHash := sha256.Sum256(yamlConfig)
 
 		// Log the config that would be sent
-		fmt.Printf("Received configuration update with hash: %x\n", configHash[:])
-		fmt.Printf("Configuration content (YAML):\n%s\n", string(yamlConfig))
-
-		fmt.Printf("In a real implementation, this would be sent to OpAMP agents\n")
+		logger.InfoContext(r.Context(), "Received configuration update",
+			"operation", "config_update",
+			"config_hash", fmt.Sprintf("%x", configHash[:]),
+			"config_bytes", len(yamlConfig))
+		logger.DebugContext(r.Context(), "In a real implementation, this would be sent to OpAMP agents", "operation", "config_update")
 
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Configuration received successfully"))
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"opamp-backend/internal/common"
+)
+
+// ReadinessServer is implemented by servers that report readiness probes.
+type ReadinessServer interface {
+	common.ServerInterface
+	CheckReadiness() []common.ReadinessCheck
+}
+
+// HandleHealthz reports that the API process is alive. It does not inspect
+// the OpAMP listener; use HandleReadyz for that.
+func HandleHealthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}
+}
+
+// HandleReadyz reports whether the server is ready to manage agents. It
+// returns 503 if any readiness probe fails.
+func HandleReadyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		srv, ok := common.GetServerInstance().(ReadinessServer)
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status": "not_ready",
+				"checks": []common.ReadinessCheck{{Name: "server", Message: "Server not initialized"}},
+			})
+			return
+		}
+
+		checks := srv.CheckReadiness()
+		status := "ready"
+		code := http.StatusOK
+		for _, check := range checks {
+			if !check.Ready {
+				status = "not_ready"
+				code = http.StatusServiceUnavailable
+				break
+			}
+		}
+
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": status,
+			"checks": checks,
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"opamp-backend/internal/common"
+	"testing"
+)
+
+// Mock server that reports a configurable readiness state
+type mockReadinessServer struct {
+	stubServer
+	checks []common.ReadinessCheck
+}
+
+func (m *mockReadinessServer) CheckReadiness() []common.ReadinessCheck {
+	return m.checks
+}
+
+func TestHandleHealthz(t *testing.T) {
+	handler := HandleHealthz()
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+}
+
+func TestHandleReadyz_Ready(t *testing.T) {
+	common.SetServerInstance(&mockReadinessServer{checks: []common.ReadinessCheck{
+		{Name: "opamp_listener", Ready: true},
+	}})
+
+	handler := HandleReadyz()
+	req := httptest.NewRequest("GET", "/readyz", nil)
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"status":"ready"`)) {
+		t.Errorf("expected ready status, got %s", w.Body.String())
+	}
+}
+
+func TestHandleReadyz_NotReady(t *testing.T) {
+	common.SetServerInstance(&mockReadinessServer{checks: []common.ReadinessCheck{
+		{Name: "opamp_listener", Ready: true},
+		{Name: "opamp_restart", Ready: false, Message: "OpAMP server is restarting"},
+	}})
+
+	handler := HandleReadyz()
+	req := httptest.NewRequest("GET", "/readyz", nil)
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", w.Code)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"opamp_restart"`)) {
+		t.Errorf("expected failing check in response, got %s", w.Body.String())
+	}
+}
@@ -2,16 +2,41 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"opamp-backend/internal/agents"
 	"opamp-backend/internal/common"
+	"opamp-backend/internal/logging"
+	"opamp-backend/internal/middleware"
 )
 
 type LogLevelUpdateRequest struct {
 	LogLevel string `json:"log_level"`
 }
 
+// Per-agent outcomes of a push reported in API responses.
+const (
+	ResultUpdated     = "updated"
+	ResultUnsupported = "unsupported" // the agent did not advertise the capability
+	ResultRejected    = "rejected"    // the configuration uses components the agent lacks
+	ResultFailed      = "failed"
+)
+
+// pushResult classifies the error returned by a push to one agent.
+func pushResult(err error) string {
+	switch {
+	case err == nil:
+		return ResultUpdated
+	case errors.Is(err, agents.ErrUnsupported):
+		return ResultUnsupported
+	case errors.Is(err, agents.ErrMissingComponents):
+		return ResultRejected
+	default:
+		return ResultFailed
+	}
+}
+
 // GlobalLogLevel holds the global log level setting.
 // The default is "info".
 var GlobalLogLevel = "info"
@@ -19,23 +44,23 @@ var GlobalLogLevel = "info"
 // HandleLogLevelUpdate updates the global log level for the Observe Agent.
 func HandleLogLevelUpdate() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Received global log level update request")
+		ctx := logging.WithAttrs(r.Context(), "operation", "global_log_level")
 
 		var req LogLevelUpdateRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			log.Printf("Failed to parse request body: %v", err)
+			logger.WarnContext(ctx, "Failed to parse request body", "error", err)
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
 
-		log.Printf("Global log level update request to level %s", req.LogLevel)
+		logger.InfoContext(ctx, "Global log level update requested", "log_level", req.LogLevel)
 
 		// Only allow a fixed set of valid log levels.
 		switch req.LogLevel {
 		case "debug", "info", "warn", "error":
 			GlobalLogLevel = req.LogLevel
 		default:
-			log.Printf("Invalid log level: %s", req.LogLevel)
+			logger.WarnContext(ctx, "Invalid log level", "log_level", req.LogLevel)
 			http.Error(w, "Invalid log level", http.StatusBadRequest)
 			return
 		}
@@ -44,44 +69,60 @@ func HandleLogLevelUpdate() http.HandlerFunc {
 		srv := common.GetServerInstance()
 		if srv == nil {
 			// If server is not available, just update the global variable
-			log.Printf("Server not initialized, only updating global variable")
+			logger.WarnContext(ctx, "Server not initialized, only updating global variable")
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte("Global log level updated, but server is not available to update agents"))
 			return
 		}
 
-		// Update all connected agents
-		agentIDs := srv.GetAgentIDs()
-		log.Printf("Updating log level to %s for %d agents", req.LogLevel, len(agentIDs))
+		// Update all connected agents the caller may change
+		var agentIDs []string
+		for _, agentID := range srv.GetAgentIDs() {
+			if agent, _ := srv.GetAgent(agentID); middleware.AgentAllowed(ctx, agent) {
+				agentIDs = append(agentIDs, agentID)
+			}
+		}
+		logger.DebugContext(ctx, "Updating agents", "log_level", req.LogLevel, "total_agents", len(agentIDs))
 
 		updateErrors := 0
 		updatedAgents := 0
+		unsupportedAgents := 0
+		results := make(map[string]string, len(agentIDs))
 
 		for _, agentID := range agentIDs {
-			log.Printf("Updating agent %s to log level %s", agentID, req.LogLevel)
-			if err := srv.UpdateAgentLogLevel(agentID, req.LogLevel); err != nil {
-				// Log the error but continue updating other agents
-				log.Printf("Error updating agent %s: %v", agentID, err)
-				updateErrors++
-			} else {
+			// Log errors but continue updating other agents
+			err := srv.UpdateAgentLogLevel(ctx, agentID, req.LogLevel)
+			results[agentID] = pushResult(err)
+			switch results[agentID] {
+			case ResultUpdated:
 				updatedAgents++
+			case ResultUnsupported:
+				logger.WarnContext(ctx, "Agent does not accept remote configuration", "agent_id", agentID)
+				unsupportedAgents++
+			default:
+				logger.ErrorContext(ctx, "Error updating agent", "agent_id", agentID, "error", err)
+				updateErrors++
 			}
 		}
 
-		log.Printf("Updated %d agents, encountered %d errors", updatedAgents, updateErrors)
+		logger.InfoContext(ctx, "Global log level applied", "log_level", req.LogLevel, "updated_agents", updatedAgents,
+			"unsupported_agents", unsupportedAgents, "failed_updates", updateErrors)
 
 		// Prepare the response
 		response := map[string]interface{}{
-			"global_log_level": GlobalLogLevel,
-			"total_agents":     len(agentIDs),
-			"updated_agents":   updatedAgents,
-			"failed_updates":   updateErrors,
+			"global_log_level":   GlobalLogLevel,
+			"total_agents":       len(agentIDs),
+			"updated_agents":     updatedAgents,
+			"failed_updates":     updateErrors,
+			"unsupported_agents": unsupportedAgents,
+			"results":            results,
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		if updateErrors > 0 {
+		if updateErrors > 0 || unsupportedAgents > 0 {
 			w.WriteHeader(http.StatusPartialContent)
-			response["message"] = fmt.Sprintf("Global log level updated but failed to update %d agents", updateErrors)
+			response["message"] = fmt.Sprintf("Global log level updated but failed to update %d agents and %d agents do not accept remote configuration",
+				updateErrors, unsupportedAgents)
 		} else {
 			w.WriteHeader(http.StatusOK)
 			response["message"] = "Log level updated successfully for all agents"
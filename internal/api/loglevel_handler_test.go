@@ -3,6 +3,9 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"opamp-backend/internal/agents"
@@ -13,7 +16,7 @@ import (
 // Mock server implementation for tests
 type mockLogLevelServer struct{}
 
-func (m *mockLogLevelServer) UpdateAgentLogLevel(agentID string, logLevel string) error {
+func (m *mockLogLevelServer) UpdateAgentLogLevel(ctx context.Context, agentID string, logLevel string) error {
 	return nil
 }
 
@@ -74,3 +77,52 @@ func TestHandleLogLevelUpdate_Invalid(t *testing.T) {
 		t.Errorf("expected status 400, got %d", w.Code)
 	}
 }
+
+// mockUnsupportedServer has one agent that accepts remote config and one
+// that does not.
+type mockUnsupportedServer struct {
+	mockLogLevelServer
+}
+
+func (m *mockUnsupportedServer) GetAgentIDs() []string {
+	return []string{"capable", "incapable"}
+}
+
+func (m *mockUnsupportedServer) UpdateAgentLogLevel(ctx context.Context, agentID string, logLevel string) error {
+	if agentID == "incapable" {
+		return fmt.Errorf("agent %s does not accept RemoteConfig: %w", agentID, agents.ErrUnsupported)
+	}
+	if agentID == "lacking" {
+		return fmt.Errorf("agent %s lacks processors/batch: %w", agentID, agents.ErrMissingComponents)
+	}
+	return nil
+}
+
+func TestHandleLogLevelUpdate_Unsupported(t *testing.T) {
+	GlobalLogLevel = "info"
+	common.SetServerInstance(&mockUnsupportedServer{})
+	defer common.SetServerInstance(&mockLogLevelServer{})
+
+	req := httptest.NewRequest("PUT", "/api/loglevel", bytes.NewBufferString(`{"log_level": "warn"}`))
+	w := httptest.NewRecorder()
+	HandleLogLevelUpdate()(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("expected status 206, got %d", w.Code)
+	}
+	var response struct {
+		UpdatedAgents     int               `json:"updated_agents"`
+		UnsupportedAgents int               `json:"unsupported_agents"`
+		FailedUpdates     int               `json:"failed_updates"`
+		Results           map[string]string `json:"results"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.UpdatedAgents != 1 || response.UnsupportedAgents != 1 || response.FailedUpdates != 0 {
+		t.Errorf("unexpected counts: %+v", response)
+	}
+	if response.Results["capable"] != ResultUpdated || response.Results["incapable"] != ResultUnsupported {
+		t.Errorf("unexpected per-agent results: %v", response.Results)
+	}
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/common"
+	"opamp-backend/internal/logging"
+	"opamp-backend/internal/middleware"
+	"opamp-backend/internal/packages"
+	"os"
+)
+
+// PackageServer is implemented by servers that distribute packages to agents.
+type PackageServer interface {
+	common.ServerInterface
+	ListPackages() ([]packages.Package, []packages.Assignment, error)
+	AddPackage(name, version, packageType string, signature []byte, content io.Reader) (packages.Package, error)
+	PackageFile(name, version string, query url.Values) (packages.Package, string, error)
+	AssignPackage(ctx context.Context, name, version string, selector agents.Selector) (packages.Assignment, map[string]error, error)
+}
+
+// SignatureHeader carries the optional base64 encoded signature of an
+// uploaded package.
+const SignatureHeader = "X-Package-Signature"
+
+// PackageList is the response of GET /api/packages.
+type PackageList struct {
+	Packages    []packages.Package    `json:"packages"`
+	Assignments []packages.Assignment `json:"assignments"`
+}
+
+// AssignPackageRequest assigns a package version to a group of agents. An
+// empty version removes the assignment made to the same selector.
+type AssignPackageRequest struct {
+	Name    string          `json:"name"`
+	Version string          `json:"version"`
+	Agents  agents.Selector `json:"agents"`
+}
+
+// HandlePackages lists the package registry (GET) or uploads a package
+// version (POST with the file as body and name, version and optionally type
+// as query parameters).
+func HandlePackages() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		srv, ok := common.GetServerInstance().(PackageServer)
+		if !ok {
+			http.Error(w, "Server not initialized", http.StatusInternalServerError)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			list, assignments, err := srv.ListPackages()
+			if err != nil {
+				writePackageError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(PackageList{Packages: list, Assignments: assignments})
+		case http.MethodPost:
+			query := r.URL.Query()
+			var signature []byte
+			if header := r.Header.Get(SignatureHeader); header != "" {
+				var err error
+				if signature, err = base64.StdEncoding.DecodeString(header); err != nil {
+					http.Error(w, "Invalid "+SignatureHeader+" header", http.StatusBadRequest)
+					return
+				}
+			}
+			p, err := srv.AddPackage(query.Get("name"), query.Get("version"), query.Get("type"), signature, r.Body)
+			if middleware.IsBodyTooLarge(err) {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				writePackageError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(p)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// HandleAssignPackage assigns a package version to the agents matched by a
+// selector and sends the connected ones their packages.
+func HandleAssignPackage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req AssignPackageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		ctx := logging.WithAttrs(r.Context(), "operation", "packages", "package", req.Name)
+
+		// Assignments also apply to agents that connect later, so they cannot
+		// be limited to the agents a token may change
+		if p, ok := middleware.PrincipalFromContext(ctx); ok && !p.Agents.IsEmpty() {
+			http.Error(w, "Forbidden: assigning packages requires a token without an agent selector", http.StatusForbidden)
+			return
+		}
+
+		srv, ok := common.GetServerInstance().(PackageServer)
+		if !ok {
+			http.Error(w, "Server not initialized", http.StatusInternalServerError)
+			return
+		}
+
+		assignment, pushed, err := srv.AssignPackage(ctx, req.Name, req.Version, req.Agents)
+		if err != nil {
+			writePackageError(w, err)
+			return
+		}
+
+		results := make(map[string]AgentResult, len(pushed))
+		updated := 0
+		for agentID, err := range pushed {
+			result := AgentResult{Status: pushResult(err)}
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				updated++
+			}
+			results[agentID] = result
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if updated < len(results) {
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"assignment": assignment,
+			"updated":    updated,
+			"results":    results,
+		})
+	}
+}
+
+// HandlePackageDownload serves the file of a package version to agents
+// through the signed URL they were offered.
+func HandlePackageDownload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		srv, ok := common.GetServerInstance().(PackageServer)
+		if !ok {
+			http.Error(w, "Server not initialized", http.StatusInternalServerError)
+			return
+		}
+
+		p, path, err := srv.PackageFile(r.PathValue("name"), r.PathValue("version"), r.URL.Query())
+		if err != nil {
+			writePackageError(w, err)
+			return
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			logger.Error("Failed to open package file", "package", p.Name, "version", p.Version, "error", err)
+			http.Error(w, "Failed to read package", http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, p.Name+"-"+p.Version, p.UploadedAt, file)
+	}
+}
+
+func writePackageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, packages.ErrDisabled), errors.Is(err, packages.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, packages.ErrExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, packages.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, packages.ErrDenied):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		logger.Error("Package registry failed", "error", err)
+		http.Error(w, "Failed to update the package registry", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/common"
+	"opamp-backend/internal/packages"
+	"testing"
+)
+
+// mockPackageServer assigns packages to agents a and b; the push to b fails.
+type mockPackageServer struct {
+	stubServer
+	assigned bool
+}
+
+func (m *mockPackageServer) ListPackages() ([]packages.Package, []packages.Assignment, error) {
+	return nil, nil, packages.ErrDisabled
+}
+
+func (m *mockPackageServer) AddPackage(name, version, packageType string, signature []byte, content io.Reader) (packages.Package, error) {
+	return packages.Package{}, packages.ErrExists
+}
+
+func (m *mockPackageServer) PackageFile(name, version string, query url.Values) (packages.Package, string, error) {
+	return packages.Package{}, "", packages.ErrDenied
+}
+
+func (m *mockPackageServer) AssignPackage(ctx context.Context, name, version string, selector agents.Selector) (packages.Assignment, map[string]error, error) {
+	if name == "missing" {
+		return packages.Assignment{}, nil, packages.ErrNotFound
+	}
+	m.assigned = true
+	pushed := map[string]error{
+		"a": nil,
+		"b": fmt.Errorf("agent b does not accept packages: %w", agents.ErrUnsupported),
+	}
+	return packages.Assignment{Name: name, Version: version, Agents: selector}, pushed, nil
+}
+
+func TestWritePackageError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{packages.ErrDisabled, http.StatusNotFound},
+		{fmt.Errorf("collector 1.2.3: %w", packages.ErrNotFound), http.StatusNotFound},
+		{fmt.Errorf("collector 1.2.3: %w", packages.ErrExists), http.StatusConflict},
+		{fmt.Errorf("empty file: %w", packages.ErrInvalid), http.StatusBadRequest},
+		{fmt.Errorf("signature mismatch: %w", packages.ErrDenied), http.StatusForbidden},
+		{errors.New("disk full"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		writePackageError(w, tt.err)
+		if w.Code != tt.status {
+			t.Errorf("%v: expected status %d, got %d", tt.err, tt.status, w.Code)
+		}
+	}
+}
+
+func TestHandleAssignPackage_Partial(t *testing.T) {
+	common.SetServerInstance(&mockPackageServer{})
+	defer common.SetServerInstance(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/packages/assign", bytes.NewBufferString(`{"name": "collector", "version": "1.2.3"}`))
+	w := httptest.NewRecorder()
+	HandleAssignPackage()(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expected status 206, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Assignment packages.Assignment    `json:"assignment"`
+		Updated    int                    `json:"updated"`
+		Results    map[string]AgentResult `json:"results"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.Assignment.Version != "1.2.3" || response.Updated != 1 || response.Results["a"].Status != ResultUpdated || response.Results["b"].Status != ResultUnsupported {
+		t.Errorf("unexpected response %+v", response)
+	}
+}
+
+func TestHandleAssignPackage_SelectorToken(t *testing.T) {
+	srv := &mockPackageServer{}
+	common.SetServerInstance(srv)
+	defer common.SetServerInstance(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/packages/assign", bytes.NewBufferString(`{"name": "collector", "version": "1.2.3"}`))
+	w := serveLimited(t, agents.Selector{IDs: []string{"a"}}, HandleAssignPackage(), req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403, got %d", w.Code)
+	}
+	if srv.assigned {
+		t.Error("expected the assignment not to be made")
+	}
+}
+
+func TestHandlePackages_Errors(t *testing.T) {
+	common.SetServerInstance(&mockPackageServer{})
+	defer common.SetServerInstance(nil)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		req     *http.Request
+		status  int
+	}{
+		{"list without a registry", HandlePackages(), httptest.NewRequest(http.MethodGet, "/api/packages", nil), http.StatusNotFound},
+		{"existing version", HandlePackages(), httptest.NewRequest(http.MethodPost, "/api/packages?name=collector&version=1.2.3", bytes.NewBufferString("data")), http.StatusConflict},
+		{"unknown method", HandlePackages(), httptest.NewRequest(http.MethodDelete, "/api/packages", nil), http.StatusMethodNotAllowed},
+		{"unsigned download", HandlePackageDownload(), httptest.NewRequest(http.MethodGet, "/packages/collector/1.2.3", nil), http.StatusForbidden},
+		{"assign without a name", HandleAssignPackage(), httptest.NewRequest(http.MethodPost, "/api/packages/assign", bytes.NewBufferString(`{"version": "1.2.3"}`)), http.StatusBadRequest},
+		{"assign an unknown package", HandleAssignPackage(), httptest.NewRequest(http.MethodPost, "/api/packages/assign", bytes.NewBufferString(`{"name": "missing", "version": "1.2.3"}`)), http.StatusNotFound},
+		{"assign with GET", HandleAssignPackage(), httptest.NewRequest(http.MethodGet, "/api/packages/assign", nil), http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.handler(w, tt.req)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/packages?name=collector&version=1.2.3", bytes.NewBufferString("data"))
+	req.Header.Set(SignatureHeader, "not base64!")
+	w := httptest.NewRecorder()
+	HandlePackages()(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid signature header: expected status 400, got %d", w.Code)
+	}
+}
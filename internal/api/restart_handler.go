@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/common"
+	"opamp-backend/internal/logging"
+	"opamp-backend/internal/middleware"
+	"sort"
+)
+
+// RestartServer is implemented by servers that can restart agents.
+type RestartServer interface {
+	common.ServerInterface
+	RestartAgent(ctx context.Context, agentID string) error
+	ListRestarts() []agents.Restart
+}
+
+// RestartRequest selects the agents to restart by agent_id, by the agents
+// selector, or both.
+type RestartRequest struct {
+	AgentID string          `json:"agent_id"`
+	Agents  agents.Selector `json:"agents"`
+}
+
+// AgentResult is the outcome of a command sent to one agent.
+type AgentResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HandleRestartAgents sends the restart command to the selected agents (POST)
+// or lists the restarts requested so far and whether the agents came back
+// (GET, optionally filtered by agent_id).
+func HandleRestartAgents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		srv, ok := common.GetServerInstance().(RestartServer)
+		if !ok {
+			http.Error(w, "Server not initialized", http.StatusInternalServerError)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			listRestarts(w, r, srv)
+		case http.MethodPost:
+			restartAgents(w, r, srv)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func listRestarts(w http.ResponseWriter, r *http.Request, srv RestartServer) {
+	agentID := r.URL.Query().Get("agent_id")
+	restarts := []agents.Restart{}
+	for _, restart := range srv.ListRestarts() {
+		if agentID != "" && restart.AgentID != agentID {
+			continue
+		}
+		// Agents that are away during their restart are checked by ID only
+		agent, exists := srv.GetAgent(restart.AgentID)
+		if !exists {
+			agent = &agents.Agent{ID: restart.AgentID}
+		}
+		if middleware.AgentAllowed(r.Context(), agent) {
+			restarts = append(restarts, restart)
+		}
+	}
+	sort.Slice(restarts, func(i, j int) bool { return restarts[i].AgentID < restarts[j].AgentID })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restarts)
+}
+
+func restartAgents(w http.ResponseWriter, r *http.Request, srv RestartServer) {
+	ctx := logging.WithAttrs(r.Context(), "operation", "restart")
+
+	var req RestartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	selector := req.Agents
+	if req.AgentID != "" {
+		selector.IDs = append(selector.IDs, req.AgentID)
+	}
+	// Restarting the whole fleet must be asked for explicitly
+	if selector.IsEmpty() {
+		http.Error(w, "Select the agents to restart with agent_id or agents", http.StatusBadRequest)
+		return
+	}
+
+	results := make(map[string]AgentResult)
+	requested := 0
+	for _, agent := range srv.GetAllAgents() {
+		if !selector.Matches(agent) || !middleware.AgentAllowed(ctx, agent) {
+			continue
+		}
+		err := srv.RestartAgent(ctx, agent.ID)
+		result := AgentResult{Status: pushResult(err)}
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Status = agents.RestartRequested
+			requested++
+		}
+		results[agent.ID] = result
+	}
+	if len(results) == 0 {
+		http.Error(w, "No matching agents", http.StatusNotFound)
+		return
+	}
+
+	logger.InfoContext(ctx, "Restart requested", "selected_agents", len(results), "requested", requested)
+
+	w.Header().Set("Content-Type", "application/json")
+	if requested < len(results) {
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"requested": requested,
+		"results":   results,
+	})
+}
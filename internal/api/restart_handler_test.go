@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/common"
+	"testing"
+)
+
+// mockRestartServer has agents a and b; b does not accept the restart
+// command.
+type mockRestartServer struct {
+	stubServer
+	restarted []string
+}
+
+func (m *mockRestartServer) GetAllAgents() []*agents.Agent {
+	return []*agents.Agent{{ID: "a"}, {ID: "b"}}
+}
+
+func (m *mockRestartServer) GetAgent(agentID string) (*agents.Agent, bool) {
+	if agentID == "a" || agentID == "b" {
+		return &agents.Agent{ID: agentID}, true
+	}
+	return nil, false
+}
+
+func (m *mockRestartServer) RestartAgent(ctx context.Context, agentID string) error {
+	if agentID == "b" {
+		return fmt.Errorf("agent b does not accept restart commands: %w", agents.ErrUnsupported)
+	}
+	m.restarted = append(m.restarted, agentID)
+	return nil
+}
+
+func (m *mockRestartServer) ListRestarts() []agents.Restart {
+	return []agents.Restart{{AgentID: "b"}, {AgentID: "a"}, {AgentID: "gone"}}
+}
+
+func TestHandleRestartAgents_Partial(t *testing.T) {
+	srv := &mockRestartServer{}
+	common.SetServerInstance(srv)
+	defer common.SetServerInstance(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/agents/restart", bytes.NewBufferString(`{"agents": {"ids": ["a", "b"]}}`))
+	w := httptest.NewRecorder()
+	HandleRestartAgents()(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expected status 206, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Requested int                    `json:"requested"`
+		Results   map[string]AgentResult `json:"results"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.Requested != 1 || response.Results["a"].Status != agents.RestartRequested || response.Results["b"].Status != ResultUnsupported {
+		t.Errorf("unexpected response %+v", response)
+	}
+}
+
+func TestHandleRestartAgents_InvalidRequests(t *testing.T) {
+	common.SetServerInstance(&mockRestartServer{})
+	defer common.SetServerInstance(nil)
+
+	tests := []struct {
+		method string
+		body   string
+		status int
+	}{
+		{http.MethodPost, `not json`, http.StatusBadRequest},
+		{http.MethodPost, `{}`, http.StatusBadRequest}, // the whole fleet must be selected explicitly
+		{http.MethodPost, `{"agent_id": "unknown"}`, http.StatusNotFound},
+		{http.MethodDelete, ``, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		HandleRestartAgents()(w, httptest.NewRequest(tt.method, "/api/agents/restart", bytes.NewBufferString(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.body, tt.status, w.Code)
+		}
+	}
+}
+
+func TestHandleRestartAgents_Selector(t *testing.T) {
+	srv := &mockRestartServer{}
+	common.SetServerInstance(srv)
+	defer common.SetServerInstance(nil)
+	selector := agents.Selector{IDs: []string{"a"}}
+
+	// Agents outside the selector are neither restarted nor listed.
+	req := httptest.NewRequest(http.MethodPost, "/api/agents/restart", bytes.NewBufferString(`{"agents": {"ids": ["a", "b"]}}`))
+	if w := serveLimited(t, selector, HandleRestartAgents(), req); w.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(srv.restarted) != 1 || srv.restarted[0] != "a" {
+		t.Errorf("expected only agent a to be restarted, got %v", srv.restarted)
+	}
+
+	w := serveLimited(t, selector, HandleRestartAgents(), httptest.NewRequest(http.MethodGet, "/api/agents/restart", nil))
+	var restarts []agents.Restart
+	if err := json.NewDecoder(w.Body).Decode(&restarts); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(restarts) != 1 || restarts[0].AgentID != "a" {
+		t.Errorf("expected only the restart of agent a, got %+v", restarts)
+	}
+}
+
+func TestHandleRestartAgents_List(t *testing.T) {
+	common.SetServerInstance(&mockRestartServer{})
+	defer common.SetServerInstance(nil)
+
+	w := httptest.NewRecorder()
+	HandleRestartAgents()(w, httptest.NewRequest(http.MethodGet, "/api/agents/restart", nil))
+	var restarts []agents.Restart
+	if err := json.NewDecoder(w.Body).Decode(&restarts); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(restarts) != 3 || restarts[0].AgentID != "a" || restarts[1].AgentID != "b" || restarts[2].AgentID != "gone" {
+		t.Errorf("expected every restart sorted by agent, got %+v", restarts)
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"opamp-backend/internal/logging"
+)
+
+// ServerLogLevelRequest represents the request payload to change the backend's
+// own log level. An empty Subsystem applies the level to every subsystem.
+type ServerLogLevelRequest struct {
+	Subsystem string `json:"subsystem"`
+	LogLevel  string `json:"log_level"`
+}
+
+// HandleServerLogLevel reads (GET) or changes (PUT) the log level of the
+// backend's own subsystems at runtime. Unlike HandleLogLevelUpdate it does not
+// touch any agent.
+func HandleServerLogLevel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var req ServerLogLevelRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if _, err := logging.ParseLevel(req.LogLevel); err != nil || req.LogLevel == "" {
+				http.Error(w, "Invalid log level", http.StatusBadRequest)
+				return
+			}
+
+			subsystems := logging.Subsystems()
+			if req.Subsystem != "" {
+				subsystems = []string{req.Subsystem}
+			}
+			for _, subsystem := range subsystems {
+				if err := logging.SetLevel(subsystem, req.LogLevel); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+
+			logger.InfoContext(r.Context(), "Backend log level changed",
+				"operation", "server_log_level",
+				"subsystems", subsystems,
+				"log_level", req.LogLevel)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"levels": logging.Levels(),
+		})
+	}
+}
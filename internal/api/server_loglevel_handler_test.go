@@ -0,0 +1,45 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"opamp-backend/internal/logging"
+	"testing"
+)
+
+func TestHandleServerLogLevel_SetSubsystem(t *testing.T) {
+	defer logging.Configure("info", "text", nil)
+
+	handler := HandleServerLogLevel()
+	payload := `{"subsystem": "opamp", "log_level": "debug"}`
+	req := httptest.NewRequest("PUT", "/api/admin/loglevel", bytes.NewBufferString(payload))
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+	if levels := logging.Levels(); levels["opamp"] != "debug" || levels["api"] != "info" {
+		t.Errorf("expected only opamp to be set to debug, got %v", levels)
+	}
+}
+
+func TestHandleServerLogLevel_Invalid(t *testing.T) {
+	handler := HandleServerLogLevel()
+
+	for _, payload := range []string{
+		`{"subsystem": "opamp", "log_level": "verbose"}`,
+		`{"subsystem": "unknown", "log_level": "debug"}`,
+	} {
+		req := httptest.NewRequest("PUT", "/api/admin/loglevel", bytes.NewBufferString(payload))
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %s, got %d", payload, w.Code)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/config"
+	"opamp-backend/internal/middleware"
+	"testing"
+)
+
+// stubServer implements common.ServerInterface with no agents. Test servers
+// for optional features embed it and add the methods of their feature's
+// interface.
+type stubServer struct{}
+
+func (stubServer) UpdateAgentLogLevel(ctx context.Context, agentID string, logLevel string) error {
+	return nil
+}
+
+func (stubServer) GetAllAgents() []*agents.Agent {
+	return nil
+}
+
+func (stubServer) GetAgentIDs() []string {
+	return nil
+}
+
+func (stubServer) GetAgent(agentID string) (*agents.Agent, bool) {
+	return nil, false
+}
+
+func (stubServer) RequestAgentConfig(agentID string) error {
+	return nil
+}
+
+// serveLimited runs handler for req as a caller whose token may only read or
+// change the agents matched by selector.
+func serveLimited(t *testing.T, selector agents.Selector, handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	token := config.APIToken{Name: "limited", Token: "limited-token", Scopes: []string{config.ScopeAdmin}, Agents: selector}
+	if err := middleware.SetTokens([]config.APIToken{token}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { middleware.SetTokens(nil) })
+
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(handler).ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/apitokens"
+	"opamp-backend/internal/common"
+	"time"
+)
+
+// TokenServer is implemented by servers that manage named API tokens.
+type TokenServer interface {
+	common.ServerInterface
+	ListAPITokens() ([]apitokens.Token, error)
+	MintAPIToken(name string, scopes []string, selector agents.Selector, expiresAt *time.Time) (string, apitokens.Token, error)
+	ExpireAPIToken(id string, at time.Time) (apitokens.Token, error)
+	RevokeAPIToken(id string) (apitokens.Token, error)
+}
+
+// MintTokenRequest describes a token to mint. ExpiresIn (a Go duration such
+// as "720h") and ExpiresAt are alternatives; without either the token does
+// not expire.
+type MintTokenRequest struct {
+	Name      string          `json:"name"`
+	Scopes    []string        `json:"scopes"`
+	Agents    agents.Selector `json:"agents"`
+	ExpiresIn string          `json:"expires_in,omitempty"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+}
+
+// MintTokenResponse carries the new token's secret, which is shown only once.
+type MintTokenResponse struct {
+	Secret string `json:"token"`
+	apitokens.Token
+}
+
+// TokenChangeRequest identifies the token to expire or revoke. ExpiresAt
+// defaults to now when expiring.
+type TokenChangeRequest struct {
+	ID        string     `json:"id"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// HandleAPITokens lists the minted tokens on GET and mints a token on POST.
+func HandleAPITokens() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		srv, ok := common.GetServerInstance().(TokenServer)
+		if !ok {
+			http.Error(w, "Server not initialized", http.StatusInternalServerError)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			tokens, err := srv.ListAPITokens()
+			if err != nil {
+				writeTokenError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(tokens)
+
+		case http.MethodPost:
+			var req MintTokenRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			expiresAt := req.ExpiresAt
+			if req.ExpiresIn != "" {
+				d, err := time.ParseDuration(req.ExpiresIn)
+				if err != nil || d <= 0 || expiresAt != nil {
+					http.Error(w, "Invalid expires_in (expected a positive duration, without expires_at)", http.StatusBadRequest)
+					return
+				}
+				at := time.Now().Add(d).UTC()
+				expiresAt = &at
+			}
+
+			secret, token, err := srv.MintAPIToken(req.Name, req.Scopes, req.Agents, expiresAt)
+			if err != nil {
+				writeTokenError(w, err)
+				return
+			}
+			logger.InfoContext(r.Context(), "Minted api token", "token_id", token.ID, "token_name", token.Name, "scopes", token.Scopes)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(MintTokenResponse{Secret: secret, Token: token})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// HandleExpireAPIToken sets a minted token's expiry, by default to now.
+func HandleExpireAPIToken() http.HandlerFunc {
+	return handleTokenChange("expire", func(srv TokenServer, req TokenChangeRequest) (apitokens.Token, error) {
+		at := time.Now()
+		if req.ExpiresAt != nil {
+			at = *req.ExpiresAt
+		}
+		return srv.ExpireAPIToken(req.ID, at)
+	})
+}
+
+// HandleRevokeAPIToken revokes a minted token.
+func HandleRevokeAPIToken() http.HandlerFunc {
+	return handleTokenChange("revoke", func(srv TokenServer, req TokenChangeRequest) (apitokens.Token, error) {
+		return srv.RevokeAPIToken(req.ID)
+	})
+}
+
+func handleTokenChange(action string, change func(TokenServer, TokenChangeRequest) (apitokens.Token, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req TokenChangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		srv, ok := common.GetServerInstance().(TokenServer)
+		if !ok {
+			http.Error(w, "Server not initialized", http.StatusInternalServerError)
+			return
+		}
+
+		token, err := change(srv, req)
+		if err != nil {
+			writeTokenError(w, err)
+			return
+		}
+		logger.InfoContext(r.Context(), "Changed api token", "operation", action, "token_id", token.ID, "token_name", token.Name)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(token)
+	}
+}
+
+func writeTokenError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, apitokens.ErrDisabled), errors.Is(err, apitokens.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, apitokens.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		logger.Error("API token store failed", "error", err)
+		http.Error(w, "Failed to update api tokens", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/apitokens"
+	"opamp-backend/internal/common"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mockTokenServer backs the token handlers with a real store.
+type mockTokenServer struct {
+	stubServer
+	store *apitokens.Store
+}
+
+func (m *mockTokenServer) ListAPITokens() ([]apitokens.Token, error) {
+	return m.store.List(), nil
+}
+
+func (m *mockTokenServer) MintAPIToken(name string, scopes []string, selector agents.Selector, expiresAt *time.Time) (string, apitokens.Token, error) {
+	return m.store.Mint(name, scopes, selector, expiresAt)
+}
+
+func (m *mockTokenServer) ExpireAPIToken(id string, at time.Time) (apitokens.Token, error) {
+	return m.store.Expire(id, at)
+}
+
+func (m *mockTokenServer) RevokeAPIToken(id string) (apitokens.Token, error) {
+	return m.store.Revoke(id)
+}
+
+func TestHandleAPITokens(t *testing.T) {
+	store, err := apitokens.Open(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	common.SetServerInstance(&mockTokenServer{store: store})
+	defer common.SetServerInstance(nil)
+
+	body := []byte(`{"name":"ci","scopes":["read"],"expires_in":"24h"}`)
+	w := httptest.NewRecorder()
+	HandleAPITokens()(w, httptest.NewRequest(http.MethodPost, "/api/tokens", bytes.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("mint: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var minted MintTokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &minted); err != nil {
+		t.Fatal(err)
+	}
+	if minted.Secret == "" || minted.ID == "" || minted.ExpiresAt == nil {
+		t.Fatalf("unexpected mint response %+v", minted)
+	}
+
+	w = httptest.NewRecorder()
+	HandleAPITokens()(w, httptest.NewRequest(http.MethodGet, "/api/tokens", nil))
+	if bytes.Contains(w.Body.Bytes(), []byte(minted.Secret)) || bytes.Contains(w.Body.Bytes(), []byte(`"hash"`)) {
+		t.Errorf("token listing exposes secrets: %s", w.Body.String())
+	}
+
+	revoke := []byte(`{"id":"` + minted.ID + `"}`)
+	w = httptest.NewRecorder()
+	HandleRevokeAPIToken()(w, httptest.NewRequest(http.MethodPost, "/api/tokens/revoke", bytes.NewReader(revoke)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoke: expected 200, got %d", w.Code)
+	}
+	if _, err := store.Authenticate(minted.Secret); err == nil {
+		t.Error("revoked token still authenticates")
+	}
+
+	w = httptest.NewRecorder()
+	HandleExpireAPIToken()(w, httptest.NewRequest(http.MethodPost, "/api/tokens/expire", bytes.NewReader([]byte(`{"id":"missing"}`))))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expire unknown token: expected 404, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	HandleAPITokens()(w, httptest.NewRequest(http.MethodPost, "/api/tokens", bytes.NewReader([]byte(`{"scopes":["read"]}`))))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("mint without name: expected 400, got %d", w.Code)
+	}
+}
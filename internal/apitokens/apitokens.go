@@ -0,0 +1,400 @@
+// Package apitokens stores API tokens as salted SHA-256 hashes. Minted tokens
+// have the form "opb_<id>_<secret>": the ID is kept in clear to look the
+// token up and the secret only as a hash, so the store never holds a usable
+// credential.
+package apitokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"opamp-backend/internal/agents"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix starts every minted token.
+const Prefix = "opb_"
+
+const (
+	idBytes     = 6
+	secretBytes = 32
+	saltBytes   = 16
+	hashScheme  = "sha256"
+)
+
+// Errors returned by the store.
+var (
+	ErrDisabled = errors.New("api token store is not configured")
+	ErrNotFound = errors.New("api token not found")
+	ErrInvalid  = errors.New("invalid api token")
+	ErrExpired  = errors.New("api token expired")
+	ErrRevoked  = errors.New("api token revoked")
+)
+
+// Token is a stored API token. Salt and Hash are never returned by List.
+type Token struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Scopes    []string        `json:"scopes"`
+	Agents    agents.Selector `json:"agents,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+	RevokedAt *time.Time      `json:"revoked_at,omitempty"`
+	Salt      string          `json:"salt,omitempty"`
+	Hash      string          `json:"hash,omitempty"`
+}
+
+// Active reports whether the token is neither revoked nor expired at now.
+func (t Token) Active(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
+
+// public returns a copy without the secret's salt and hash.
+func (t Token) public() Token {
+	t.Salt, t.Hash = "", ""
+	return t
+}
+
+// Hash is a salted hash of a token secret, encoded as
+// "sha256:<salt hex>:<hash hex>".
+type Hash struct {
+	salt []byte
+	sum  []byte
+}
+
+// NewHash hashes secret with a fresh random salt.
+func NewHash(secret string) (Hash, error) {
+	salt := make([]byte, saltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return Hash{}, err
+	}
+	return Hash{salt: salt, sum: digest(salt, secret)}, nil
+}
+
+// ParseHash decodes a hash produced by Hash.String.
+func ParseHash(s string) (Hash, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || parts[0] != hashScheme {
+		return Hash{}, fmt.Errorf("token hash must have the form %s:<salt>:<hash>", hashScheme)
+	}
+	salt, err := hex.DecodeString(parts[1])
+	if err != nil || len(salt) == 0 {
+		return Hash{}, fmt.Errorf("invalid token hash salt")
+	}
+	sum, err := hex.DecodeString(parts[2])
+	if err != nil || len(sum) != sha256.Size {
+		return Hash{}, fmt.Errorf("invalid token hash")
+	}
+	return Hash{salt: salt, sum: sum}, nil
+}
+
+// Matches compares secret against the hash in constant time.
+func (h Hash) Matches(secret string) bool {
+	return len(h.sum) > 0 && subtle.ConstantTimeCompare(digest(h.salt, secret), h.sum) == 1
+}
+
+func (h Hash) String() string {
+	return fmt.Sprintf("%s:%x:%x", hashScheme, h.salt, h.sum)
+}
+
+func digest(salt []byte, secret string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(secret))
+	return h.Sum(nil)
+}
+
+// Store is a file of minted tokens. Every change re-reads the file first, so
+// that tokens managed from the CLI and the API do not overwrite each other,
+// and Authenticate re-reads it whenever it changed on disk, so that tokens
+// revoked or expired from the CLI are refused right away.
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	tokens  map[string]Token
+	version fileVersion // of the file tokens were read from
+	now     func() time.Time
+}
+
+// fileVersion identifies the content of the store file without reading it.
+type fileVersion struct {
+	modTime int64 // in nanoseconds
+	size    int64
+}
+
+// statVersion returns the version of the file at path, or the zero version
+// if it does not exist.
+func statVersion(path string) fileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{modTime: info.ModTime().UnixNano(), size: info.Size()}
+}
+
+// Open loads the store at path; a missing file is an empty store.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, now: time.Now}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the store file.
+func (s *Store) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Snapshot is the content of the store file, read by Read and installed by
+// Use.
+type Snapshot struct {
+	tokens  map[string]Token
+	version fileVersion
+}
+
+// Read reads the store file without changing the store.
+func (s *Store) Read() (Snapshot, error) {
+	tokens, version, err := readTokens(s.path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{tokens: tokens, version: version}, nil
+}
+
+// Use replaces the tokens with those of a snapshot returned by Read.
+func (s *Store) Use(snapshot Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens, s.version = snapshot.tokens, snapshot.version
+}
+
+func (s *Store) load() error {
+	tokens, version, err := readTokens(s.path)
+	if err != nil {
+		return err
+	}
+	s.tokens, s.version = tokens, version
+	return nil
+}
+
+// readTokens reads the file at path along with its version, taken before
+// reading so that a concurrent change is picked up by the next check.
+func readTokens(path string) (map[string]Token, fileVersion, error) {
+	version := statVersion(path)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string]Token), fileVersion{}, nil
+	}
+	if err != nil {
+		return nil, fileVersion{}, err
+	}
+	var list []Token
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fileVersion{}, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	tokens := make(map[string]Token, len(list))
+	for _, t := range list {
+		tokens[t.ID] = t
+	}
+	return tokens, version, nil
+}
+
+// save writes the store through a temporary file so readers never see a
+// partial file.
+func (s *Store) save() error {
+	list := make([]Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		list = append(list, t)
+	}
+	sortTokens(list)
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".apitokens-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.version = statVersion(s.path)
+	return nil
+}
+
+// Mint creates a token and returns it in full. The secret cannot be
+// recovered later. A nil expiresAt never expires.
+func (s *Store) Mint(name string, scopes []string, selector agents.Selector, expiresAt *time.Time) (string, Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return "", Token{}, err
+	}
+	if name == "" {
+		return "", Token{}, fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	now := s.now()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return "", Token{}, fmt.Errorf("%w: expiry is in the past", ErrInvalid)
+	}
+	for _, t := range s.tokens {
+		if t.Name == name && t.Active(now) {
+			return "", Token{}, fmt.Errorf("%w: an active token named %q already exists", ErrInvalid, name)
+		}
+	}
+
+	idRaw := make([]byte, idBytes)
+	if _, err := rand.Read(idRaw); err != nil {
+		return "", Token{}, err
+	}
+	id := hex.EncodeToString(idRaw) // hex, so the first "_" ends the ID
+	secret, err := randomString(secretBytes)
+	if err != nil {
+		return "", Token{}, err
+	}
+	hash, err := NewHash(secret)
+	if err != nil {
+		return "", Token{}, err
+	}
+	t := Token{
+		ID:        id,
+		Name:      name,
+		Scopes:    scopes,
+		Agents:    selector,
+		CreatedAt: now.UTC(),
+		ExpiresAt: expiresAt,
+		Salt:      hex.EncodeToString(hash.salt),
+		Hash:      hex.EncodeToString(hash.sum),
+	}
+	s.tokens[id] = t
+	if err := s.save(); err != nil {
+		delete(s.tokens, id)
+		return "", Token{}, err
+	}
+	return Prefix + id + "_" + secret, t.public(), nil
+}
+
+// List returns every token, including expired and revoked ones, without
+// their hashes.
+func (s *Store) List() []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		list = append(list, t.public())
+	}
+	sortTokens(list)
+	return list
+}
+
+// Expire sets the token's expiry to at.
+func (s *Store) Expire(id string, at time.Time) (Token, error) {
+	return s.update(id, func(t *Token) {
+		at = at.UTC()
+		t.ExpiresAt = &at
+	})
+}
+
+// Revoke permanently disables the token.
+func (s *Store) Revoke(id string) (Token, error) {
+	return s.update(id, func(t *Token) {
+		if t.RevokedAt == nil {
+			now := s.now().UTC()
+			t.RevokedAt = &now
+		}
+	})
+}
+
+func (s *Store) update(id string, change func(*Token)) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return Token{}, err
+	}
+	t, ok := s.tokens[id]
+	if !ok {
+		return Token{}, ErrNotFound
+	}
+	previous := t
+	change(&t)
+	s.tokens[id] = t
+	if err := s.save(); err != nil {
+		s.tokens[id] = previous
+		return Token{}, err
+	}
+	return t.public(), nil
+}
+
+// Authenticate looks up a presented token by its ID and checks its secret,
+// first re-reading the file if it changed. Expired and revoked tokens are
+// returned along with ErrExpired or ErrRevoked.
+func (s *Store) Authenticate(presented string) (Token, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(presented, Prefix), "_")
+	if !strings.HasPrefix(presented, Prefix) || !ok {
+		return Token{}, ErrInvalid
+	}
+
+	s.mu.Lock()
+	if statVersion(s.path) != s.version {
+		// A file that fails to parse keeps the tokens last read
+		s.load()
+	}
+	t, found := s.tokens[id]
+	s.mu.Unlock()
+	if !found {
+		return Token{}, ErrInvalid
+	}
+	salt, _ := hex.DecodeString(t.Salt)
+	sum, _ := hex.DecodeString(t.Hash)
+	if !(Hash{salt: salt, sum: sum}).Matches(secret) {
+		return Token{}, ErrInvalid
+	}
+	switch now := s.now(); {
+	case t.RevokedAt != nil:
+		return t.public(), ErrRevoked
+	case !t.Active(now):
+		return t.public(), ErrExpired
+	}
+	return t.public(), nil
+}
+
+func sortTokens(list []Token) {
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
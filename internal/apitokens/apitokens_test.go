@@ -0,0 +1,157 @@
+package apitokens
+
+import (
+	"errors"
+	"opamp-backend/internal/agents"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreMintAuthenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, token, err := store.Mint("ci", []string{"read"}, agents.Selector{Labels: map[string]string{"env": "staging"}}, nil)
+	if err != nil {
+		t.Fatalf("Mint() error = %v", err)
+	}
+	if !strings.HasPrefix(secret, Prefix+token.ID+"_") {
+		t.Errorf("token %q does not start with its ID %q", secret, token.ID)
+	}
+	if token.Hash != "" || token.Salt != "" {
+		t.Error("Mint() returned the stored hash")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), strings.TrimPrefix(secret, Prefix+token.ID+"_")) {
+		t.Error("store file contains the token secret")
+	}
+
+	got, err := store.Authenticate(secret)
+	if err != nil || got.Name != "ci" || got.Agents.Labels["env"] != "staging" {
+		t.Fatalf("Authenticate() = %+v, %v", got, err)
+	}
+	for _, bad := range []string{secret + "x", Prefix + token.ID + "_wrong", "opb_unknown_secret", "not-a-token"} {
+		if _, err := store.Authenticate(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Authenticate(%q) error = %v, want ErrInvalid", bad, err)
+		}
+	}
+
+	// A second store on the same file sees the token.
+	other, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Authenticate(secret); err != nil {
+		t.Errorf("reopened store rejected the token: %v", err)
+	}
+}
+
+func TestStoreExpireRevoke(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	expiry := now.Add(time.Hour)
+	secret, token, err := store.Mint("ci", []string{"read"}, agents.Selector{}, &expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Mint("ci", []string{"read"}, agents.Selector{}, nil); !errors.Is(err, ErrInvalid) {
+		t.Errorf("minting a duplicate active name: error = %v, want ErrInvalid", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := store.Authenticate(secret); !errors.Is(err, ErrExpired) {
+		t.Errorf("Authenticate() after expiry error = %v, want ErrExpired", err)
+	}
+
+	if _, err := store.Expire(token.ID, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Authenticate(secret); err != nil {
+		t.Errorf("Authenticate() after extending expiry: %v", err)
+	}
+
+	revoked, err := store.Revoke(token.ID)
+	if err != nil || revoked.RevokedAt == nil {
+		t.Fatalf("Revoke() = %+v, %v", revoked, err)
+	}
+	if _, err := store.Authenticate(secret); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Authenticate() after revoke error = %v, want ErrRevoked", err)
+	}
+	if _, err := store.Revoke("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revoke(missing) error = %v, want ErrNotFound", err)
+	}
+
+	list := store.List()
+	if len(list) != 1 || list[0].Hash != "" {
+		t.Errorf("List() = %+v, want one token without its hash", list)
+	}
+}
+
+func TestStoreAuthenticateSeesOtherWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	server, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The CLI works on its own store on the same file.
+	cli, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, token, err := cli.Mint("ci", []string{"read"}, agents.Selector{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Authenticate(secret); err != nil {
+		t.Fatalf("token minted by another store was rejected: %v", err)
+	}
+	if _, err := cli.Revoke(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Authenticate(secret); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Authenticate() after revoke by another store error = %v, want ErrRevoked", err)
+	}
+
+	// A file that fails to parse keeps the tokens last read.
+	if err := os.WriteFile(path, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Authenticate(secret); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Authenticate() with an unreadable file error = %v, want ErrRevoked", err)
+	}
+}
+
+func TestHash(t *testing.T) {
+	h, err := NewHash("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseHash(h.String())
+	if err != nil {
+		t.Fatalf("ParseHash(%q) error = %v", h.String(), err)
+	}
+	if !parsed.Matches("s3cret") || parsed.Matches("s3cret2") || parsed.Matches("") {
+		t.Error("parsed hash does not match only its secret")
+	}
+	for _, bad := range []string{"", "md5:00:00", "sha256:zz:00", "sha256:00:0011"} {
+		if _, err := ParseHash(bad); err == nil {
+			t.Errorf("ParseHash(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Package audit records mutating API calls and configuration pushes in an
+// append-only JSON lines file.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Outcomes of an audited call or of a single target within it.
+const (
+	OutcomeSuccess = "success"
+	OutcomePartial = "partial"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// ErrDisabled is returned when querying a server without an audit log.
+var ErrDisabled = errors.New("audit log is not enabled")
+
+// Entry is one audited API call.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Caller    string    `json:"caller,omitempty"`
+	Method    string    `json:"method"`
+	Endpoint  string    `json:"endpoint"`
+	Status    int       `json:"status"`
+	Outcome   string    `json:"outcome"`
+	Targets   []Target  `json:"targets,omitempty"`
+
+	mu sync.Mutex
+}
+
+// Target is an agent affected by an audited call.
+type Target struct {
+	AgentID          string `json:"agent_id"`
+	BeforeConfigHash string `json:"before_config_hash,omitempty"`
+	AfterConfigHash  string `json:"after_config_hash,omitempty"`
+	Outcome          string `json:"outcome"`
+	Error            string `json:"error,omitempty"`
+}
+
+type entryKey struct{}
+
+// NewContext returns a context carrying entry, so that handlers can add the
+// agents they affect.
+func NewContext(ctx context.Context, entry *Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, entry)
+}
+
+// FromContext returns the entry being recorded for the current request.
+func FromContext(ctx context.Context) (*Entry, bool) {
+	entry, ok := ctx.Value(entryKey{}).(*Entry)
+	return entry, ok
+}
+
+// AddTarget records that the current request affected an agent. err is the
+// outcome of the change; it is a no-op outside an audited request.
+func AddTarget(ctx context.Context, agentID, beforeHash, afterHash string, err error) {
+	entry, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+	target := Target{
+		AgentID:          agentID,
+		BeforeConfigHash: beforeHash,
+		AfterConfigHash:  afterHash,
+		Outcome:          OutcomeSuccess,
+	}
+	if err != nil {
+		target.Outcome = OutcomeFailure
+		target.Error = err.Error()
+	}
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	entry.Targets = append(entry.Targets, target)
+}
+
+// SetCaller records the authenticated caller of the current request.
+func SetCaller(ctx context.Context, caller string) {
+	if entry, ok := FromContext(ctx); ok {
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		entry.Caller = caller
+	}
+}
+
+// HasTargets reports whether any agent was affected.
+func (e *Entry) HasTargets() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.Targets) > 0
+}
+
+// Finish sets the response status and derives the outcome of the call.
+func (e *Entry) Finish(status int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Status = status
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		e.Outcome = OutcomeDenied
+	case status >= http.StatusBadRequest:
+		e.Outcome = OutcomeFailure
+	case status == http.StatusPartialContent:
+		e.Outcome = OutcomePartial
+	default:
+		e.Outcome = OutcomeSuccess
+		for _, target := range e.Targets {
+			if target.Outcome != OutcomeSuccess {
+				e.Outcome = OutcomePartial
+				break
+			}
+		}
+	}
+}
+
+// Log is an append-only audit log file.
+type Log struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	lastErr error
+}
+
+// Open opens (creating it if needed) the audit log at path for appending.
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{path: path, file: file}, nil
+}
+
+// Record appends an entry to the log.
+func (l *Log) Record(entry *Entry) error {
+	entry.mu.Lock()
+	data, err := json.Marshal(entry)
+	entry.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+	_, err = l.file.Write(append(data, '\n'))
+	l.lastErr = err
+	return err
+}
+
+// Check reports whether the last write succeeded, for readiness probes.
+func (l *Log) Check() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+	if l.lastErr != nil {
+		return fmt.Errorf("audit log write failed: %v", l.lastErr)
+	}
+	return nil
+}
+
+// Close flushes the log to disk and closes it.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Sync()
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
+
+// Query selects entries from the log. Zero values match everything.
+type Query struct {
+	Caller   string
+	AgentID  string
+	Endpoint string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+func (q Query) matches(e *Entry) bool {
+	if q.Caller != "" && e.Caller != q.Caller {
+		return false
+	}
+	if q.Endpoint != "" && e.Endpoint != q.Endpoint {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.Time.After(q.Until) {
+		return false
+	}
+	if q.AgentID != "" && !slices.ContainsFunc(e.Targets, func(t Target) bool { return t.AgentID == q.AgentID }) {
+		return false
+	}
+	return true
+}
+
+// Search returns the most recent entries matching q, newest first.
+func (l *Log) Search(q Query) ([]*Entry, error) {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var matches []*Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		entry := &Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			continue // skip a partially written last line
+		}
+		if !q.matches(entry) {
+			continue
+		}
+		matches = append(matches, entry)
+		if q.Limit > 0 && len(matches) > q.Limit {
+			matches = matches[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.Reverse(matches)
+	return matches, nil
+}
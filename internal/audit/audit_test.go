@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLog_RecordAndSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.log")
+	log, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+
+	start := time.Now().UTC()
+	for i, caller := range []string{"alice", "bob", "alice"} {
+		entry := &Entry{Time: start.Add(time.Duration(i) * time.Second), Caller: caller, Method: "PUT", Endpoint: "/api/agent/loglevel"}
+		ctx := NewContext(context.Background(), entry)
+		AddTarget(ctx, "agent-"+caller, "before", "after", nil)
+		entry.Finish(http.StatusOK)
+		if err := log.Record(entry); err != nil {
+			t.Fatalf("Record error: %v", err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	// Reopening appends instead of truncating.
+	log, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	defer log.Close()
+	log.Record(&Entry{Time: start.Add(time.Minute), Caller: "carol", Method: "POST", Endpoint: "/api/config", Outcome: OutcomeSuccess})
+
+	all, err := log.Search(Query{})
+	if err != nil {
+		t.Fatalf("Search error: %v", err)
+	}
+	if len(all) != 4 || all[0].Caller != "carol" {
+		t.Fatalf("expected 4 entries newest first, got %d", len(all))
+	}
+
+	byAgent, _ := log.Search(Query{AgentID: "agent-alice"})
+	if len(byAgent) != 2 || byAgent[0].Targets[0].AfterConfigHash != "after" {
+		t.Errorf("expected 2 entries for agent-alice, got %+v", byAgent)
+	}
+	limited, _ := log.Search(Query{Caller: "alice", Limit: 1})
+	if len(limited) != 1 || !limited[0].Time.Equal(start.Add(2*time.Second)) {
+		t.Errorf("expected the most recent alice entry, got %+v", limited)
+	}
+	since, _ := log.Search(Query{Since: start.Add(30 * time.Second)})
+	if len(since) != 1 || since[0].Endpoint != "/api/config" {
+		t.Errorf("expected only the entry after since, got %+v", since)
+	}
+
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 4 {
+		t.Errorf("expected 4 JSON lines, got %d", lines)
+	}
+}
+
+func TestEntry_Finish(t *testing.T) {
+	tests := []struct {
+		status  int
+		failed  bool
+		outcome string
+	}{
+		{http.StatusOK, false, OutcomeSuccess},
+		{http.StatusOK, true, OutcomePartial},
+		{http.StatusPartialContent, true, OutcomePartial},
+		{http.StatusForbidden, false, OutcomeDenied},
+		{http.StatusInternalServerError, true, OutcomeFailure},
+	}
+
+	for _, tt := range tests {
+		entry := &Entry{}
+		ctx := NewContext(context.Background(), entry)
+		AddTarget(ctx, "ok", "", "", nil)
+		if tt.failed {
+			AddTarget(ctx, "failed", "", "", errors.New("send failed"))
+		}
+		entry.Finish(tt.status)
+		if entry.Outcome != tt.outcome {
+			t.Errorf("status %d (failed target %v): expected %s, got %s", tt.status, tt.failed, tt.outcome, entry.Outcome)
+		}
+	}
+}
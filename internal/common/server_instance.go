@@ -1,16 +1,40 @@
 package common
 
-import "opamp-backend/internal/agents"
+import (
+	"context"
+	"opamp-backend/internal/agents"
+	"time"
+)
 
-// ServerInterface defines the methods that API handlers need to call on the server
+// ServerInterface defines the methods that API handlers need to call on the
+// server. Handlers for optional features declare their own interfaces that
+// embed it and assert the server instance to them.
 type ServerInterface interface {
-	UpdateAgentLogLevel(agentID string, logLevel string) error
+	UpdateAgentLogLevel(ctx context.Context, agentID string, logLevel string) error
 	GetAllAgents() []*agents.Agent
 	GetAgentIDs() []string
 	GetAgent(agentID string) (*agents.Agent, bool) // Added this method
 	RequestAgentConfig(agentID string) error       // Added this method
 }
 
+// ReadinessCheck reports the outcome of a single readiness probe.
+type ReadinessCheck struct {
+	Name    string `json:"name"`
+	Ready   bool   `json:"ready"`
+	Message string `json:"message,omitempty"`
+}
+
+// EnrollmentRecord is the enrollment state of one agent.
+type EnrollmentRecord struct {
+	AgentID           string            `json:"agent_id"`
+	State             string            `json:"state"` // pending, approved or rejected
+	Labels            map[string]string `json:"labels,omitempty"`
+	ClientCertSubject string            `json:"client_cert_subject,omitempty"`
+	RequestedAt       time.Time         `json:"requested_at"`
+	DecidedAt         *time.Time        `json:"decided_at,omitempty"`
+	DecidedBy         string            `json:"decided_by,omitempty"` // "api" or the auto-approve rule name
+}
+
 var serverInstance ServerInterface
 
 // SetServerInstance sets the global server instance
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/apitokens"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Scopes that can be granted to API tokens. ScopeAdmin grants every scope and
+// ScopeConfigWrite also grants ScopeLogLevel.
+const (
+	ScopeRead        = "read"
+	ScopeLogLevel    = "loglevel"
+	ScopeConfigWrite = "config:write"
+	ScopeAdmin       = "admin"
+	ScopeDebug       = "debug"
+)
+
+// Scopes lists every valid scope.
+var Scopes = []string{ScopeRead, ScopeLogLevel, ScopeConfigWrite, ScopeAdmin, ScopeDebug}
+
+// APIToken is a named credential for the REST API. The secret is given
+// either in clear as Token or, preferably, as a salted TokenHash produced by
+// "opamp-backend tokens hash".
+type APIToken struct {
+	Name      string          `yaml:"name"`
+	Token     string          `yaml:"token"`
+	TokenHash string          `yaml:"token_hash"`
+	Scopes    []string        `yaml:"scopes"`
+	Agents    agents.Selector `yaml:"agents"` // optional: the agents the token may read or change
+}
+
+// apiTokenFile is the format of api.token_file.
+type apiTokenFile struct {
+	Tokens []APIToken `yaml:"tokens"`
+}
+
+// LoadAPITokenFile reads the tokens listed in a token file.
+func LoadAPITokenFile(path string) ([]APIToken, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file apiTokenFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return file.Tokens, nil
+}
+
+// APITokens returns the tokens configured in api.tokens followed by those in
+// api.token_file.
+func (c *Config) APITokens() ([]APIToken, error) {
+	tokens := append([]APIToken(nil), c.API.Tokens...)
+	if c.API.TokenFile != "" {
+		fileTokens, err := LoadAPITokenFile(c.API.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, fileTokens...)
+	}
+	return tokens, nil
+}
+
+func validateAPITokens(tokens []APIToken) error {
+	names := make(map[string]bool, len(tokens))
+	for i, token := range tokens {
+		if token.Name == "" {
+			return fmt.Errorf("api token %d has no name", i)
+		}
+		if names[token.Name] {
+			return fmt.Errorf("api token %q is defined more than once", token.Name)
+		}
+		names[token.Name] = true
+		if (token.Token == "") == (token.TokenHash == "") {
+			return fmt.Errorf("api token %q must set exactly one of token and token_hash", token.Name)
+		}
+		if token.TokenHash != "" {
+			if _, err := apitokens.ParseHash(token.TokenHash); err != nil {
+				return fmt.Errorf("api token %q: %v", token.Name, err)
+			}
+		}
+		if err := ValidateScopes(token.Scopes); err != nil {
+			return fmt.Errorf("api token %q: %v", token.Name, err)
+		}
+	}
+	return nil
+}
+
+// ValidateScopes checks that scopes is non-empty and only lists known scopes.
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("no scopes granted")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
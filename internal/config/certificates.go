@@ -0,0 +1,100 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"opamp-backend/internal/agents"
+	"time"
+)
+
+// Certificate approval policies for certificates.approval.
+const (
+	ApprovalManual = "manual"
+	ApprovalAuto   = "auto"
+)
+
+// Defaults for certificates issued to agents.
+const (
+	DefaultCertificateValidity = 30 * 24 * time.Hour
+)
+
+// CertificatesConfig enables signing the client certificates agents request
+// with a CSR, using a local CA.
+type CertificatesConfig struct {
+	CACertFile string `yaml:"ca_cert_file"` // empty disables certificate requests
+	CAKeyFile  string `yaml:"ca_key_file"`
+	Store      string `yaml:"store"` // JSON file of requests and issued certificates
+	// Endpoint is the OpAMP endpoint offered along with the certificates,
+	// unless connection_settings offer another one to the agent.
+	Endpoint    string        `yaml:"endpoint"`
+	Validity    time.Duration `yaml:"validity"`     // default 30 days
+	RenewBefore time.Duration `yaml:"renew_before"` // default a third of validity
+	// Approval is manual (the default), where requests wait for the API, or
+	// auto, where requests of the agents matched by Agents are signed.
+	Approval string          `yaml:"approval"`
+	Agents   agents.Selector `yaml:"agents"` // empty for every agent
+}
+
+// Enabled reports whether agents can request certificates.
+func (c CertificatesConfig) Enabled() bool {
+	return c.CACertFile != ""
+}
+
+// ValidityPeriod returns Validity or its default.
+func (c CertificatesConfig) ValidityPeriod() time.Duration {
+	if c.Validity == 0 {
+		return DefaultCertificateValidity
+	}
+	return c.Validity
+}
+
+// RenewalPeriod returns how long before expiry certificates are renewed.
+func (c CertificatesConfig) RenewalPeriod() time.Duration {
+	if c.RenewBefore == 0 {
+		return c.ValidityPeriod() / 3
+	}
+	return c.RenewBefore
+}
+
+func (c CertificatesConfig) validate() error {
+	if !c.Enabled() {
+		if c.CAKeyFile != "" {
+			return fmt.Errorf("certificates.ca_key_file requires ca_cert_file")
+		}
+		return nil
+	}
+	if c.CAKeyFile == "" {
+		return fmt.Errorf("certificates.ca_key_file is required when ca_cert_file is set")
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("certificates.endpoint: invalid URL %q", c.Endpoint)
+	}
+	switch u.Scheme {
+	case "ws", "wss", "http", "https":
+	default:
+		return fmt.Errorf("certificates.endpoint: unsupported scheme %q", u.Scheme)
+	}
+	if c.Validity < 0 || c.RenewBefore < 0 {
+		return fmt.Errorf("certificates.validity and renew_before must not be negative")
+	}
+	if c.RenewalPeriod() >= c.ValidityPeriod() {
+		return fmt.Errorf("certificates.renew_before must be shorter than validity")
+	}
+	switch c.Approval {
+	case "", ApprovalManual, ApprovalAuto:
+	default:
+		return fmt.Errorf("invalid certificates.approval %q (expected manual or auto)", c.Approval)
+	}
+	pair, err := tls.LoadX509KeyPair(c.CACertFile, c.CAKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificates CA: %v", err)
+	}
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil || !ca.IsCA {
+		return fmt.Errorf("certificates.ca_cert_file %s is not a CA certificate", c.CACertFile)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// CertificateStore holds the server certificate and client CA bundle used by
+// a TLS listener and allows them to be replaced without restarting the
+// listener. Connections that are already established keep the certificate
+// they negotiated with.
+type CertificateStore struct {
+	cert       atomic.Pointer[tls.Certificate]
+	clientAuth atomic.Pointer[clientAuth]
+}
+
+type clientAuth struct {
+	pool *x509.CertPool
+	mode tls.ClientAuthType
+}
+
+// NewCertificateStore loads the key pair from certFile and keyFile.
+func NewCertificateStore(certFile, keyFile string) (*CertificateStore, error) {
+	store := &CertificateStore{}
+	if err := store.Load(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Load replaces the stored certificate with the key pair from certFile and
+// keyFile. The current certificate is kept if loading fails.
+func (s *CertificateStore) Load(certFile, keyFile string) error {
+	cert, err := loadKeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	s.cert.Store(cert)
+	return nil
+}
+
+// LoadClientCAs enables client certificate verification against the CA
+// bundle in caFile. mode is ClientAuthRequire (the default) or
+// ClientAuthOptional. An empty caFile disables client certificates.
+func (s *CertificateStore) LoadClientCAs(caFile, mode string) error {
+	auth, err := loadClientAuth(caFile, mode)
+	if err != nil {
+		return err
+	}
+	s.clientAuth.Store(auth)
+	return nil
+}
+
+// TLSFiles are a key pair and client CA bundle loaded by LoadTLSFiles.
+type TLSFiles struct {
+	cert       *tls.Certificate
+	clientAuth *clientAuth
+}
+
+// LoadTLSFiles loads what Load and LoadClientCAs would without changing any
+// CertificateStore.
+func LoadTLSFiles(certFile, keyFile, caFile, mode string) (TLSFiles, error) {
+	cert, err := loadKeyPair(certFile, keyFile)
+	if err != nil {
+		return TLSFiles{}, err
+	}
+	auth, err := loadClientAuth(caFile, mode)
+	if err != nil {
+		return TLSFiles{}, err
+	}
+	return TLSFiles{cert: cert, clientAuth: auth}, nil
+}
+
+// Use replaces the certificate and client CA bundle with files returned by
+// LoadTLSFiles.
+func (s *CertificateStore) Use(files TLSFiles) {
+	s.cert.Store(files.cert)
+	s.clientAuth.Store(files.clientAuth)
+}
+
+func loadKeyPair(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificates: %v", err)
+	}
+	return &cert, nil
+}
+
+func loadClientAuth(caFile, mode string) (*clientAuth, error) {
+	if caFile == "" {
+		return nil, nil
+	}
+	pool, err := loadCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+	authType := tls.RequireAndVerifyClientCert
+	if mode == ClientAuthOptional {
+		authType = tls.VerifyClientCertIfGiven
+	}
+	return &clientAuth{pool: pool, mode: authType}, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (s *CertificateStore) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.cert.Load(), nil
+}
+
+// TLSConfig returns a tls.Config that always serves the current certificate
+// and verifies clients against the current CA bundle.
+func (s *CertificateStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: s.GetCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := &tls.Config{GetCertificate: s.GetCertificate}
+			if ca := s.clientAuth.Load(); ca != nil {
+				cfg.ClientCAs = ca.pool
+				cfg.ClientAuth = ca.mode
+			}
+			return cfg, nil
+		},
+	}
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", caFile)
+	}
+	return pool, nil
+}
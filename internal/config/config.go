@@ -3,7 +3,11 @@ package config
 import (
 	"crypto/tls"
 	"fmt"
+	"opamp-backend/internal/logging"
 	"os"
+	"path"
+	"slices"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -14,11 +18,114 @@ type Config struct {
 		TLS           struct {
 			CertFile string `yaml:"cert_file"`
 			KeyFile  string `yaml:"key_file"`
+			// ClientCAFile enables client certificate verification against
+			// the CA bundle it contains.
+			ClientCAFile string         `yaml:"client_ca_file"`
+			ClientAuth   string         `yaml:"client_auth"` // require (default) or optional
+			Identity     ClientIdentity `yaml:"identity"`
 		} `yaml:"tls"`
+		Auth       AgentAuthConfig  `yaml:"auth"`
+		Enrollment EnrollmentConfig `yaml:"enrollment"`
+		// Attach serves OpAMP on the API listener instead of listen_address.
+		Attach bool `yaml:"attach"`
+		// HTTPIdleTimeout removes agents polling over plain HTTP that have
+		// not polled for this long (default 5m).
+		HTTPIdleTimeout time.Duration `yaml:"http_idle_timeout"`
 	} `yaml:"opamp"`
 	API struct {
-		ListenAddress string `yaml:"listen_address"`
+		ListenAddress string     `yaml:"listen_address"`
+		Tokens        []APIToken `yaml:"tokens"`
+		TokenFile     string     `yaml:"token_file"`  // YAML file with a tokens list, re-read on reload
+		TokenStore    string     `yaml:"token_store"` // JSON file of minted tokens, managed through the API and CLI
+		OIDC          OIDCConfig `yaml:"oidc"`
+		Limits        APILimits  `yaml:"limits"`
 	} `yaml:"api"`
+	// ConnectionSettings are offered to agents to move them to another
+	// endpoint or rotate their credentials.
+	ConnectionSettings []ConnectionSettingsGroup `yaml:"connection_settings"`
+	// Certificates signs the client certificates agents request.
+	Certificates CertificatesConfig `yaml:"certificates"`
+	// CustomCapabilities are advertised to agents so that they send custom
+	// messages for them, in addition to those with a registered handler.
+	CustomCapabilities []string `yaml:"custom_capabilities"`
+	// Packages configures the package registry offered to agents.
+	Packages PackagesConfig `yaml:"packages"`
+	// Audit enables the append-only audit log of mutating API calls.
+	Audit struct {
+		File string `yaml:"file"` // JSON lines file; empty disables auditing
+	} `yaml:"audit"`
+	// ShutdownTimeout bounds how long Stop waits for connections and
+	// in-flight work to drain.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// Reload controls watching backend.yaml and the TLS files for changes.
+	Reload struct {
+		Watch    bool          `yaml:"watch"`
+		Interval time.Duration `yaml:"interval"`
+	} `yaml:"reload"`
+	Logging struct {
+		Level      string            `yaml:"level"`      // debug, info, warn or error
+		Format     string            `yaml:"format"`     // text or json
+		Subsystems map[string]string `yaml:"subsystems"` // per-subsystem level overrides
+	} `yaml:"logging"`
+}
+
+// Client certificate modes for opamp.tls.client_auth.
+const (
+	ClientAuthRequire  = "require"
+	ClientAuthOptional = "optional"
+)
+
+// Ways of matching a client certificate against the agent it belongs to.
+const (
+	IdentityMatchNone        = ""
+	IdentityMatchInstanceUID = "instance_uid"
+	IdentityMatchLabel       = "label"
+)
+
+// ClientIdentity configures how the subject common name or a SAN of a
+// verified client certificate must correspond to the connecting agent.
+type ClientIdentity struct {
+	Match string `yaml:"match"` // instance_uid, label, or empty to only record the identity
+	Label string `yaml:"label"` // agent description attribute compared when match is label
+}
+
+// AgentAuthConfig configures how agents authenticate when connecting to the
+// OpAMP endpoint. Each scheme is matched against the Authorization header.
+type AgentAuthConfig struct {
+	Enabled          bool              `yaml:"enabled"`
+	SharedSecrets    []string          `yaml:"shared_secrets"`    // Authorization: Secret-Key <secret>
+	EnrollmentTokens []EnrollmentToken `yaml:"enrollment_tokens"` // Authorization: Enrollment-Token <token>
+	BearerTokens     []BearerToken     `yaml:"bearer_tokens"`     // Authorization: Bearer <token>
+}
+
+// EnrollmentToken is a credential issued to a single agent.
+type EnrollmentToken struct {
+	Name      string    `yaml:"name"`
+	Token     string    `yaml:"token"`
+	AgentID   string    `yaml:"agent_id"`   // optional: the only agent ID allowed to use the token
+	ExpiresAt time.Time `yaml:"expires_at"` // optional
+}
+
+// BearerToken is a named credential that any agent may present.
+type BearerToken struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+}
+
+// EnrollmentConfig controls whether agents with unknown instance UIDs must be
+// approved before they are managed.
+type EnrollmentConfig struct {
+	Enabled     bool              `yaml:"enabled"`
+	AutoApprove []AutoApproveRule `yaml:"auto_approve"`
+	Store       string            `yaml:"store"` // JSON file of enrollment records, read at startup
+}
+
+// AutoApproveRule approves pending agents that match every criterion it sets.
+// Values are glob patterns as accepted by path.Match.
+type AutoApproveRule struct {
+	Name       string            `yaml:"name"`
+	Attributes map[string]string `yaml:"attributes"`  // AgentDescription attributes
+	ClientCert string            `yaml:"client_cert"` // client certificate common name or SAN
 }
 
 func LoadConfig(path string) (Config, error) {
@@ -32,10 +139,156 @@ func LoadConfig(path string) (Config, error) {
 	return cfg, err
 }
 
+// TLSConfig loads the OpAMP server certificate and client CA bundle and
+// returns a tls.Config serving them. Use NewCertificateStore directly to
+// reload them later.
 func (c *Config) TLSConfig() (*tls.Config, error) {
-	cert, err := tls.LoadX509KeyPair(c.OpAMP.TLS.CertFile, c.OpAMP.TLS.KeyFile)
+	store, err := NewCertificateStore(c.OpAMP.TLS.CertFile, c.OpAMP.TLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.LoadClientCAs(c.OpAMP.TLS.ClientCAFile, c.OpAMP.TLS.ClientAuth); err != nil {
+		return nil, err
+	}
+	return store.TLSConfig(), nil
+}
+
+// TLSEnabled reports whether the OpAMP listener is configured to use TLS.
+func (c *Config) TLSEnabled() bool {
+	return c.OpAMP.TLS.CertFile != "" && c.OpAMP.TLS.KeyFile != ""
+}
+
+// Validate checks the configuration for values that cannot be applied.
+func (c *Config) Validate() error {
+	if _, err := logging.ParseLevel(c.Logging.Level); err != nil {
+		return err
+	}
+	for subsystem, level := range c.Logging.Subsystems {
+		if !slices.Contains(logging.Subsystems(), subsystem) {
+			return fmt.Errorf("logging.subsystems: unknown subsystem %q", subsystem)
+		}
+		if _, err := logging.ParseLevel(level); err != nil {
+			return fmt.Errorf("logging.subsystems.%s: %v", subsystem, err)
+		}
+	}
+	switch c.Logging.Format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("invalid log format %q (expected text or json)", c.Logging.Format)
+	}
+
+	if (c.OpAMP.TLS.CertFile == "") != (c.OpAMP.TLS.KeyFile == "") {
+		return fmt.Errorf("opamp.tls requires both cert_file and key_file")
+	}
+	if c.TLSEnabled() {
+		if _, err := tls.LoadX509KeyPair(c.OpAMP.TLS.CertFile, c.OpAMP.TLS.KeyFile); err != nil {
+			return fmt.Errorf("failed to load TLS certificates: %v", err)
+		}
+	}
+	if c.OpAMP.TLS.ClientCAFile != "" {
+		if !c.TLSEnabled() {
+			return fmt.Errorf("opamp.tls.client_ca_file requires cert_file and key_file")
+		}
+		if _, err := loadCertPool(c.OpAMP.TLS.ClientCAFile); err != nil {
+			return err
+		}
+	}
+	switch c.OpAMP.TLS.ClientAuth {
+	case "", ClientAuthRequire, ClientAuthOptional:
+	default:
+		return fmt.Errorf("invalid opamp.tls.client_auth %q (expected require or optional)", c.OpAMP.TLS.ClientAuth)
+	}
+	switch c.OpAMP.TLS.Identity.Match {
+	case IdentityMatchNone, IdentityMatchInstanceUID:
+	case IdentityMatchLabel:
+		if c.OpAMP.TLS.Identity.Label == "" {
+			return fmt.Errorf("opamp.tls.identity.label is required when match is label")
+		}
+	default:
+		return fmt.Errorf("invalid opamp.tls.identity.match %q (expected instance_uid or label)", c.OpAMP.TLS.Identity.Match)
+	}
+	if c.OpAMP.TLS.Identity.Match != IdentityMatchNone && c.OpAMP.TLS.ClientCAFile == "" {
+		return fmt.Errorf("opamp.tls.identity requires client_ca_file")
+	}
+
+	if c.OpAMP.Auth.Enabled {
+		auth := c.OpAMP.Auth
+		if len(auth.SharedSecrets) == 0 && len(auth.EnrollmentTokens) == 0 && len(auth.BearerTokens) == 0 {
+			return fmt.Errorf("opamp.auth is enabled but no credentials are configured")
+		}
+		for _, secret := range auth.SharedSecrets {
+			if secret == "" {
+				return fmt.Errorf("opamp.auth.shared_secrets must not contain empty values")
+			}
+		}
+		for i, token := range auth.EnrollmentTokens {
+			if token.Token == "" {
+				return fmt.Errorf("opamp.auth.enrollment_tokens[%d] has no token", i)
+			}
+		}
+		for i, token := range auth.BearerTokens {
+			if token.Token == "" {
+				return fmt.Errorf("opamp.auth.bearer_tokens[%d] has no token", i)
+			}
+		}
+	}
+
+	for i, rule := range c.OpAMP.Enrollment.AutoApprove {
+		if len(rule.Attributes) == 0 && rule.ClientCert == "" {
+			return fmt.Errorf("opamp.enrollment.auto_approve[%d] must set attributes or client_cert", i)
+		}
+		patterns := []string{rule.ClientCert}
+		for _, pattern := range rule.Attributes {
+			patterns = append(patterns, pattern)
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("opamp.enrollment.auto_approve[%d]: invalid pattern %q", i, pattern)
+			}
+		}
+	}
+
+	tokens, err := c.APITokens()
 	if err != nil {
-		return nil, fmt.Errorf("failed to load TLS certificates: %v", err)
+		return fmt.Errorf("api.token_file: %v", err)
+	}
+	if err := validateAPITokens(tokens); err != nil {
+		return err
+	}
+	if err := c.API.OIDC.validate(); err != nil {
+		return err
+	}
+	if err := c.API.Limits.validate(); err != nil {
+		return err
+	}
+	if err := validateConnectionSettings(c.ConnectionSettings); err != nil {
+		return err
+	}
+	if err := c.Certificates.validate(); err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(c.CustomCapabilities))
+	for i, capability := range c.CustomCapabilities {
+		if capability == "" {
+			return fmt.Errorf("custom_capabilities[%d] is empty", i)
+		}
+		if seen[capability] {
+			return fmt.Errorf("custom capability %q is listed more than once", capability)
+		}
+		seen[capability] = true
+	}
+	if err := c.Packages.validate(); err != nil {
+		return err
+	}
+
+	if c.OpAMP.HTTPIdleTimeout < 0 {
+		return fmt.Errorf("opamp.http_idle_timeout must not be negative")
+	}
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown_timeout must not be negative")
+	}
+	if c.Reload.Interval < 0 {
+		return fmt.Errorf("reload.interval must not be negative")
 	}
-	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+	return nil
 }
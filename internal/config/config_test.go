@@ -1,7 +1,18 @@
 package config
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestTLSConfig_InvalidFiles(t *testing.T) {
@@ -15,3 +26,281 @@ func TestTLSConfig_InvalidFiles(t *testing.T) {
 		t.Error("Expected error when certificate files do not exist")
 	}
 }
+
+// writeTestCertificate writes a self-signed certificate and key with the given
+// common name and returns their paths.
+func writeTestCertificate(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestCertificateStore_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certA, keyA := writeTestCertificate(t, dir, "a")
+	certB, keyB := writeTestCertificate(t, dir, "b")
+
+	store, err := NewCertificateStore(certA, keyA)
+	if err != nil {
+		t.Fatalf("NewCertificateStore error: %v", err)
+	}
+
+	if err := store.Load(certB, keyB); err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	cert, _ := store.GetCertificate(nil)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != "b" {
+		t.Errorf("Expected reloaded certificate, got CN %q", leaf.Subject.CommonName)
+	}
+
+	// A failed load keeps the current certificate.
+	if err := store.Load("nonexistent.crt", "nonexistent.key"); err == nil {
+		t.Error("Expected error when certificate files do not exist")
+	}
+	if current, _ := store.GetCertificate(nil); current != cert {
+		t.Error("Expected certificate to be unchanged after failed load")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var cfg Config
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected empty config to be valid, got %v", err)
+	}
+
+	cfg.Logging.Level = "verbose"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for invalid log level")
+	}
+
+	cfg = Config{}
+	cfg.OpAMP.TLS.CertFile = "server.crt"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error when only cert_file is set")
+	}
+}
+
+// TestShippedConfig keeps the example backend.yaml loadable and valid.
+func TestShippedConfig(t *testing.T) {
+	cfg, err := LoadConfig("../../config/backend.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load config/backend.yaml: %v", err)
+	}
+	// The server certificate is generated by "make cert" rather than shipped
+	cfg.OpAMP.TLS.CertFile, cfg.OpAMP.TLS.KeyFile = writeTestCertificate(t, t.TempDir(), "server")
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected config/backend.yaml to be valid, got %v", err)
+	}
+	if cfg.API.Limits.MaxBodyBytes["/api/packages"] == 0 {
+		t.Error("Expected a body size limit for /api/packages")
+	}
+}
+
+func TestValidate_APITokens(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "tokens.yaml")
+	if err := os.WriteFile(tokenFile, []byte(`
+tokens:
+  - name: "ci"
+    token: "ci-token"
+    scopes: ["read", "loglevel"]
+    agents:
+      labels:
+        deployment.environment: "staging"
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg Config
+	cfg.API.Tokens = []APIToken{{Name: "ops", Token: "ops-token", Scopes: []string{ScopeAdmin}}}
+	cfg.API.TokenFile = tokenFile
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected tokens to be valid, got %v", err)
+	}
+	tokens, err := cfg.APITokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[1].Agents.Labels["deployment.environment"] != "staging" {
+		t.Errorf("Expected inline and file tokens, got %+v", tokens)
+	}
+
+	for _, token := range []APIToken{
+		{Name: "ops", Token: "other", Scopes: []string{ScopeRead}},
+		{Name: "bad-scope", Token: "t", Scopes: []string{"write-everything"}},
+		{Name: "no-scope", Token: "t"},
+		{Name: "both", Token: "t", TokenHash: "sha256:00:00", Scopes: []string{ScopeRead}},
+		{Name: "bad-hash", TokenHash: "sha256:not-hex:00", Scopes: []string{ScopeRead}},
+	} {
+		cfg.API.Tokens = []APIToken{{Name: "ops", Token: "ops-token", Scopes: []string{ScopeAdmin}}, token}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected error for token %+v", token)
+		}
+	}
+}
+
+func TestValidate_OIDC(t *testing.T) {
+	var cfg Config
+	cfg.API.OIDC = OIDCConfig{
+		Enabled:     true,
+		Issuer:      "https://sso.example.com",
+		Audience:    "opamp-backend",
+		JWKSURL:     "https://sso.example.com/jwks.json",
+		GroupScopes: map[string][]string{"sre": {ScopeAdmin}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected OIDC config to be valid, got %v", err)
+	}
+
+	cfg.API.OIDC.JWKSFile = "jwks.json"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error when both jwks_file and jwks_url are set")
+	}
+
+	cfg.API.OIDC.JWKSFile = ""
+	cfg.API.OIDC.GroupScopes["dev"] = []string{"superuser"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for unknown scope in group_scopes")
+	}
+}
+
+func TestAPILimits(t *testing.T) {
+	var limits APILimits
+	if got := limits.BodyLimit("/api/config"); got != DefaultMaxConfigBodyBytes {
+		t.Errorf("default /api/config limit = %d, want %d", got, DefaultMaxConfigBodyBytes)
+	}
+	if got := limits.BodyLimit("/api/loglevel"); got != DefaultMaxBodyBytes {
+		t.Errorf("default /api/loglevel limit = %d, want %d", got, DefaultMaxBodyBytes)
+	}
+
+	limits.MaxBodyBytes = map[string]int64{"default": 1024, "/api/config": 4096}
+	if got := limits.BodyLimit("/api/loglevel"); got != 1024 {
+		t.Errorf("configured default limit = %d, want 1024", got)
+	}
+	if got := limits.BodyLimit("/api/config"); got != 4096 {
+		t.Errorf("configured /api/config limit = %d, want 4096", got)
+	}
+
+	var cfg Config
+	cfg.API.Limits = APILimits{RequestsPerSecond: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for a negative rate")
+	}
+	cfg.API.Limits = APILimits{MaxBodyBytes: map[string]int64{"/api/config": 0}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for a zero body limit")
+	}
+}
+
+func TestValidate_ConnectionSettings(t *testing.T) {
+	valid := ConnectionSettingsGroup{
+		Name:  "migrate",
+		OpAMP: &OpAMPDestination{Destination: Destination{Endpoint: "wss://backend-2:4320/v1/opamp"}},
+	}
+	var cfg Config
+	cfg.ConnectionSettings = []ConnectionSettingsGroup{valid}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid connection settings, got %v", err)
+	}
+
+	cases := map[string]ConnectionSettingsGroup{
+		"no name":            {OpAMP: valid.OpAMP},
+		"no settings":        {Name: "empty"},
+		"bad scheme":         {Name: "ftp", OpAMP: &OpAMPDestination{Destination: Destination{Endpoint: "ftp://backend-2"}}},
+		"missing CA":         {Name: "ca", OpAMP: &OpAMPDestination{Destination: Destination{Endpoint: "wss://backend-2", CAFile: "missing.crt"}}},
+		"negative heartbeat": {Name: "hb", OpAMP: &OpAMPDestination{Destination: valid.OpAMP.Destination, HeartbeatInterval: -1}},
+		"websocket metrics":  {Name: "metrics", OwnMetrics: &Destination{Endpoint: "wss://otlp:4318"}},
+		"bad traces URL":     {Name: "traces", OwnTraces: &Destination{Endpoint: "otlp:4318"}},
+	}
+	for name, group := range cases {
+		cfg.ConnectionSettings = []ConnectionSettingsGroup{group}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	cfg.ConnectionSettings = []ConnectionSettingsGroup{valid, valid}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for duplicate group names")
+	}
+
+	cfg.ConnectionSettings = []ConnectionSettingsGroup{{Name: "logs", OwnLogs: &Destination{Endpoint: "https://otlp:4318/v1/logs"}}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected a group with only own_logs to be valid, got %v", err)
+	}
+}
+
+func TestValidate_Certificates(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir(), "not-a-ca")
+	valid := CertificatesConfig{CACertFile: certFile, CAKeyFile: keyFile, Endpoint: "wss://backend:4320/v1/opamp"}
+
+	cases := map[string]struct {
+		change func(*CertificatesConfig)
+		want   string
+	}{
+		"key without cert":   {func(c *CertificatesConfig) { c.CACertFile = "" }, "requires ca_cert_file"},
+		"missing key":        {func(c *CertificatesConfig) { c.CAKeyFile = "" }, "ca_key_file is required"},
+		"no endpoint":        {func(c *CertificatesConfig) { c.Endpoint = "" }, "certificates.endpoint"},
+		"renewal too long":   {func(c *CertificatesConfig) { c.Validity, c.RenewBefore = time.Hour, time.Hour }, "shorter than validity"},
+		"unknown approval":   {func(c *CertificatesConfig) { c.Approval = "always" }, "certificates.approval"},
+		"not a CA":           {func(c *CertificatesConfig) {}, "not a CA certificate"},
+		"unreadable CA file": {func(c *CertificatesConfig) { c.CACertFile = "missing.crt" }, "failed to load"},
+	}
+	for name, tc := range cases {
+		var cfg Config
+		cfg.Certificates = valid
+		tc.change(&cfg.Certificates)
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", name, tc.want, err)
+		}
+	}
+
+	if got := (CertificatesConfig{Validity: 90 * time.Hour}).RenewalPeriod(); got != 30*time.Hour {
+		t.Errorf("Expected renewal a third of the validity before expiry, got %v", got)
+	}
+}
+
+func TestValidate_CustomCapabilities(t *testing.T) {
+	var cfg Config
+	cfg.CustomCapabilities = []string{"io.opentelemetry.pprof", "com.example.inventory"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid custom capabilities, got %v", err)
+	}
+	for _, capabilities := range [][]string{{""}, {"com.example.a", "com.example.a"}} {
+		cfg.CustomCapabilities = capabilities
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected an error for custom capabilities %q", capabilities)
+		}
+	}
+}
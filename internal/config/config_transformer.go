@@ -2,13 +2,14 @@ package config
 
 import (
 	"fmt"
-	"log"
 	"opamp-backend/internal/common"
-	"strings"
+	"opamp-backend/internal/logging"
 
 	"gopkg.in/yaml.v2"
 )
 
+var logger = logging.For(logging.SubsystemConfig)
+
 // CollectorConfig represents a simplified structure of the OpenTelemetry collector configuration.
 type CollectorConfig struct {
 	Logging struct {
@@ -31,28 +32,25 @@ func GetCurrentCollectorConfig(agentID string) (string, error) {
 
 	// If we have effective configuration reported by the agent, use it
 	if agent.EffectiveConfig != "" {
-		log.Printf("Using agent-reported effective configuration for agent %s", agentID)
+		logger.Debug("Using agent-reported effective configuration", "agent_id", agentID)
 		return agent.EffectiveConfig, nil
 	}
 
 	// If not, use the last known configuration we sent
 	if agent.Config != "" {
-		log.Printf("Using last sent configuration for agent %s", agentID)
+		logger.Debug("Using last sent configuration", "agent_id", agentID)
 		return agent.Config, nil
 	}
 
 	// If we have nothing stored, return a default configuration
-	log.Printf("Using default configuration for agent %s (no stored config found)", agentID)
+	logger.Info("Using default configuration (no stored config found)", "agent_id", agentID)
 	defaultConfig := getDefaultConfig()
 	return defaultConfig, nil
 }
 
 // UpdateLogLevelInConfig updates the log level in the given configuration.
 func UpdateLogLevelInConfig(originalConfig string, newLogLevel string) (string, error) {
-	// Log the first few lines of the original config for debugging
-	lines := strings.Split(originalConfig, "\n")
-	preview := strings.Join(lines[:min(10, len(lines))], "\n")
-	log.Printf("Updating log level to %s in config: \n%s...", newLogLevel, preview)
+	logger.Debug("Updating log level in config", "log_level", newLogLevel, "config_bytes", len(originalConfig))
 
 	// Parse the original YAML
 	var configMap map[string]interface{}
@@ -90,22 +88,9 @@ func UpdateLogLevelInConfig(originalConfig string, newLogLevel string) (string,
 		return "", fmt.Errorf("failed to marshal updated config: %v", err)
 	}
 
-	// Log a preview of the updated config
-	updatedLines := strings.Split(string(updated), "\n")
-	updatedPreview := strings.Join(updatedLines[:min(10, len(updatedLines))], "\n")
-	log.Printf("Updated config preview: \n%s...", updatedPreview)
-
 	return string(updated), nil
 }
 
-// min returns the minimum of two integers
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
-
 // getDefaultConfig returns the default collector configuration
 func getDefaultConfig() string {
 	defaultConfig := `receivers:
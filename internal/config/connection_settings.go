@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"opamp-backend/internal/agents"
+	"time"
+)
+
+// ConnectionSettingsGroup offers connection settings to the agents matched by
+// Agents. Where several groups match an agent, later groups take precedence
+// for each destination they set.
+type ConnectionSettingsGroup struct {
+	Name   string            `yaml:"name"`
+	Agents agents.Selector   `yaml:"agents"` // empty for every agent
+	OpAMP  *OpAMPDestination `yaml:"opamp"`
+	// Destinations for the agents' own telemetry
+	OwnMetrics *Destination `yaml:"own_metrics"`
+	OwnTraces  *Destination `yaml:"own_traces"`
+	OwnLogs    *Destination `yaml:"own_logs"`
+}
+
+// Destination is an endpoint that agents connect to.
+type Destination struct {
+	Endpoint string            `yaml:"endpoint"`
+	Headers  map[string]string `yaml:"headers"`
+	CAFile   string            `yaml:"ca_file"` // PEM bundle used to verify the endpoint's certificate
+}
+
+// OpAMPDestination is an OpAMP server that agents connect to.
+type OpAMPDestination struct {
+	Destination       `yaml:",inline"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+}
+
+// CAFiles lists the CA files the group's destinations refer to.
+func (g ConnectionSettingsGroup) CAFiles() []string {
+	var files []string
+	if g.OpAMP != nil && g.OpAMP.CAFile != "" {
+		files = append(files, g.OpAMP.CAFile)
+	}
+	for _, destination := range []*Destination{g.OwnMetrics, g.OwnTraces, g.OwnLogs} {
+		if destination != nil && destination.CAFile != "" {
+			files = append(files, destination.CAFile)
+		}
+	}
+	return files
+}
+
+func (d Destination) validate(field string, schemes ...string) error {
+	u, err := url.Parse(d.Endpoint)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%s.endpoint: invalid URL %q", field, d.Endpoint)
+	}
+	valid := false
+	for _, scheme := range schemes {
+		valid = valid || u.Scheme == scheme
+	}
+	if !valid {
+		return fmt.Errorf("%s.endpoint: unsupported scheme %q", field, u.Scheme)
+	}
+	if d.CAFile != "" {
+		if _, err := loadCertPool(d.CAFile); err != nil {
+			return fmt.Errorf("%s.ca_file: %v", field, err)
+		}
+	}
+	return nil
+}
+
+func validateConnectionSettings(groups []ConnectionSettingsGroup) error {
+	names := make(map[string]bool, len(groups))
+	for i, group := range groups {
+		if group.Name == "" {
+			return fmt.Errorf("connection_settings[%d] has no name", i)
+		}
+		if names[group.Name] {
+			return fmt.Errorf("connection_settings %q is defined more than once", group.Name)
+		}
+		names[group.Name] = true
+
+		field := fmt.Sprintf("connection_settings.%s", group.Name)
+		if group.OpAMP == nil && group.OwnMetrics == nil && group.OwnTraces == nil && group.OwnLogs == nil {
+			return fmt.Errorf("%s offers no settings", field)
+		}
+		if group.OpAMP != nil {
+			if err := group.OpAMP.validate(field+".opamp", "ws", "wss", "http", "https"); err != nil {
+				return err
+			}
+			if group.OpAMP.HeartbeatInterval < 0 {
+				return fmt.Errorf("%s.opamp.heartbeat_interval must not be negative", field)
+			}
+		}
+		for _, own := range []struct {
+			name        string
+			destination *Destination
+		}{{"own_metrics", group.OwnMetrics}, {"own_traces", group.OwnTraces}, {"own_logs", group.OwnLogs}} {
+			if own.destination == nil {
+				continue
+			}
+			if err := own.destination.validate(field+"."+own.name, "http", "https"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
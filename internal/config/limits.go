@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"math"
+)
+
+// Default request body limits, used for routes without a max_body_bytes entry.
+const (
+	DefaultMaxBodyBytes       int64 = 64 << 10
+	DefaultMaxConfigBodyBytes int64 = 1 << 20
+	DefaultMaxPackageBytes    int64 = 512 << 20
+	DefaultMaxFanOuts               = 2
+)
+
+// APILimits throttles REST API callers. Rates are in requests per second;
+// zero disables the corresponding limit.
+type APILimits struct {
+	RequestsPerSecond       float64          `yaml:"requests_per_second"`        // per caller (token or OIDC user)
+	Burst                   int              `yaml:"burst"`                      // per caller
+	GlobalRequestsPerSecond float64          `yaml:"global_requests_per_second"` // across all callers
+	GlobalBurst             int              `yaml:"global_burst"`               // across all callers
+	MaxBodyBytes            map[string]int64 `yaml:"max_body_bytes"`             // per route path; "default" for the rest
+	MaxConcurrentFanOuts    int              `yaml:"max_concurrent_fan_outs"`    // concurrent calls that push to every agent
+}
+
+// BodyLimit returns the maximum request body size for a route.
+func (l APILimits) BodyLimit(path string) int64 {
+	if n, ok := l.MaxBodyBytes[path]; ok {
+		return n
+	}
+	if n, ok := l.MaxBodyBytes["default"]; ok {
+		return n
+	}
+	switch path {
+	case "/api/config":
+		return DefaultMaxConfigBodyBytes
+	case "/api/packages":
+		return DefaultMaxPackageBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+// FanOuts returns the number of fan-out calls allowed to run at once.
+func (l APILimits) FanOuts() int {
+	if l.MaxConcurrentFanOuts > 0 {
+		return l.MaxConcurrentFanOuts
+	}
+	return DefaultMaxFanOuts
+}
+
+// BurstFor returns burst, or the rate rounded up when no burst is set.
+func BurstFor(rate float64, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+	return int(math.Max(1, math.Ceil(rate)))
+}
+
+func (l APILimits) validate() error {
+	if l.RequestsPerSecond < 0 || l.GlobalRequestsPerSecond < 0 || l.Burst < 0 || l.GlobalBurst < 0 {
+		return fmt.Errorf("api.limits rates and bursts must not be negative")
+	}
+	if l.MaxConcurrentFanOuts < 0 {
+		return fmt.Errorf("api.limits.max_concurrent_fan_outs must not be negative")
+	}
+	for path, n := range l.MaxBodyBytes {
+		if n <= 0 {
+			return fmt.Errorf("api.limits.max_body_bytes.%s must be positive", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// OIDCConfig configures validation of JWT bearer tokens issued by an OpenID
+// Connect provider for the REST API.
+type OIDCConfig struct {
+	Enabled       bool                `yaml:"enabled"`
+	Issuer        string              `yaml:"issuer"`         // required "iss" claim
+	Audience      string              `yaml:"audience"`       // required entry in the "aud" claim
+	JWKSFile      string              `yaml:"jwks_file"`      // signing keys from a local JWKS document
+	JWKSURL       string              `yaml:"jwks_url"`       // or fetched from the provider
+	JWKSRefresh   time.Duration       `yaml:"jwks_refresh"`   // how long fetched keys are cached (default 15m)
+	UsernameClaim string              `yaml:"username_claim"` // claim naming the caller (default "sub")
+	GroupsClaim   string              `yaml:"groups_claim"`   // claim listing the caller's groups (default "groups")
+	GroupScopes   map[string][]string `yaml:"group_scopes"`   // scopes granted to each group
+}
+
+func (o OIDCConfig) validate() error {
+	if !o.Enabled {
+		return nil
+	}
+	if o.Issuer == "" || o.Audience == "" {
+		return fmt.Errorf("api.oidc requires issuer and audience")
+	}
+	if (o.JWKSFile == "") == (o.JWKSURL == "") {
+		return fmt.Errorf("api.oidc requires exactly one of jwks_file or jwks_url")
+	}
+	if o.JWKSRefresh < 0 {
+		return fmt.Errorf("api.oidc.jwks_refresh must not be negative")
+	}
+	for group, scopes := range o.GroupScopes {
+		for _, scope := range scopes {
+			if !slices.Contains(Scopes, scope) {
+				return fmt.Errorf("api.oidc.group_scopes.%s: unknown scope %q", group, scope)
+			}
+		}
+	}
+	return nil
+}
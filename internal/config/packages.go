@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// PackagesConfig enables the package registry offered to agents.
+type PackagesConfig struct {
+	Dir string `yaml:"dir"` // registry directory; empty disables packages
+	// DownloadURL is the base URL of the API listener as agents reach it;
+	// packages are served under <download_url>/packages/.
+	DownloadURL string `yaml:"download_url"`
+}
+
+// Enabled reports whether the package registry is configured.
+func (p PackagesConfig) Enabled() bool {
+	return p.Dir != ""
+}
+
+// BaseURL returns DownloadURL without a trailing slash.
+func (p PackagesConfig) BaseURL() string {
+	return strings.TrimSuffix(p.DownloadURL, "/")
+}
+
+func (p PackagesConfig) validate() error {
+	if !p.Enabled() {
+		return nil
+	}
+	if p.DownloadURL == "" {
+		return fmt.Errorf("packages.download_url is required when packages.dir is set")
+	}
+	u, err := url.Parse(p.DownloadURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid packages.download_url %q (expected an http or https URL)", p.DownloadURL)
+	}
+	return nil
+}
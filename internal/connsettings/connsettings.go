@@ -0,0 +1,212 @@
+// Package connsettings builds the ConnectionSettingsOffers sent to agents
+// from the connection_settings groups in backend.yaml.
+package connsettings
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/config"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"google.golang.org/protobuf/proto"
+)
+
+// group is a connection settings group with its CA files loaded.
+type group struct {
+	agents     agents.Selector
+	opamp      *protobufs.OpAMPConnectionSettings
+	ownMetrics *protobufs.TelemetryConnectionSettings
+	ownTraces  *protobufs.TelemetryConnectionSettings
+	ownLogs    *protobufs.TelemetryConnectionSettings
+}
+
+// Offers holds the configured connection settings groups.
+type Offers struct {
+	mu     sync.RWMutex
+	groups []group
+}
+
+// New loads the CA files of groups.
+func New(groups []config.ConnectionSettingsGroup) (*Offers, error) {
+	o := &Offers{}
+	if err := o.Update(groups); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Update replaces the groups, for example after a reload. Nothing is changed
+// if a CA file cannot be read.
+func (o *Offers) Update(groups []config.ConnectionSettingsGroup) error {
+	loaded, err := Load(groups)
+	if err != nil {
+		return err
+	}
+	o.Use(loaded)
+	return nil
+}
+
+// Groups are connection settings groups with their CA files loaded.
+type Groups []group
+
+// Load loads the CA files of groups without changing any Offers.
+func Load(groups []config.ConnectionSettingsGroup) (Groups, error) {
+	loaded := make(Groups, 0, len(groups))
+	for _, g := range groups {
+		loadedGroup := group{agents: g.Agents}
+		if g.OpAMP != nil {
+			certificate, err := caCertificate(g.OpAMP.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("connection_settings.%s.opamp: %v", g.Name, err)
+			}
+			loadedGroup.opamp = &protobufs.OpAMPConnectionSettings{
+				DestinationEndpoint:      g.OpAMP.Endpoint,
+				Headers:                  headers(g.OpAMP.Headers),
+				Certificate:              certificate,
+				HeartbeatIntervalSeconds: uint64(g.OpAMP.HeartbeatInterval.Seconds()),
+			}
+		}
+		var err error
+		if loadedGroup.ownMetrics, err = telemetrySettings(g.OwnMetrics); err != nil {
+			return nil, fmt.Errorf("connection_settings.%s.own_metrics: %v", g.Name, err)
+		}
+		if loadedGroup.ownTraces, err = telemetrySettings(g.OwnTraces); err != nil {
+			return nil, fmt.Errorf("connection_settings.%s.own_traces: %v", g.Name, err)
+		}
+		if loadedGroup.ownLogs, err = telemetrySettings(g.OwnLogs); err != nil {
+			return nil, fmt.Errorf("connection_settings.%s.own_logs: %v", g.Name, err)
+		}
+		loaded = append(loaded, loadedGroup)
+	}
+	return loaded, nil
+}
+
+// Use replaces the groups with groups returned by Load.
+func (o *Offers) Use(groups Groups) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.groups = groups
+}
+
+// Enabled reports whether any connection settings are configured.
+func (o *Offers) Enabled() bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return len(o.groups) > 0
+}
+
+// For returns the settings offered to agent, leaving out those it has not
+// advertised the capability to accept, or nil if there are none. The offer's
+// Hash identifies its content.
+func (o *Offers) For(agent *agents.Agent) *protobufs.ConnectionSettingsOffers {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	offers := &protobufs.ConnectionSettingsOffers{}
+	for _, g := range o.groups {
+		if !g.agents.Matches(agent) {
+			continue
+		}
+		if g.opamp != nil && agent.HasCapability(protobufs.AgentCapabilities_AgentCapabilities_AcceptsOpAMPConnectionSettings) {
+			offers.Opamp = g.opamp
+		}
+		if g.ownMetrics != nil && agent.HasCapability(protobufs.AgentCapabilities_AgentCapabilities_ReportsOwnMetrics) {
+			offers.OwnMetrics = g.ownMetrics
+		}
+		if g.ownTraces != nil && agent.HasCapability(protobufs.AgentCapabilities_AgentCapabilities_ReportsOwnTraces) {
+			offers.OwnTraces = g.ownTraces
+		}
+		if g.ownLogs != nil && agent.HasCapability(protobufs.AgentCapabilities_AgentCapabilities_ReportsOwnLogs) {
+			offers.OwnLogs = g.ownLogs
+		}
+	}
+	if offers.Opamp == nil && !HasOwnTelemetry(offers) {
+		return nil
+	}
+	return hashed(offers)
+}
+
+// WithCertificate returns a copy of offers, which may be nil, that also
+// offers certificate for the OpAMP connection. endpoint is used if offers
+// do not move the agent to another OpAMP endpoint.
+func WithCertificate(offers *protobufs.ConnectionSettingsOffers, endpoint string, certificate *protobufs.TLSCertificate) *protobufs.ConnectionSettingsOffers {
+	if offers == nil {
+		offers = &protobufs.ConnectionSettingsOffers{}
+	} else {
+		// Group settings are shared between offers
+		offers = proto.Clone(offers).(*protobufs.ConnectionSettingsOffers)
+	}
+	if offers.Opamp == nil {
+		offers.Opamp = &protobufs.OpAMPConnectionSettings{DestinationEndpoint: endpoint}
+	}
+	offers.Opamp.Certificate = certificate
+	return hashed(offers)
+}
+
+// hashed sets the Hash of offers to identify their content.
+func hashed(offers *protobufs.ConnectionSettingsOffers) *protobufs.ConnectionSettingsOffers {
+	offers.Hash = nil
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(offers)
+	if err != nil {
+		return nil
+	}
+	hash := sha256.Sum256(data)
+	offers.Hash = hash[:]
+	return offers
+}
+
+// HasOwnTelemetry reports whether offers direct the agent's own telemetry.
+func HasOwnTelemetry(offers *protobufs.ConnectionSettingsOffers) bool {
+	return offers.GetOwnMetrics() != nil || offers.GetOwnTraces() != nil || offers.GetOwnLogs() != nil
+}
+
+// telemetrySettings converts an own telemetry destination, or returns nil if
+// it is not set.
+func telemetrySettings(destination *config.Destination) (*protobufs.TelemetryConnectionSettings, error) {
+	if destination == nil {
+		return nil, nil
+	}
+	certificate, err := caCertificate(destination.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &protobufs.TelemetryConnectionSettings{
+		DestinationEndpoint: destination.Endpoint,
+		Headers:             headers(destination.Headers),
+		Certificate:         certificate,
+	}, nil
+}
+
+// headers converts a header map, sorted by name so that offers hash stably.
+func headers(values map[string]string) *protobufs.Headers {
+	if len(values) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	h := &protobufs.Headers{}
+	for _, key := range keys {
+		h.Headers = append(h.Headers, &protobufs.Header{Key: key, Value: values[key]})
+	}
+	return h
+}
+
+// caCertificate reads a PEM CA bundle into a TLSCertificate, or returns nil
+// if caFile is empty.
+func caCertificate(caFile string) (*protobufs.TLSCertificate, error) {
+	if caFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &protobufs.TLSCertificate{CaCert: data}, nil
+}
@@ -0,0 +1,94 @@
+package connsettings
+
+import (
+	"bytes"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+func TestOffersFor(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caFile, []byte("ca bundle"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	opamp := func(endpoint string) *config.OpAMPDestination {
+		return &config.OpAMPDestination{Destination: config.Destination{
+			Endpoint: endpoint,
+			Headers:  map[string]string{"Authorization": "Secret-Key new", "X-Tenant": "a"},
+			CAFile:   caFile,
+		}}
+	}
+	offers, err := New([]config.ConnectionSettingsGroup{
+		{Name: "all", OpAMP: opamp("wss://backend-2/v1/opamp")},
+		{Name: "eu", Agents: agents.Selector{Labels: map[string]string{"region": "eu"}}, OpAMP: opamp("wss://backend-eu/v1/opamp")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accepts := uint64(protobufs.AgentCapabilities_AgentCapabilities_AcceptsOpAMPConnectionSettings)
+	if got := offers.For(&agents.Agent{ID: "a"}); got != nil {
+		t.Errorf("Expected no offer for an agent without the capability, got %v", got)
+	}
+
+	us := offers.For(&agents.Agent{ID: "a", Capabilities: accepts})
+	eu := offers.For(&agents.Agent{ID: "b", Capabilities: accepts, Labels: map[string]string{"region": "eu"}})
+	if us.GetOpamp().GetDestinationEndpoint() != "wss://backend-2/v1/opamp" {
+		t.Errorf("Expected the fleet-wide endpoint, got %v", us.GetOpamp())
+	}
+	if eu.GetOpamp().GetDestinationEndpoint() != "wss://backend-eu/v1/opamp" {
+		t.Errorf("Expected the later, more specific group to win, got %v", eu.GetOpamp())
+	}
+	if string(us.Opamp.Certificate.GetCaCert()) != "ca bundle" {
+		t.Errorf("Expected the CA bundle to be offered, got %v", us.Opamp.Certificate)
+	}
+	if headers := us.Opamp.Headers.GetHeaders(); len(headers) != 2 || headers[0].Key != "Authorization" {
+		t.Errorf("Expected sorted headers, got %v", headers)
+	}
+	if len(us.Hash) == 0 || bytes.Equal(us.Hash, eu.Hash) {
+		t.Error("Expected offers with different content to hash differently")
+	}
+	if again := offers.For(&agents.Agent{ID: "c", Capabilities: accepts}); !bytes.Equal(again.Hash, us.Hash) {
+		t.Error("Expected the same offer to hash the same")
+	}
+}
+
+func TestOffersForOwnTelemetry(t *testing.T) {
+	offers, err := New([]config.ConnectionSettingsGroup{
+		{
+			Name:       "telemetry",
+			OwnMetrics: &config.Destination{Endpoint: "https://otlp:4318/v1/metrics"},
+			OwnLogs:    &config.Destination{Endpoint: "https://otlp:4318/v1/logs", Headers: map[string]string{"X-Tenant": "ops"}},
+		},
+		{Name: "eu-metrics", Agents: agents.Selector{Labels: map[string]string{"region": "eu"}}, OwnMetrics: &config.Destination{Endpoint: "https://otlp-eu:4318/v1/metrics"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricsOnly := offers.For(&agents.Agent{ID: "a", Capabilities: uint64(protobufs.AgentCapabilities_AgentCapabilities_ReportsOwnMetrics)})
+	if metricsOnly.GetOwnMetrics().GetDestinationEndpoint() != "https://otlp:4318/v1/metrics" || metricsOnly.OwnLogs != nil {
+		t.Errorf("Expected only the metrics destination for an agent reporting own metrics, got %v", metricsOnly)
+	}
+	if !HasOwnTelemetry(metricsOnly) || metricsOnly.Opamp != nil {
+		t.Errorf("Expected an own telemetry offer without OpAMP settings, got %v", metricsOnly)
+	}
+
+	both := uint64(protobufs.AgentCapabilities_AgentCapabilities_ReportsOwnMetrics | protobufs.AgentCapabilities_AgentCapabilities_ReportsOwnLogs)
+	eu := offers.For(&agents.Agent{ID: "b", Capabilities: both, Labels: map[string]string{"region": "eu"}})
+	if eu.GetOwnMetrics().GetDestinationEndpoint() != "https://otlp-eu:4318/v1/metrics" {
+		t.Errorf("Expected the group's metrics destination to win, got %v", eu.OwnMetrics)
+	}
+	if headers := eu.GetOwnLogs().GetHeaders().GetHeaders(); len(headers) != 1 || headers[0].Value != "ops" {
+		t.Errorf("Expected the fleet-wide logs destination to be kept, got %v", eu.OwnLogs)
+	}
+
+	if got := offers.For(&agents.Agent{ID: "c", Capabilities: uint64(protobufs.AgentCapabilities_AgentCapabilities_ReportsOwnTraces)}); got != nil {
+		t.Errorf("Expected no offer without a traces destination, got %v", got)
+	}
+}
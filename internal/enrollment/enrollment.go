@@ -0,0 +1,240 @@
+// Package enrollment tracks which agents are allowed to be managed. When
+// enrollment is enabled, agents with unknown instance UIDs wait in a pending
+// state until they are approved through the API or by an auto-approve rule.
+// Decisions are kept in a JSON store file so that they survive restarts.
+package enrollment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"opamp-backend/internal/common"
+	"opamp-backend/internal/config"
+	"opamp-backend/internal/logging"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var logger = logging.For(logging.SubsystemAgents)
+
+// Enrollment states.
+const (
+	StatePending  = "pending"
+	StateApproved = "approved"
+	StateRejected = "rejected"
+)
+
+// DecidedByAPI is recorded for decisions made through the API.
+const DecidedByAPI = "api"
+
+// Candidate is what an agent reports about itself when it is evaluated.
+type Candidate struct {
+	AgentID           string
+	Labels            map[string]string
+	ClientCertSubject string
+	ClientCertNames   []string // common name and SANs of the client certificate
+}
+
+// Registry holds the enrollment records of every agent seen, kept in the
+// store file if one is configured.
+type Registry struct {
+	mu      sync.RWMutex
+	cfg     config.EnrollmentConfig
+	path    string
+	records map[string]*common.EnrollmentRecord
+}
+
+// New creates a registry using cfg, loading the records kept in cfg.Store.
+func New(cfg config.EnrollmentConfig) (*Registry, error) {
+	r := &Registry{cfg: cfg, path: cfg.Store, records: make(map[string]*common.EnrollmentRecord)}
+	if r.path == "" {
+		return r, nil
+	}
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []*common.EnrollmentRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", r.path, err)
+	}
+	for _, record := range records {
+		r.records[record.AgentID] = record
+	}
+	return r, nil
+}
+
+// Update replaces the enrollment settings, for example after a reload.
+// Existing decisions are kept, and so is the store file, which is only read
+// at startup.
+func (r *Registry) Update(cfg config.EnrollmentConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cfg = cfg
+}
+
+// Enabled reports whether agents must be approved before they are managed.
+func (r *Registry) Enabled() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cfg.Enabled
+}
+
+// Evaluate returns the enrollment state of an agent, recording it as pending
+// the first time it is seen. Pending agents are checked against the
+// auto-approve rules on every call, since their description may arrive after
+// their first message. Agents are always approved when enrollment is disabled.
+func (r *Registry) Evaluate(c Candidate) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.cfg.Enabled {
+		return StateApproved
+	}
+
+	record, exists := r.records[c.AgentID]
+	if !exists {
+		record = &common.EnrollmentRecord{AgentID: c.AgentID, State: StatePending, RequestedAt: time.Now()}
+		r.records[c.AgentID] = record
+	}
+	if record.State != StatePending {
+		return record.State
+	}
+
+	changed := !exists || record.ClientCertSubject != c.ClientCertSubject || !maps.Equal(record.Labels, c.Labels)
+	record.Labels = c.Labels
+	record.ClientCertSubject = c.ClientCertSubject
+	for i, rule := range r.cfg.AutoApprove {
+		if ruleMatches(rule, c) {
+			name := rule.Name
+			if name == "" {
+				name = fmt.Sprintf("auto_approve[%d]", i)
+			}
+			now := time.Now()
+			record.State = StateApproved
+			record.DecidedAt = &now
+			record.DecidedBy = name
+			changed = true
+			break
+		}
+	}
+	if changed {
+		if err := r.save(); err != nil {
+			logger.Error("Failed to save enrollment records", "agent_id", c.AgentID, "operation", "enrollment", "error", err)
+		}
+	}
+	return record.State
+}
+
+// Approve marks an agent as approved.
+func (r *Registry) Approve(agentID string) (common.EnrollmentRecord, error) {
+	return r.decide(agentID, StateApproved)
+}
+
+// Reject marks an agent as rejected. Rejected agents are disconnected
+// whenever they connect.
+func (r *Registry) Reject(agentID string) (common.EnrollmentRecord, error) {
+	return r.decide(agentID, StateRejected)
+}
+
+func (r *Registry) decide(agentID, state string) (common.EnrollmentRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, exists := r.records[agentID]
+	if !exists {
+		return common.EnrollmentRecord{}, fmt.Errorf("agent %s has not requested enrollment", agentID)
+	}
+	now := time.Now()
+	record.State = state
+	record.DecidedAt = &now
+	record.DecidedBy = DecidedByAPI
+	if err := r.save(); err != nil {
+		return *record, fmt.Errorf("failed to save enrollment records: %w", err)
+	}
+	return *record, nil
+}
+
+// List returns the records in the given state, or all records if state is
+// empty, ordered by request time.
+func (r *Registry) List(state string) []common.EnrollmentRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	records := make([]common.EnrollmentRecord, 0, len(r.records))
+	for _, record := range r.records {
+		if state == "" || record.State == state {
+			records = append(records, *record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].RequestedAt.Before(records[j].RequestedAt)
+	})
+	return records
+}
+
+// save writes the records to the store file, if any. The caller holds mu.
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	records := make([]*common.EnrollmentRecord, 0, len(r.records))
+	for _, record := range r.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].AgentID < records[j].AgentID
+	})
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), ".enrollments-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+// ruleMatches reports whether every criterion set on rule matches c.
+func ruleMatches(rule config.AutoApproveRule, c Candidate) bool {
+	for key, pattern := range rule.Attributes {
+		value, ok := c.Labels[key]
+		if !ok || !globMatch(pattern, value) {
+			return false
+		}
+	}
+	if rule.ClientCert != "" {
+		for _, name := range c.ClientCertNames {
+			if globMatch(rule.ClientCert, name) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func globMatch(pattern, value string) bool {
+	ok, err := path.Match(pattern, value)
+	return err == nil && ok
+}
@@ -0,0 +1,129 @@
+package enrollment
+
+import (
+	"encoding/json"
+	"opamp-backend/internal/config"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEvaluate_Disabled(t *testing.T) {
+	r := newRegistry(t, config.EnrollmentConfig{})
+	if state := r.Evaluate(Candidate{AgentID: "a"}); state != StateApproved {
+		t.Errorf("expected %s, got %s", StateApproved, state)
+	}
+	if len(r.List("")) != 0 {
+		t.Error("expected no records while enrollment is disabled")
+	}
+}
+
+func TestEvaluate_ApprovalQueue(t *testing.T) {
+	r := newRegistry(t, config.EnrollmentConfig{Enabled: true})
+
+	if state := r.Evaluate(Candidate{AgentID: "a"}); state != StatePending {
+		t.Fatalf("expected unknown agent to be %s, got %s", StatePending, state)
+	}
+	if pending := r.List(StatePending); len(pending) != 1 || pending[0].AgentID != "a" {
+		t.Fatalf("expected agent a in the pending queue, got %+v", pending)
+	}
+	if data, _ := json.Marshal(r.List(StatePending)[0]); strings.Contains(string(data), "decided_at") {
+		t.Errorf("expected a pending record without decided_at, got %s", data)
+	}
+
+	record, err := r.Approve("a")
+	if err != nil {
+		t.Fatalf("Approve error: %v", err)
+	}
+	if record.State != StateApproved || record.DecidedBy != DecidedByAPI || record.DecidedAt == nil {
+		t.Errorf("unexpected record after approval: %+v", record)
+	}
+	if state := r.Evaluate(Candidate{AgentID: "a"}); state != StateApproved {
+		t.Errorf("expected approved agent to stay %s, got %s", StateApproved, state)
+	}
+
+	r.Evaluate(Candidate{AgentID: "b"})
+	if _, err := r.Reject("b"); err != nil {
+		t.Fatalf("Reject error: %v", err)
+	}
+	if state := r.Evaluate(Candidate{AgentID: "b"}); state != StateRejected {
+		t.Errorf("expected %s, got %s", StateRejected, state)
+	}
+
+	if _, err := r.Approve("unknown"); err == nil {
+		t.Error("expected error approving an agent that never connected")
+	}
+}
+
+func TestEvaluate_AutoApprove(t *testing.T) {
+	r := newRegistry(t, config.EnrollmentConfig{
+		Enabled: true,
+		AutoApprove: []config.AutoApproveRule{
+			{Name: "prod", Attributes: map[string]string{"deployment.environment": "prod", "host.name": "edge-*"}},
+			{Name: "certs", ClientCert: "*.agents.example.com"},
+		},
+	})
+
+	tests := []struct {
+		candidate Candidate
+		state     string
+		decidedBy string
+	}{
+		{Candidate{AgentID: "1", Labels: map[string]string{"deployment.environment": "prod", "host.name": "edge-1"}}, StateApproved, "prod"},
+		{Candidate{AgentID: "2", Labels: map[string]string{"deployment.environment": "prod", "host.name": "core-1"}}, StatePending, ""},
+		{Candidate{AgentID: "3", ClientCertNames: []string{"c1.agents.example.com"}}, StateApproved, "certs"},
+		{Candidate{AgentID: "4", ClientCertNames: []string{"c1.other.example.com"}}, StatePending, ""},
+	}
+
+	for _, tt := range tests {
+		if state := r.Evaluate(tt.candidate); state != tt.state {
+			t.Errorf("agent %s: expected %s, got %s", tt.candidate.AgentID, tt.state, state)
+		}
+	}
+	for _, record := range r.List(StateApproved) {
+		for _, tt := range tests {
+			if tt.candidate.AgentID == record.AgentID && record.DecidedBy != tt.decidedBy {
+				t.Errorf("agent %s: expected decided_by %q, got %q", record.AgentID, tt.decidedBy, record.DecidedBy)
+			}
+		}
+	}
+
+	// A pending agent is approved once it reports matching attributes.
+	late := Candidate{AgentID: "2", Labels: map[string]string{"deployment.environment": "prod", "host.name": "edge-2"}}
+	if state := r.Evaluate(late); state != StateApproved {
+		t.Errorf("expected pending agent to be auto-approved after reporting attributes, got %s", state)
+	}
+}
+
+func TestStore_SurvivesRestart(t *testing.T) {
+	cfg := config.EnrollmentConfig{Enabled: true, Store: filepath.Join(t.TempDir(), "enrollments.json")}
+	r := newRegistry(t, cfg)
+	r.Evaluate(Candidate{AgentID: "a"})
+	r.Evaluate(Candidate{AgentID: "b"})
+	r.Evaluate(Candidate{AgentID: "c", Labels: map[string]string{"host.name": "edge-1"}})
+	if _, err := r.Approve("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reject("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := newRegistry(t, cfg)
+	for agentID, want := range map[string]string{"a": StateApproved, "b": StateRejected, "c": StatePending} {
+		if state := reopened.Evaluate(Candidate{AgentID: agentID}); state != want {
+			t.Errorf("agent %s after restart: expected %s, got %s", agentID, want, state)
+		}
+	}
+	if pending := reopened.List(StatePending); len(pending) != 1 || pending[0].AgentID != "c" {
+		t.Errorf("expected agent c to stay pending, got %+v", pending)
+	}
+}
+
+func newRegistry(t *testing.T, cfg config.EnrollmentConfig) *Registry {
+	t.Helper()
+	r, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	return r
+}
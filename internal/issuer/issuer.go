@@ -0,0 +1,403 @@
+// Package issuer signs the client certificates agents request with a CSR in
+// ConnectionSettingsRequest, using a local CA, and renews them before they
+// expire. A certificate's common name is the agent ID, whatever the CSR asks
+// for, so it can be verified with opamp.tls.identity match instance_uid.
+package issuer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"opamp-backend/internal/agents"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+// Request states.
+const (
+	StatePending  = "pending"
+	StateIssued   = "issued"
+	StateRejected = "rejected"
+)
+
+// Deciders recorded on issued and rejected requests.
+const (
+	DecidedByAPI    = "api"
+	DecidedByPolicy = "auto_approve"
+)
+
+// Errors returned by the issuer.
+var (
+	ErrDisabled = errors.New("certificate issuing is not configured")
+	ErrNotFound = errors.New("agent has not requested a certificate")
+	ErrInvalid  = errors.New("invalid certificate signing request")
+)
+
+// Record is the latest certificate request of an agent and the certificate
+// issued for it.
+type Record struct {
+	AgentID      string     `json:"agent_id"`
+	State        string     `json:"state"`
+	Subject      string     `json:"subject"` // as requested in the CSR
+	RequestedAt  time.Time  `json:"requested_at"`
+	DecidedAt    *time.Time `json:"decided_at,omitempty"`
+	DecidedBy    string     `json:"decided_by,omitempty"`
+	SerialNumber string     `json:"serial_number,omitempty"`
+	IssuedAt     *time.Time `json:"issued_at,omitempty"`
+	NotAfter     *time.Time `json:"not_after,omitempty"`
+	Renewals     int        `json:"renewals,omitempty"`
+	CSR          []byte     `json:"csr,omitempty"`         // DER
+	Certificate  []byte     `json:"certificate,omitempty"` // PEM
+	// SHA-256 of the CA certificate that signed Certificate
+	CAFingerprint string `json:"ca_fingerprint,omitempty"`
+	// Replacement is a newer request of an agent whose certificate was
+	// issued. The issued certificate stays in use until it is approved.
+	Replacement *Record `json:"replacement,omitempty"`
+}
+
+// public returns a copy without the CSR or the replacement, which List
+// returns separately.
+func (r Record) public() Record {
+	r.CSR = nil
+	r.Replacement = nil
+	return r
+}
+
+// Policy controls how requests are approved and certificates issued.
+type Policy struct {
+	Validity    time.Duration
+	RenewBefore time.Duration
+	AutoApprove bool
+	Agents      agents.Selector // agents approved automatically, empty for every agent
+}
+
+// Issuer holds the CA and the certificate requests of every agent.
+type Issuer struct {
+	mu            sync.Mutex
+	path          string // empty keeps records in memory only
+	ca            *x509.Certificate
+	caKey         crypto.Signer
+	caPEM         []byte
+	caFingerprint string
+	policy        Policy
+	records       map[string]*Record
+	now           func() time.Time
+}
+
+// Open loads the records stored in path, if any. The issuer is disabled
+// until Configure is called with a CA.
+func Open(path string) (*Issuer, error) {
+	i := &Issuer{path: path, records: make(map[string]*Record), now: time.Now}
+	if path == "" {
+		return i, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return i, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []*Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	for _, record := range records {
+		i.records[record.AgentID] = record
+	}
+	return i, nil
+}
+
+// Configure loads the CA key pair and replaces the policy, for example after
+// a reload. Empty file names disable the issuer. Nothing is changed if the
+// CA cannot be loaded.
+func (i *Issuer) Configure(caCertFile, caKeyFile string, policy Policy) error {
+	ca, err := LoadCA(caCertFile, caKeyFile)
+	if err != nil {
+		return err
+	}
+	i.Use(ca, policy)
+	return nil
+}
+
+// CA is a CA key pair loaded by LoadCA.
+type CA struct {
+	cert        *x509.Certificate
+	key         crypto.Signer
+	pem         []byte
+	fingerprint string
+}
+
+// LoadCA loads the CA key pair without changing any Issuer. It returns nil
+// for an empty caCertFile.
+func LoadCA(caCertFile, caKeyFile string) (*CA, error) {
+	if caCertFile == "" {
+		return nil, nil
+	}
+	pair, err := tls.LoadX509KeyPair(caCertFile, caKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificates CA: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificates CA: %v", err)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("certificates CA key cannot sign")
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	return &CA{
+		cert:        cert,
+		key:         key,
+		pem:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		fingerprint: hex.EncodeToString(fingerprint[:]),
+	}, nil
+}
+
+// Use replaces the CA with one returned by LoadCA, nil disabling certificate
+// requests, and replaces the policy.
+func (i *Issuer) Use(ca *CA, policy Policy) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if ca == nil {
+		i.ca, i.caKey, i.caPEM, i.caFingerprint = nil, nil, nil, ""
+	} else {
+		i.ca, i.caKey, i.caPEM, i.caFingerprint = ca.cert, ca.key, ca.pem, ca.fingerprint
+	}
+	i.policy = policy
+}
+
+// Enabled reports whether a CA is configured.
+func (i *Issuer) Enabled() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.ca != nil
+}
+
+// Request records a CSR sent by agent, in PEM or DER form, and signs it
+// right away if the policy approves it. The policy only approves agents whose
+// ID is bound, that is tied to the credential or client certificate they
+// authenticated with, or approved through enrollment. Sending the same CSR
+// again returns the existing record; a new CSR goes through approval again,
+// and replaces an issued certificate only once it is approved.
+func (i *Issuer) Request(agent *agents.Agent, csrData []byte, bound bool) (Record, error) {
+	if block, _ := pem.Decode(csrData); block != nil {
+		csrData = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(csrData)
+	if err != nil {
+		return Record{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return Record{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.ca == nil {
+		return Record{}, ErrDisabled
+	}
+	current, exists := i.records[agent.ID]
+	if exists && bytes.Equal(current.CSR, csr.Raw) {
+		return current.public(), nil
+	}
+	if exists && current.Replacement != nil && bytes.Equal(current.Replacement.CSR, csr.Raw) {
+		return current.Replacement.public(), nil
+	}
+
+	request := &Record{
+		AgentID:     agent.ID,
+		State:       StatePending,
+		Subject:     csr.Subject.String(),
+		RequestedAt: i.now(),
+		CSR:         csr.Raw,
+	}
+	if exists && current.State == StateIssued {
+		current.Replacement = request
+	} else {
+		i.records[agent.ID] = request
+	}
+	if i.policy.AutoApprove && bound && !agent.Pending && i.policy.Agents.Matches(agent) {
+		if err := i.decide(request, StateIssued, DecidedByPolicy); err != nil {
+			return Record{}, err
+		}
+	}
+	return request.public(), i.save()
+}
+
+// Approve signs the pending request of an agent.
+func (i *Issuer) Approve(agentID string) (Record, error) {
+	return i.decideAPI(agentID, StateIssued)
+}
+
+// Reject rejects the pending request of an agent.
+func (i *Issuer) Reject(agentID string) (Record, error) {
+	return i.decideAPI(agentID, StateRejected)
+}
+
+func (i *Issuer) decideAPI(agentID, state string) (Record, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.ca == nil {
+		return Record{}, ErrDisabled
+	}
+	record, exists := i.records[agentID]
+	if !exists {
+		return Record{}, fmt.Errorf("%w: %s", ErrNotFound, agentID)
+	}
+	if record.Replacement != nil {
+		record = record.Replacement
+	}
+	if record.State != StatePending {
+		return Record{}, fmt.Errorf("certificate request of agent %s is already %s", agentID, record.State)
+	}
+	if err := i.decide(record, state, DecidedByAPI); err != nil {
+		return Record{}, err
+	}
+	return record.public(), i.save()
+}
+
+// decide records a decision, signing the request if it is approved. An
+// approved replacement becomes the agent's record. The caller must hold i.mu.
+func (i *Issuer) decide(record *Record, state, decidedBy string) error {
+	if state == StateIssued {
+		if err := i.sign(record); err != nil {
+			return err
+		}
+		i.records[record.AgentID] = record
+	}
+	now := i.now()
+	record.State = state
+	record.DecidedAt = &now
+	record.DecidedBy = decidedBy
+	return nil
+}
+
+// Certificate returns the certificate issued to an agent and the CA that
+// signed it, or nil if there is none. Certificates are renewed with the key
+// of the original request once they are within the renewal period of their
+// expiry, or when the CA changed.
+func (i *Issuer) Certificate(agentID string) (*protobufs.TLSCertificate, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	record, exists := i.records[agentID]
+	if i.ca == nil || !exists || record.State != StateIssued {
+		return nil, nil
+	}
+	if record.CAFingerprint != i.caFingerprint || !i.now().Before(record.NotAfter.Add(-i.policy.RenewBefore)) {
+		if err := i.sign(record); err != nil {
+			return nil, err
+		}
+		record.Renewals++
+		if err := i.save(); err != nil {
+			return nil, err
+		}
+	}
+	return &protobufs.TLSCertificate{Cert: record.Certificate, CaCert: i.caPEM}, nil
+}
+
+// List returns the records in the given state, or all records if state is
+// empty, ordered by request time.
+func (i *Issuer) List(state string) []Record {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	records := make([]Record, 0, len(i.records))
+	for _, record := range i.records {
+		for _, r := range []*Record{record, record.Replacement} {
+			if r != nil && (state == "" || r.State == state) {
+				records = append(records, r.public())
+			}
+		}
+	}
+	sort.Slice(records, func(a, b int) bool {
+		return records[a].RequestedAt.Before(records[b].RequestedAt)
+	})
+	return records
+}
+
+// sign issues a certificate for the public key of record's CSR. The caller
+// must hold i.mu.
+func (i *Issuer) sign(record *Record) error {
+	csr, err := x509.ParseCertificateRequest(record.CSR)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := i.now()
+	notAfter := now.Add(i.policy.Validity)
+	if notAfter.After(i.ca.NotAfter) {
+		notAfter = i.ca.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: record.AgentID},
+		NotBefore:    now.Add(-time.Minute), // tolerate clock skew
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, i.ca, csr.PublicKey, i.caKey)
+	if err != nil {
+		return fmt.Errorf("failed to sign certificate for agent %s: %v", record.AgentID, err)
+	}
+	record.Certificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	record.SerialNumber = serial.Text(16)
+	record.IssuedAt = &now
+	record.NotAfter = &notAfter
+	record.CAFingerprint = i.caFingerprint
+	return nil
+}
+
+// save writes the records through a temporary file so readers never see a
+// partial file. The caller must hold i.mu.
+func (i *Issuer) save() error {
+	if i.path == "" {
+		return nil
+	}
+	records := make([]*Record, 0, len(i.records))
+	for _, record := range i.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(a, b int) bool {
+		return records[a].AgentID < records[b].AgentID
+	})
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(i.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(i.path), ".certificates-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), i.path)
+}
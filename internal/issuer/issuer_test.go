@@ -0,0 +1,273 @@
+package issuer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"opamp-backend/internal/agents"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCA writes a self-signed CA certificate and key and returns their
+// paths.
+func writeCA(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// newCSR returns a PEM encoded CSR for a fresh key.
+func newCSR(t *testing.T, commonName string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: commonName}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func parseCertificate(t *testing.T, data []byte) *x509.Certificate {
+	t.Helper()
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("no PEM certificate in %q", data)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestIssuerPolicy(t *testing.T) {
+	dir := t.TempDir()
+	caCert, caKey := writeCA(t, dir, "ca")
+	issuer, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issuer.Request(&agents.Agent{ID: "a"}, newCSR(t, "a"), true); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Request() without a CA error = %v, want ErrDisabled", err)
+	}
+
+	policy := Policy{Validity: time.Hour, RenewBefore: 10 * time.Minute, AutoApprove: true, Agents: agents.Selector{Labels: map[string]string{"env": "prod"}}}
+	if err := issuer.Configure(caCert, caKey, policy); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := issuer.Request(&agents.Agent{ID: "a"}, []byte("not a csr"), true); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Request() with garbage error = %v, want ErrInvalid", err)
+	}
+
+	prod := &agents.Agent{ID: "0a0b", Labels: map[string]string{"env": "prod"}}
+	record, err := issuer.Request(prod, newCSR(t, "whatever the agent asks for"), true)
+	if err != nil || record.State != StateIssued || record.DecidedBy != DecidedByPolicy {
+		t.Fatalf("Request() for a matched agent = %+v, %v", record, err)
+	}
+	offered, err := issuer.Certificate(prod.ID)
+	if err != nil || offered == nil {
+		t.Fatalf("Certificate() = %v, %v", offered, err)
+	}
+	cert := parseCertificate(t, offered.Cert)
+	if cert.Subject.CommonName != "0a0b" || cert.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Errorf("issued certificate has subject %q and usage %v", cert.Subject, cert.ExtKeyUsage)
+	}
+	if err := cert.CheckSignatureFrom(parseCertificate(t, offered.CaCert)); err != nil {
+		t.Errorf("issued certificate is not signed by the CA: %v", err)
+	}
+
+	dev := &agents.Agent{ID: "0c0d", Labels: map[string]string{"env": "dev"}}
+	csr := newCSR(t, "dev")
+	if record, err := issuer.Request(dev, csr, true); err != nil || record.State != StatePending {
+		t.Fatalf("Request() for an unmatched agent = %+v, %v", record, err)
+	}
+	if offered, _ := issuer.Certificate(dev.ID); offered != nil {
+		t.Error("Expected no certificate for a pending request")
+	}
+	if _, err := issuer.Approve("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Approve() of an unknown agent error = %v, want ErrNotFound", err)
+	}
+	if record, err := issuer.Approve(dev.ID); err != nil || record.State != StateIssued || record.DecidedBy != DecidedByAPI {
+		t.Errorf("Approve() = %+v, %v", record, err)
+	}
+	if _, err := issuer.Reject(dev.ID); err == nil {
+		t.Error("Expected an error rejecting a request that was already approved")
+	}
+	if record, _ := issuer.Request(dev, csr, true); record.State != StateIssued {
+		t.Errorf("Expected the same CSR to keep its record, got %+v", record)
+	}
+
+	if record, _ := issuer.Request(&agents.Agent{ID: "0a0c", Labels: map[string]string{"env": "prod"}}, newCSR(t, "unbound"), false); record.State != StatePending {
+		t.Errorf("Expected agents whose ID is not bound not to be approved automatically, got %+v", record)
+	}
+
+	pending := &agents.Agent{ID: "0e0f", Labels: map[string]string{"env": "prod"}, Pending: true}
+	if record, _ := issuer.Request(pending, newCSR(t, "pending"), true); record.State != StatePending {
+		t.Errorf("Expected agents pending enrollment not to be approved automatically, got %+v", record)
+	}
+	if list := issuer.List(StatePending); len(list) != 2 || list[0].AgentID != "0a0c" || list[1].AgentID != "0e0f" || list[1].CSR != nil {
+		t.Errorf("List(pending) = %+v", list)
+	}
+}
+
+func TestIssuerReplacement(t *testing.T) {
+	dir := t.TempDir()
+	caCert, caKey := writeCA(t, dir, "ca")
+	store := filepath.Join(dir, "certificates.json")
+	issuer, err := Open(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := issuer.Configure(caCert, caKey, Policy{Validity: time.Hour, RenewBefore: 10 * time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	agent := &agents.Agent{ID: "a"}
+	if _, err := issuer.Request(agent, newCSR(t, "a"), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issuer.Approve("a"); err != nil {
+		t.Fatal(err)
+	}
+	issued, _ := issuer.Certificate("a")
+
+	// A new CSR awaits approval while the issued certificate stays in use,
+	// also after a restart.
+	csr := newCSR(t, "a")
+	if record, err := issuer.Request(agent, csr, true); err != nil || record.State != StatePending {
+		t.Fatalf("Request() with a new CSR = %+v, %v", record, err)
+	}
+	reopened, err := Open(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Configure(caCert, caKey, Policy{Validity: time.Hour, RenewBefore: 10 * time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := reopened.Certificate("a"); current == nil || string(current.Cert) != string(issued.Cert) {
+		t.Fatalf("Expected the issued certificate to stay in use, got %v", current)
+	}
+	if record, _ := reopened.Request(agent, csr, true); record.State != StatePending {
+		t.Errorf("Expected the same CSR to keep its pending record, got %+v", record)
+	}
+	if list := reopened.List(""); len(list) != 2 || list[0].State != StateIssued || list[1].State != StatePending {
+		t.Errorf("List() = %+v", list)
+	}
+
+	// Rejecting the new request keeps the issued certificate.
+	if _, err := reopened.Reject("a"); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := reopened.Certificate("a"); current == nil || string(current.Cert) != string(issued.Cert) {
+		t.Errorf("Expected the issued certificate to stay in use after rejecting its replacement, got %v", current)
+	}
+
+	// Approving another one replaces it.
+	if _, err := reopened.Request(agent, newCSR(t, "a"), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Approve("a"); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := reopened.Certificate("a"); current == nil || string(current.Cert) == string(issued.Cert) {
+		t.Errorf("Expected the approved replacement to be issued, got %v", current)
+	}
+	if list := reopened.List(""); len(list) != 1 || list[0].State != StateIssued {
+		t.Errorf("List() after approving the replacement = %+v", list)
+	}
+}
+
+func TestIssuerRenewal(t *testing.T) {
+	dir := t.TempDir()
+	caCert, caKey := writeCA(t, dir, "ca")
+	store := filepath.Join(dir, "certificates.json")
+	issuer, err := Open(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := issuer.Configure(caCert, caKey, Policy{Validity: time.Hour, RenewBefore: 10 * time.Minute, AutoApprove: true}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	issuer.now = func() time.Time { return now }
+
+	if _, err := issuer.Request(&agents.Agent{ID: "a"}, newCSR(t, "a"), true); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := issuer.Certificate("a")
+
+	// Still outside the renewal period
+	now = now.Add(45 * time.Minute)
+	if again, _ := issuer.Certificate("a"); string(again.Cert) != string(first.Cert) {
+		t.Error("Expected the certificate not to be renewed yet")
+	}
+
+	now = now.Add(10 * time.Minute)
+	renewed, err := issuer.Certificate("a")
+	if err != nil || string(renewed.Cert) == string(first.Cert) {
+		t.Fatalf("Expected the certificate to be renewed, got %v", err)
+	}
+	oldCert, newCert := parseCertificate(t, first.Cert), parseCertificate(t, renewed.Cert)
+	if !newCert.NotAfter.After(oldCert.NotAfter) || !oldCert.PublicKey.(*ecdsa.PublicKey).Equal(newCert.PublicKey) {
+		t.Error("Expected the renewed certificate to extend the validity of the same key")
+	}
+
+	// Records survive a restart, and a new CA renews the certificate.
+	reopened, err := Open(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCert, otherKey := writeCA(t, dir, "other")
+	if err := reopened.Configure(otherCert, otherKey, Policy{Validity: time.Hour, RenewBefore: 10 * time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := reopened.Certificate("a")
+	if err != nil || rotated == nil {
+		t.Fatalf("Certificate() after reopening = %v, %v", rotated, err)
+	}
+	if err := parseCertificate(t, rotated.Cert).CheckSignatureFrom(parseCertificate(t, rotated.CaCert)); err != nil {
+		t.Errorf("Expected the certificate to be signed by the new CA: %v", err)
+	}
+	if list := reopened.List(""); len(list) != 1 || list[0].Renewals != 2 {
+		t.Errorf("List() = %+v", list)
+	}
+}
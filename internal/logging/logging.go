@@ -0,0 +1,233 @@
+// Package logging provides the structured logger shared by the backend
+// subsystems. Loggers returned by For stay valid across calls to Configure
+// and SetLevel, so packages can create them once at init time.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Subsystem names used as the "subsystem" attribute on every record.
+const (
+	SubsystemOpAMP  = "opamp"
+	SubsystemAPI    = "api"
+	SubsystemConfig = "config"
+	SubsystemAgents = "agents"
+)
+
+var (
+	// level applies to loggers outside the known subsystems.
+	level  = new(slog.LevelVar)
+	output atomic.Pointer[slog.Handler]
+
+	// levels holds the independently adjustable level of each subsystem.
+	levels = map[string]*slog.LevelVar{
+		SubsystemOpAMP:  new(slog.LevelVar),
+		SubsystemAPI:    new(slog.LevelVar),
+		SubsystemConfig: new(slog.LevelVar),
+		SubsystemAgents: new(slog.LevelVar),
+	}
+)
+
+func init() {
+	setOutput(os.Stderr, "text")
+}
+
+// Settings are a parsed level, output format and per-subsystem levels,
+// applied with Apply.
+type Settings struct {
+	level      slog.Level
+	format     string
+	subsystems map[string]slog.Level
+}
+
+// Configure sets the minimum level and output format ("text" or "json") for
+// all loggers, then applies any per-subsystem level overrides. Empty values
+// keep the defaults of "info" and "text".
+func Configure(levelName, format string, overrides map[string]string) error {
+	settings, err := ParseSettings(levelName, format, overrides)
+	if err != nil {
+		return err
+	}
+	Apply(settings)
+	return nil
+}
+
+// ParseSettings checks the arguments of Configure without applying them.
+func ParseSettings(levelName, format string, overrides map[string]string) (Settings, error) {
+	lvl, err := ParseLevel(levelName)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	switch strings.ToLower(format) {
+	case "", "text", "json":
+	default:
+		return Settings{}, fmt.Errorf("invalid log format %q (expected text or json)", format)
+	}
+
+	subsystemLevels := make(map[string]slog.Level, len(overrides))
+	for subsystem, name := range overrides {
+		if _, ok := levels[subsystem]; !ok {
+			return Settings{}, fmt.Errorf("unknown log subsystem %q", subsystem)
+		}
+		if subsystemLevels[subsystem], err = ParseLevel(name); err != nil {
+			return Settings{}, err
+		}
+	}
+	return Settings{level: lvl, format: format, subsystems: subsystemLevels}, nil
+}
+
+// Apply makes settings the current logging configuration.
+func Apply(settings Settings) {
+	setOutput(os.Stderr, settings.format)
+	level.Set(settings.level)
+	for subsystem, v := range levels {
+		if l, ok := settings.subsystems[subsystem]; ok {
+			v.Set(l)
+		} else {
+			v.Set(settings.level)
+		}
+	}
+}
+
+// SetLevel changes the level of a single subsystem at runtime.
+func SetLevel(subsystem, levelName string) error {
+	v, ok := levels[subsystem]
+	if !ok {
+		return fmt.Errorf("unknown log subsystem %q", subsystem)
+	}
+	lvl, err := ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+	v.Set(lvl)
+	return nil
+}
+
+// Levels returns the current level name of every subsystem.
+func Levels() map[string]string {
+	result := make(map[string]string, len(levels))
+	for subsystem, v := range levels {
+		result[subsystem] = LevelName(v.Level())
+	}
+	return result
+}
+
+// Subsystems returns the names of all subsystems with adjustable levels.
+func Subsystems() []string {
+	names := make([]string, 0, len(levels))
+	for subsystem := range levels {
+		names = append(names, subsystem)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LevelName returns the lower-case name accepted by ParseLevel.
+func LevelName(l slog.Level) string {
+	switch {
+	case l <= slog.LevelDebug:
+		return "debug"
+	case l <= slog.LevelInfo:
+		return "info"
+	case l <= slog.LevelWarn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+// ParseLevel converts a level name (debug, info, warn, error) to a slog.Level.
+// An empty name is treated as info.
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("invalid log level %q", name)
+	}
+}
+
+// For returns the logger for a subsystem. Subsystems without their own level
+// follow the level passed to Configure.
+func For(subsystem string) *slog.Logger {
+	lvl, ok := levels[subsystem]
+	if !ok {
+		lvl = level
+	}
+	return slog.New(&handler{level: lvl}).With("subsystem", subsystem)
+}
+
+func setOutput(w io.Writer, format string) {
+	// Filtering happens in handler.Enabled, so the output handler accepts everything.
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	if strings.ToLower(format) == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	output.Store(&h)
+}
+
+type contextKey struct{}
+
+// WithAttrs returns a context carrying attributes (such as request_id or
+// agent_id) that are added to every record logged with that context.
+func WithAttrs(ctx context.Context, args ...any) context.Context {
+	existing, _ := ctx.Value(contextKey{}).([]any)
+	merged := make([]any, 0, len(existing)+len(args))
+	merged = append(merged, existing...)
+	merged = append(merged, args...)
+	return context.WithValue(ctx, contextKey{}, merged)
+}
+
+// handler applies its subsystem's level and forwards records to the current
+// output handler, replaying any attributes and groups added via With.
+type handler struct {
+	level *slog.LevelVar
+	ops   []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if args, ok := ctx.Value(contextKey{}).([]any); ok {
+		r.Add(args...)
+	}
+	out := *output.Load()
+	for _, op := range h.ops {
+		out = op(out)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithGroup(name) })
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) *handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &handler{level: h.level, ops: append(ops, op)}
+}
@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestConfigure_InvalidValues(t *testing.T) {
+	if err := Configure("verbose", "text", nil); err == nil {
+		t.Error("expected error for invalid log level")
+	}
+	if err := Configure("info", "xml", nil); err == nil {
+		t.Error("expected error for invalid log format")
+	}
+}
+
+func TestFor_JSONOutputWithContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	setOutput(&buf, "json")
+	defer setOutput(os.Stderr, "text")
+
+	ctx := WithAttrs(context.Background(), "request_id", "req-1")
+	For(SubsystemAPI).InfoContext(ctx, "hello", "agent_id", "agent-1")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected JSON log record, got %q: %v", buf.String(), err)
+	}
+	for key, want := range map[string]string{
+		"msg":        "hello",
+		"subsystem":  SubsystemAPI,
+		"request_id": "req-1",
+		"agent_id":   "agent-1",
+	} {
+		if record[key] != want {
+			t.Errorf("expected %s=%q, got %v", key, want, record[key])
+		}
+	}
+}
+
+func TestFor_LevelFiltering(t *testing.T) {
+	if err := Configure("warn", "text", nil); err != nil {
+		t.Fatal(err)
+	}
+	defer Configure("info", "text", nil)
+
+	var buf bytes.Buffer
+	setOutput(&buf, "text")
+
+	l := For(SubsystemOpAMP)
+	l.Info("dropped")
+	l.Warn("kept")
+
+	if bytes.Contains(buf.Bytes(), []byte("dropped")) {
+		t.Errorf("expected info record to be filtered, got %q", buf.String())
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("kept")) {
+		t.Errorf("expected warn record to be written, got %q", buf.String())
+	}
+}
+
+func TestSetLevel_PerSubsystem(t *testing.T) {
+	if err := Configure("info", "text", map[string]string{SubsystemAgents: "error"}); err != nil {
+		t.Fatal(err)
+	}
+	defer Configure("info", "text", nil)
+
+	var buf bytes.Buffer
+	setOutput(&buf, "text")
+
+	if err := SetLevel(SubsystemOpAMP, "debug"); err != nil {
+		t.Fatal(err)
+	}
+	For(SubsystemOpAMP).Debug("opamp debug")
+	For(SubsystemAPI).Debug("api debug")
+	For(SubsystemAgents).Warn("agents warn")
+
+	if !bytes.Contains(buf.Bytes(), []byte("opamp debug")) {
+		t.Errorf("expected opamp debug record, got %q", buf.String())
+	}
+	if bytes.Contains(buf.Bytes(), []byte("api debug")) {
+		t.Errorf("expected api debug record to be filtered, got %q", buf.String())
+	}
+	if bytes.Contains(buf.Bytes(), []byte("agents warn")) {
+		t.Errorf("expected agents warn record to be filtered, got %q", buf.String())
+	}
+
+	levels := Levels()
+	if levels[SubsystemOpAMP] != "debug" || levels[SubsystemAPI] != "info" || levels[SubsystemAgents] != "error" {
+		t.Errorf("unexpected levels: %v", levels)
+	}
+
+	if err := SetLevel("unknown", "debug"); err == nil {
+		t.Error("expected error for unknown subsystem")
+	}
+}
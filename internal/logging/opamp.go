@@ -0,0 +1,25 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// OpampLogger adapts a slog.Logger to the Logger interface required by opamp-go.
+type OpampLogger struct {
+	Logger *slog.Logger
+}
+
+// NewOpampLogger returns an opamp-go logger that writes through l.
+func NewOpampLogger(l *slog.Logger) *OpampLogger {
+	return &OpampLogger{Logger: l}
+}
+
+func (l *OpampLogger) Debugf(ctx context.Context, format string, args ...interface{}) {
+	l.Logger.DebugContext(ctx, fmt.Sprintf(format, args...))
+}
+
+func (l *OpampLogger) Errorf(ctx context.Context, format string, args ...interface{}) {
+	l.Logger.ErrorContext(ctx, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,103 @@
+// Package metrics provides the counters exported by the backend in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	registryMu sync.Mutex
+	registry   []*Counter
+)
+
+// Counter is a monotonically increasing value partitioned by label values.
+type Counter struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+// NewCounter creates and registers a counter with the given label names.
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		values:     make(map[string]uint64),
+	}
+	registryMu.Lock()
+	registry = append(registry, c)
+	registryMu.Unlock()
+	return c
+}
+
+// Inc increments the counter for the given label values, which must match
+// the label names passed to NewCounter.
+func (c *Counter) Inc(labelValues ...string) {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+// Value returns the current count for the given label values.
+func (c *Counter) Value(labelValues ...string) uint64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) key(labelValues []string) string {
+	if len(labelValues) != len(c.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", c.name, len(c.labelNames), len(labelValues)))
+	}
+	pairs := make([]string, len(labelValues))
+	for i, value := range labelValues {
+		pairs[i] = fmt.Sprintf("%s=%q", c.labelNames[i], value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (c *Counter) write(b *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "" {
+			fmt.Fprintf(b, "%s %d\n", c.name, c.values[key])
+		} else {
+			fmt.Fprintf(b, "%s{%s} %d\n", c.name, key, c.values[key])
+		}
+	}
+}
+
+// Handler serves all registered counters.
+func Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		registryMu.Lock()
+		counters := append([]*Counter(nil), registry...)
+		registryMu.Unlock()
+
+		var b strings.Builder
+		for _, c := range counters {
+			c.write(&b)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(b.String()))
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"opamp-backend/internal/audit"
+	"time"
+)
+
+// Audit records every mutating request, and every request that changed an
+// agent, in log. Handlers add the agents they affect with audit.AddTarget and
+// the auth middleware adds the caller. A nil log disables auditing.
+func Audit(log *audit.Log, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if log == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		entry := &audit.Entry{
+			Time:      time.Now().UTC(),
+			RequestID: w.Header().Get(RequestIDHeader),
+			Method:    r.Method,
+			Endpoint:  r.URL.Path,
+		}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(audit.NewContext(r.Context(), entry)))
+
+		if !isMutating(r.Method) && !entry.HasTargets() {
+			return
+		}
+		entry.Finish(recorder.status)
+		if err := log.Record(entry); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write audit log entry", "error", err)
+		}
+	})
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
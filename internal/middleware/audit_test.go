@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"opamp-backend/internal/audit"
+	"opamp-backend/internal/config"
+	"path/filepath"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	authToken = ""
+	SetTokens([]config.APIToken{{Name: "reader", Token: "read-token", Scopes: []string{config.ScopeRead}}})
+	defer SetTokens(nil)
+
+	log, err := audit.Open(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	push := RequireScope(config.ScopeRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		audit.AddTarget(r.Context(), "agent-1", "old", "new", nil)
+	}))
+	admin := RequireScope(config.ScopeAdmin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	read := RequireScope(config.ScopeRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, call := range []struct {
+		handler http.Handler
+		method  string
+	}{
+		{read, "GET"},  // read-only: not recorded
+		{push, "GET"},  // pushed a config: recorded
+		{admin, "PUT"}, // denied: recorded
+	} {
+		req := httptest.NewRequest(call.method, "/test", nil)
+		req.Header.Set("Authorization", "read-token")
+		RequestID(Audit(log, call.handler)).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	entries, err := log.Search(audit.Query{})
+	if err != nil {
+		t.Fatalf("Search error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 audit entries, got %d", len(entries))
+	}
+	if denied := entries[0]; denied.Outcome != audit.OutcomeDenied || denied.Status != http.StatusForbidden || denied.Caller != "reader" {
+		t.Errorf("expected denied entry, got %+v", denied)
+	}
+	pushed := entries[1]
+	if pushed.Caller != "reader" || pushed.RequestID == "" || len(pushed.Targets) != 1 || pushed.Outcome != audit.OutcomeSuccess {
+		t.Errorf("expected push entry with caller, request ID and target, got %+v", pushed)
+	}
+}
@@ -1,21 +1,231 @@
 package middleware
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
 	"net/http"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/apitokens"
+	"opamp-backend/internal/audit"
+	"opamp-backend/internal/config"
+	"opamp-backend/internal/logging"
+	"opamp-backend/internal/oidc"
 	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
 )
 
+var logger = logging.For(logging.SubsystemAPI)
+
 var authToken = os.Getenv("AUTH_TOKEN") // Retrieve the token from environment variables
 
+// authTokenHash is AUTH_TOKEN_HASH, a token_hash accepted like AUTH_TOKEN so
+// that the token itself need not be in the environment.
+var authTokenHash = parseAuthTokenHash(os.Getenv("AUTH_TOKEN_HASH"))
+
+func parseAuthTokenHash(value string) apitokens.Hash {
+	if value == "" {
+		return apitokens.Hash{}
+	}
+	hash, err := apitokens.ParseHash(value)
+	if err != nil {
+		logger.Error("Ignoring AUTH_TOKEN_HASH", "error", err)
+	}
+	return hash
+}
+
+// legacyTokenName identifies callers using AUTH_TOKEN, which grants every scope.
+const legacyTokenName = "AUTH_TOKEN"
+
+// namedToken is a configured API token with its secret held only as a hash.
+type namedToken struct {
+	config.APIToken
+	hash apitokens.Hash
+}
+
+// tokens holds the named API tokens accepted in addition to AUTH_TOKEN.
+var tokens atomic.Pointer[[]namedToken]
+
+// tokenStore holds the minted tokens when api.token_store is configured.
+var tokenStore atomic.Pointer[apitokens.Store]
+
+// jwtVerifier validates OIDC bearer tokens when configured.
+var jwtVerifier atomic.Pointer[oidc.Verifier]
+
+// SetJWTVerifier enables (or, with nil, disables) OIDC bearer tokens.
+func SetJWTVerifier(v *oidc.Verifier) {
+	jwtVerifier.Store(v)
+}
+
+// TokenSet is a list of named API tokens prepared by ParseTokens.
+type TokenSet []namedToken
+
+// SetTokens replaces the named API tokens, for example after a reload.
+// Tokens given in clear are hashed so that only hashes stay in memory.
+func SetTokens(t []config.APIToken) error {
+	set, err := ParseTokens(t)
+	if err != nil {
+		return err
+	}
+	UseTokens(set)
+	return nil
+}
+
+// ParseTokens hashes the tokens for SetTokens without installing them.
+func ParseTokens(t []config.APIToken) (TokenSet, error) {
+	named := make(TokenSet, 0, len(t))
+	for _, token := range t {
+		var hash apitokens.Hash
+		var err error
+		if token.TokenHash != "" {
+			hash, err = apitokens.ParseHash(token.TokenHash)
+		} else {
+			hash, err = apitokens.NewHash(token.Token)
+		}
+		if err != nil {
+			return nil, err
+		}
+		token.Token = ""
+		named = append(named, namedToken{APIToken: token, hash: hash})
+	}
+	return named, nil
+}
+
+// UseTokens installs tokens prepared by ParseTokens.
+func UseTokens(set TokenSet) {
+	named := []namedToken(set)
+	tokens.Store(&named)
+}
+
+// SetTokenStore enables (or, with nil, disables) minted tokens.
+func SetTokenStore(s *apitokens.Store) {
+	tokenStore.Store(s)
+}
+
+// Principal is the authenticated caller of an API request.
+type Principal struct {
+	Name   string
+	Scopes []string
+	Agents agents.Selector // agents the caller may read or change; empty for all
+}
+
+// HasScope reports whether the principal was granted scope, directly or
+// through a broader scope.
+func (p *Principal) HasScope(scope string) bool {
+	if slices.Contains(p.Scopes, config.ScopeAdmin) || slices.Contains(p.Scopes, scope) {
+		return true
+	}
+	return scope == config.ScopeLogLevel && slices.Contains(p.Scopes, config.ScopeConfigWrite)
+}
+
+// CanAccessAgent reports whether the principal's agent selector allows agent.
+func (p *Principal) CanAccessAgent(agent *agents.Agent) bool {
+	return p.Agents.IsEmpty() || p.Agents.Matches(agent)
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the caller stored by AuthMiddleware or
+// RequireScope.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// AgentAllowed reports whether the caller of the request may read or change
+// agent. Requests that did not pass through the auth middleware are allowed.
+func AgentAllowed(ctx context.Context, agent *agents.Agent) bool {
+	p, ok := PrincipalFromContext(ctx)
+	return !ok || p.CanAccessAgent(agent)
+}
+
+// authenticate matches the Authorization header against AUTH_TOKEN (or
+// AUTH_TOKEN_HASH), the
+// minted and the named tokens, then validates bearer tokens as OIDC JWTs.
+// Minted and named tokens may also be sent as "Bearer <token>".
+func authenticate(r *http.Request) (*Principal, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, false
+	}
+	if (authToken != "" && secureEqual(header, authToken)) || authTokenHash.Matches(header) {
+		return &Principal{Name: legacyTokenName, Scopes: []string{config.ScopeAdmin}}, true
+	}
+
+	presented := strings.TrimPrefix(header, "Bearer ")
+	if store := tokenStore.Load(); store != nil && strings.HasPrefix(presented, apitokens.Prefix) {
+		t, err := store.Authenticate(presented)
+		if err == nil {
+			return &Principal{Name: t.Name, Scopes: t.Scopes, Agents: t.Agents}, true
+		}
+		if errors.Is(err, apitokens.ErrExpired) || errors.Is(err, apitokens.ErrRevoked) {
+			logger.WarnContext(r.Context(), "Rejected api token", "token_id", t.ID, "error", err)
+		}
+	}
+	if configured := tokens.Load(); configured != nil {
+		for _, t := range *configured {
+			if t.hash.Matches(presented) {
+				return &Principal{Name: t.Name, Scopes: t.Scopes, Agents: t.Agents}, true
+			}
+		}
+	}
+
+	if v := jwtVerifier.Load(); v != nil && presented != header {
+		claims, err := v.Verify(presented)
+		if err != nil {
+			logger.WarnContext(r.Context(), "Rejected bearer token", "error", err)
+			return nil, false
+		}
+		return &Principal{Name: "oidc:" + claims.Username, Scopes: claims.Scopes}, true
+	}
+	return nil, false
+}
+
+// secureEqual compares digests so that neither the contents nor the length
+// of the expected value leak through timing.
+func secureEqual(a, b string) bool {
+	da, db := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(da[:], db[:]) == 1
+}
+
+// withPrincipal stores the caller on the request context, in its log
+// attributes and in the request's audit entry.
+func withPrincipal(r *http.Request, p *Principal) *http.Request {
+	ctx := context.WithValue(r.Context(), principalKey{}, p)
+	ctx = logging.WithAttrs(ctx, "caller", p.Name)
+	audit.SetCaller(ctx, p.Name)
+	return r.WithContext(ctx)
+}
+
 // AuthMiddleware checks for a valid Authorization header.
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		// If authToken is not set or does not match the header, reject the request.
-		if authToken == "" || token != authToken {
+		p, ok := authenticate(r)
+		if !ok {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, withPrincipal(r, p))
+	})
+}
+
+// RequireScope checks for a valid Authorization header whose token was
+// granted scope. Callers without that scope receive 403 Forbidden.
+func RequireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := authenticate(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !p.HasScope(scope) {
+			audit.SetCaller(r.Context(), p.Name)
+			http.Error(w, "Forbidden: requires scope "+scope, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, withPrincipal(r, p))
 	})
 }
@@ -3,7 +3,11 @@ package middleware
 import (
 	"net/http"
 	"net/http/httptest"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/apitokens"
+	"opamp-backend/internal/config"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -38,3 +42,138 @@ func TestAuthMiddleware_Authorized(t *testing.T) {
 		t.Errorf("Expected 200 OK, got %d", w.Code)
 	}
 }
+
+func TestAuthMiddleware_AuthTokenHash(t *testing.T) {
+	hash, err := apitokens.NewHash("hashed-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousToken, previousHash := authToken, authTokenHash
+	authToken, authTokenHash = "", parseAuthTokenHash(hash.String())
+	defer func() { authToken, authTokenHash = previousToken, previousHash }()
+
+	handler := RequireScope(config.ScopeAdmin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for header, want := range map[string]int{"hashed-secret": http.StatusOK, "wrong-secret": http.StatusUnauthorized} {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", header)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("Authorization %q: expected %d, got %d", header, want, w.Code)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	authToken = ""
+	SetTokens([]config.APIToken{
+		{Name: "reader", Token: "read-token", Scopes: []string{config.ScopeRead}},
+		{Name: "deployer", Token: "write-token", Scopes: []string{config.ScopeConfigWrite}},
+		{Name: "ops", Token: "admin-token", Scopes: []string{config.ScopeAdmin}},
+	})
+	defer SetTokens(nil)
+
+	tests := []struct {
+		header string
+		scope  string
+		status int
+	}{
+		{"read-token", config.ScopeRead, http.StatusOK},
+		{"Bearer read-token", config.ScopeRead, http.StatusOK},
+		{"read-token", config.ScopeLogLevel, http.StatusForbidden},
+		{"write-token", config.ScopeLogLevel, http.StatusOK},
+		{"write-token", config.ScopeDebug, http.StatusForbidden},
+		{"admin-token", config.ScopeDebug, http.StatusOK},
+		{"wrong-token", config.ScopeRead, http.StatusUnauthorized},
+		{"", config.ScopeRead, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		handler := RequireScope(tt.scope, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		req := httptest.NewRequest("GET", "/test", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%q with scope %s: expected %d, got %d", tt.header, tt.scope, tt.status, w.Code)
+		}
+	}
+}
+
+func TestAgentAllowed(t *testing.T) {
+	authToken = ""
+	SetTokens([]config.APIToken{{
+		Name:   "staging",
+		Token:  "staging-token",
+		Scopes: []string{config.ScopeLogLevel},
+		Agents: agents.Selector{Labels: map[string]string{"deployment.environment": "staging"}},
+	}})
+	defer SetTokens(nil)
+
+	staging := &agents.Agent{ID: "a", Labels: map[string]string{"deployment.environment": "staging"}}
+	prod := &agents.Agent{ID: "b", Labels: map[string]string{"deployment.environment": "prod"}}
+
+	var allowed []bool
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed = []bool{AgentAllowed(r.Context(), staging), AgentAllowed(r.Context(), prod)}
+	}))
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "staging-token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(allowed) != 2 || !allowed[0] || allowed[1] {
+		t.Errorf("expected only the staging agent to be allowed, got %v", allowed)
+	}
+}
+
+func TestHashedAndMintedTokens(t *testing.T) {
+	authToken = ""
+	hash, err := apitokens.NewHash("hashed-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetTokens([]config.APIToken{{Name: "hashed", TokenHash: hash.String(), Scopes: []string{config.ScopeRead}}}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetTokens(nil)
+
+	store, err := apitokens.Open(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	minted, token, err := store.Mint("minted", []string{config.ScopeRead}, agents.Selector{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTokenStore(store)
+	defer SetTokenStore(nil)
+
+	check := func(header string, want int) {
+		t.Helper()
+		handler := RequireScope(config.ScopeRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", header)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%q: expected %d, got %d", header, want, w.Code)
+		}
+	}
+
+	check("hashed-token", http.StatusOK)
+	check("Bearer "+minted, http.StatusOK)
+	check(minted+"x", http.StatusUnauthorized)
+
+	if _, err := store.Revoke(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	check(minted, http.StatusUnauthorized)
+}
@@ -0,0 +1,207 @@
+package middleware
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"opamp-backend/internal/config"
+	"opamp-backend/internal/metrics"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxIdleCallers bounds the per-caller buckets kept before full (idle)
+// buckets are dropped.
+const maxIdleCallers = 1024
+
+var throttledRequests = metrics.NewCounter(
+	"api_throttled_requests_total",
+	"API requests rejected by rate, concurrency or body size limits.",
+	"reason",
+)
+
+// bucket is a token bucket refilled continuously at a fixed rate.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take removes one token, or returns how long until one is available.
+func (b *bucket) take(rate float64, burst int, now time.Time) (time.Duration, bool) {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second)), false
+}
+
+// RateLimiter limits API requests per authenticated caller and across all
+// callers.
+type RateLimiter struct {
+	mu      sync.Mutex
+	limits  config.APILimits
+	global  bucket
+	callers map[string]*bucket
+	now     func() time.Time
+}
+
+// NewRateLimiter creates a limiter enforcing limits.
+func NewRateLimiter(limits config.APILimits) *RateLimiter {
+	return &RateLimiter{limits: limits, callers: make(map[string]*bucket), now: time.Now}
+}
+
+// Update replaces the limits, for example after a reload. Buckets start full.
+func (l *RateLimiter) Update(limits config.APILimits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+	l.global = bucket{}
+	l.callers = make(map[string]*bucket)
+}
+
+// Allow takes a token for caller from its own bucket and the global one. When
+// either is empty it returns how long the caller should wait and the limit
+// that was hit.
+func (l *RateLimiter) Allow(caller string) (time.Duration, string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+
+	if rate := l.limits.RequestsPerSecond; rate > 0 {
+		b, ok := l.callers[caller]
+		if !ok {
+			if len(l.callers) >= maxIdleCallers {
+				l.pruneIdle(now)
+			}
+			b = &bucket{}
+			l.callers[caller] = b
+		}
+		if wait, ok := b.take(rate, config.BurstFor(rate, l.limits.Burst), now); !ok {
+			return wait, "caller", false
+		}
+	}
+	if rate := l.limits.GlobalRequestsPerSecond; rate > 0 {
+		if wait, ok := l.global.take(rate, config.BurstFor(rate, l.limits.GlobalBurst), now); !ok {
+			return wait, "global", false
+		}
+	}
+	return 0, "", true
+}
+
+// pruneIdle drops buckets that have refilled completely, since a new bucket
+// behaves the same.
+func (l *RateLimiter) pruneIdle(now time.Time) {
+	rate := l.limits.RequestsPerSecond
+	burst := float64(config.BurstFor(rate, l.limits.Burst))
+	for caller, b := range l.callers {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(l.callers, caller)
+		}
+	}
+}
+
+// Limit rejects requests over the rate limits with 429 Too Many Requests. It
+// must run after RequireScope so that the caller is known.
+func (l *RateLimiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller := ""
+		if p, ok := PrincipalFromContext(r.Context()); ok {
+			caller = p.Name
+		}
+		if wait, reason, ok := l.Allow(caller); !ok {
+			throttledRequests.Inc(reason)
+			logger.WarnContext(r.Context(), "Rate limited API request", "limit", reason, "retry_after", wait)
+			tooManyRequests(w, wait, "Too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ConcurrencyLimit caps how many requests run a handler at once, for calls
+// that fan out to every agent.
+type ConcurrencyLimit struct {
+	mu     sync.Mutex
+	active int
+	max    int
+}
+
+// NewConcurrencyLimit allows max concurrent requests.
+func NewConcurrencyLimit(max int) *ConcurrencyLimit {
+	return &ConcurrencyLimit{max: max}
+}
+
+// SetMax changes the cap; requests already running are not affected.
+func (c *ConcurrencyLimit) SetMax(max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.max = max
+}
+
+func (c *ConcurrencyLimit) acquire() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active >= c.max {
+		return false
+	}
+	c.active++
+	return true
+}
+
+func (c *ConcurrencyLimit) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+}
+
+// Limit rejects requests with 429 Too Many Requests while the cap is reached.
+func (c *ConcurrencyLimit) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.acquire() {
+			throttledRequests.Inc("concurrency")
+			logger.WarnContext(r.Context(), "Rejected API request over the fan-out concurrency limit")
+			tooManyRequests(w, time.Second, "Too many concurrent fan-out requests")
+			return
+		}
+		defer c.release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MaxBytes limits request bodies to the size returned by limit, answering
+// 413 Request Entity Too Large when the declared length exceeds it. Handlers
+// reading past the limit get an *http.MaxBytesError.
+func MaxBytes(limit func() int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := limit()
+		if r.ContentLength > n {
+			throttledRequests.Inc("body_size")
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// IsBodyTooLarge reports whether err came from reading past a MaxBytes limit.
+func IsBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
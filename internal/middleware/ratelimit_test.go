@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"opamp-backend/internal/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterPerCallerAndGlobal(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewRateLimiter(config.APILimits{RequestsPerSecond: 1, Burst: 2, GlobalRequestsPerSecond: 10, GlobalBurst: 3})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, _, ok := l.Allow("alice"); !ok {
+			t.Fatalf("request %d within burst was limited", i)
+		}
+	}
+	wait, reason, ok := l.Allow("alice")
+	if ok || reason != "caller" || wait != time.Second {
+		t.Fatalf("Allow() = %v, %q, %v; want caller limit with 1s wait", wait, reason, ok)
+	}
+
+	// bob has a separate bucket, but only one request of the global burst is left.
+	if _, _, ok := l.Allow("bob"); !ok {
+		t.Fatal("bob was limited by alice's bucket")
+	}
+	if _, reason, ok := l.Allow("bob"); ok || reason != "global" {
+		t.Fatalf("expected the global limit, got %q, %v", reason, ok)
+	}
+
+	now = now.Add(time.Second)
+	if _, _, ok := l.Allow("alice"); !ok {
+		t.Fatal("alice was still limited after the bucket refilled")
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	l := NewRateLimiter(config.APILimits{})
+	for i := 0; i < 100; i++ {
+		if _, _, ok := l.Allow("alice"); !ok {
+			t.Fatal("limiter without rates limited a request")
+		}
+	}
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	l := NewRateLimiter(config.APILimits{RequestsPerSecond: 0.5, Burst: 1})
+	handler := l.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ctx := context.WithValue(context.Background(), principalKey{}, &Principal{Name: "alice"})
+
+	codes := []int{}
+	var retryAfter string
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/agents", nil).WithContext(ctx))
+		codes = append(codes, w.Code)
+		retryAfter = w.Header().Get("Retry-After")
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Fatalf("status codes = %v, want [200 429]", codes)
+	}
+	if retryAfter != "2" {
+		t.Errorf("Retry-After = %q, want 2", retryAfter)
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	c := NewConcurrencyLimit(1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := c.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/loglevel", nil))
+		close(done)
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/loglevel", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("second concurrent request got %d (Retry-After %q), want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	close(release)
+	<-done
+	if !c.acquire() {
+		t.Error("slot was not released after the first request finished")
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	var readErr error
+	handler := MaxBytes(func() int64 { return 8 }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 64)
+		for readErr == nil {
+			_, readErr = r.Body.Read(buf)
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/config", strings.NewReader(strings.Repeat("x", 16))))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("declared oversized body got %d, want 413", w.Code)
+	}
+
+	// Bodies without a declared length are cut off while reading.
+	req := httptest.NewRequest("POST", "/api/config", strings.NewReader(strings.Repeat("x", 16)))
+	req.ContentLength = -1
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if !IsBodyTooLarge(readErr) {
+		t.Errorf("read error = %v, want *http.MaxBytesError", readErr)
+	}
+}
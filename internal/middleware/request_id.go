@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"opamp-backend/internal/logging"
+)
+
+// RequestIDHeader is the header used to propagate request IDs.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID assigns each request an ID, reusing the caller's X-Request-ID if
+// present. The ID is echoed in the response and attached to the request
+// context so that log records carry it as request_id.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := logging.WithAttrs(r.Context(), "request_id", id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
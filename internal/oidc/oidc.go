@@ -0,0 +1,312 @@
+// Package oidc validates JWT bearer tokens issued by an OpenID Connect
+// provider and maps their group claims to API scopes. Only the RS256 and
+// ES256 signature algorithms are supported.
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"opamp-backend/internal/config"
+	"opamp-backend/internal/logging"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultJWKSRefresh = 15 * time.Minute
+	// minJWKSRefetch limits refetches triggered by tokens with unknown key IDs.
+	minJWKSRefetch = time.Minute
+	// clockSkew is tolerated when checking exp and nbf.
+	clockSkew = time.Minute
+)
+
+var logger = logging.For(logging.SubsystemAPI)
+
+// ErrInvalidToken is wrapped by every token validation error.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the validated claims of a token.
+type Claims struct {
+	Subject  string
+	Username string
+	Groups   []string
+	Scopes   []string // scopes granted through the configured group mapping
+}
+
+// Verifier validates tokens against the configured issuer, audience and keys.
+type Verifier struct {
+	cfg    config.OIDCConfig
+	client *http.Client
+
+	// keys is replaced as a whole, so that verifications never wait for a
+	// JWKS fetch unless they need its result.
+	keys atomic.Pointer[map[string]crypto.PublicKey]
+
+	mu        sync.Mutex
+	fetchedAt time.Time     // when the last fetch started
+	fetching  chan struct{} // closed when the fetch in progress ends
+}
+
+// NewVerifier creates a verifier. Keys from jwks_file are loaded immediately;
+// keys from jwks_url are fetched on first use.
+func NewVerifier(cfg config.OIDCConfig) (*Verifier, error) {
+	v := &Verifier{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+	if cfg.JWKSFile != "" {
+		data, err := os.ReadFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := parseJWKS(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.JWKSFile, err)
+		}
+		v.keys.Store(&keys)
+	}
+	return v, nil
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the token's signature and standard claims and returns its
+// identity.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed JWT", ErrInvalidToken)
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding", ErrInvalidToken)
+	}
+
+	key, err := v.key(h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(h.Alg, key, digest[:], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	return v.checkClaims(claims, time.Now())
+}
+
+func verifySignature(alg string, key crypto.PublicKey, digest, signature []byte) error {
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key type does not match %s", ErrInvalidToken, alg)
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest, signature); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key type does not match %s", ErrInvalidToken, alg)
+		}
+		// JWS encodes ES256 signatures as the 32-byte R and S values.
+		if len(signature) != 64 {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, alg)
+	}
+	return nil
+}
+
+func (v *Verifier) checkClaims(claims map[string]interface{}, now time.Time) (*Claims, error) {
+	if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, iss)
+	}
+	if !slices.Contains(stringList(claims["aud"]), v.cfg.Audience) {
+		return nil, fmt.Errorf("%w: audience does not include %q", ErrInvalidToken, v.cfg.Audience)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	}
+	if now.Add(-clockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("%w: token not yet valid", ErrInvalidToken)
+	}
+
+	result := &Claims{}
+	result.Subject, _ = claims["sub"].(string)
+	usernameClaim := v.cfg.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "sub"
+	}
+	result.Username, _ = claims[usernameClaim].(string)
+	if result.Username == "" {
+		result.Username = result.Subject
+	}
+
+	groupsClaim := v.cfg.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	result.Groups = stringList(claims[groupsClaim])
+	for _, group := range result.Groups {
+		for _, scope := range v.cfg.GroupScopes[group] {
+			if !slices.Contains(result.Scopes, scope) {
+				result.Scopes = append(result.Scopes, scope)
+			}
+		}
+	}
+	return result, nil
+}
+
+// key returns the verification key for kid, fetching the JWKS from jwks_url
+// when it is stale or does not contain kid.
+func (v *Verifier) key(kid string) (crypto.PublicKey, error) {
+	if v.cfg.JWKSURL != "" {
+		if err := v.refreshKeys(kid); err != nil {
+			return nil, err
+		}
+	}
+
+	var keys map[string]crypto.PublicKey
+	if current := v.keys.Load(); current != nil {
+		keys = *current
+	}
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	// Tokens without a key ID can only be verified against a single key.
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+}
+
+// refreshKeys fetches the JWKS from jwks_url when there are no keys yet, when
+// they are older than jwks_refresh, or when kid is unknown and the last fetch
+// is older than minJWKSRefetch. Only one fetch runs at a time; callers that
+// need its result wait for it, the others keep using the cached keys.
+func (v *Verifier) refreshKeys(kid string) error {
+	refresh := v.cfg.JWKSRefresh
+	if refresh <= 0 {
+		refresh = defaultJWKSRefresh
+	}
+
+	v.mu.Lock()
+	cached := v.keys.Load()
+	known := cached != nil && (*cached)[kid] != nil
+	if done := v.fetching; done != nil {
+		v.mu.Unlock()
+		if !known {
+			<-done
+		}
+		if v.keys.Load() == nil {
+			return fmt.Errorf("failed to fetch JWKS")
+		}
+		return nil
+	}
+	age := time.Since(v.fetchedAt)
+	if cached != nil && age <= refresh && (known || age <= minJWKSRefetch) {
+		v.mu.Unlock()
+		return nil
+	}
+	if cached == nil && age <= minJWKSRefetch {
+		v.mu.Unlock()
+		return fmt.Errorf("failed to fetch JWKS: retrying after %s", (minJWKSRefetch - age).Round(time.Second))
+	}
+	done := make(chan struct{})
+	v.fetching = done
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+
+	keys, err := v.fetchKeys()
+	if err == nil {
+		v.keys.Store(&keys)
+	}
+	v.mu.Lock()
+	v.fetching = nil
+	v.mu.Unlock()
+	close(done)
+
+	if err != nil {
+		if cached == nil {
+			return err
+		}
+		logger.Warn("Using cached JWKS after refresh failed", "jwks_url", v.cfg.JWKSURL, "error", err)
+	}
+	return nil
+}
+
+func (v *Verifier) fetchKeys() (map[string]crypto.PublicKey, error) {
+	resp, err := v.client.Get(v.cfg.JWKSURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	return parseJWKS(data)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// stringList accepts a claim that is either a string or a list of strings.
+func stringList(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
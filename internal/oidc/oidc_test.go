@@ -0,0 +1,291 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"opamp-backend/internal/config"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{"kid": kid, "kty": "RSA", "use": "sig", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	return map[string]string{"kid": kid, "kty": "EC", "crv": "P-256", "x": b64(x), "y": b64(y)}
+}
+
+func jwks(t *testing.T, keys ...map[string]string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// signToken builds a compact JWT signed with key (an RSA or P-256 private key).
+func signToken(t *testing.T, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signingInput + "." + b64(signature)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":    "https://sso.example.com",
+		"aud":    []string{"opamp-backend", "other"},
+		"sub":    "user-1",
+		"email":  "alice@example.com",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"groups": []string{"sre", "everyone"},
+	}
+}
+
+func testConfig() config.OIDCConfig {
+	return config.OIDCConfig{
+		Enabled:       true,
+		Issuer:        "https://sso.example.com",
+		Audience:      "opamp-backend",
+		UsernameClaim: "email",
+		GroupScopes: map[string][]string{
+			"sre":      {config.ScopeLogLevel, config.ScopeRead},
+			"everyone": {config.ScopeRead},
+		},
+	}
+}
+
+func TestVerify_JWKSFile(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	cfg := testConfig()
+	cfg.JWKSFile = filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(cfg.JWKSFile, jwks(t, rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVerifier(cfg)
+	if err != nil {
+		t.Fatalf("NewVerifier error: %v", err)
+	}
+
+	for _, token := range []string{
+		signToken(t, "rsa-1", rsaKey, validClaims()),
+		signToken(t, "ec-1", ecKey, validClaims()),
+	} {
+		claims, err := v.Verify(token)
+		if err != nil {
+			t.Fatalf("Verify error: %v", err)
+		}
+		if claims.Username != "alice@example.com" {
+			t.Errorf("expected username from email claim, got %q", claims.Username)
+		}
+		if !slices.Equal(claims.Scopes, []string{config.ScopeLogLevel, config.ScopeRead}) {
+			t.Errorf("unexpected scopes %v", claims.Scopes)
+		}
+	}
+}
+
+func TestVerify_Rejects(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	cfg := testConfig()
+	cfg.JWKSFile = filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(cfg.JWKSFile, jwks(t, rsaJWK("rsa-1", &rsaKey.PublicKey)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVerifier(cfg)
+	if err != nil {
+		t.Fatalf("NewVerifier error: %v", err)
+	}
+
+	with := func(claim string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		claims[claim] = value
+		return claims
+	}
+	tests := map[string]string{
+		"wrong issuer":   signToken(t, "rsa-1", rsaKey, with("iss", "https://evil.example.com")),
+		"wrong audience": signToken(t, "rsa-1", rsaKey, with("aud", "someone-else")),
+		"expired":        signToken(t, "rsa-1", rsaKey, with("exp", time.Now().Add(-time.Hour).Unix())),
+		"not yet valid":  signToken(t, "rsa-1", rsaKey, with("nbf", time.Now().Add(time.Hour).Unix())),
+		"wrong key":      signToken(t, "rsa-1", otherKey, validClaims()),
+		"unknown kid":    signToken(t, "rsa-2", rsaKey, validClaims()),
+		"malformed":      "not-a-jwt",
+	}
+	for name, token := range tests {
+		if _, err := v.Verify(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
+
+func TestVerify_JWKSURLRotation(t *testing.T) {
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var current atomic.Value
+	current.Store(jwks(t, ecJWK("old", &oldKey.PublicKey)))
+	var fetches atomic.Int32
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write(current.Load().([]byte))
+	}))
+	defer jwksServer.Close()
+
+	cfg := testConfig()
+	cfg.JWKSURL = jwksServer.URL
+	v, err := NewVerifier(cfg)
+	if err != nil {
+		t.Fatalf("NewVerifier error: %v", err)
+	}
+
+	if _, err := v.Verify(signToken(t, "old", oldKey, validClaims())); err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+	if _, err := v.Verify(signToken(t, "old", oldKey, validClaims())); err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected keys to be cached after one fetch, got %d fetches", n)
+	}
+
+	// A token signed with a rotated key triggers a refetch once the
+	// minimum refetch interval has passed.
+	current.Store(jwks(t, ecJWK("new", &newKey.PublicKey)))
+	v.mu.Lock()
+	v.fetchedAt = time.Now().Add(-2 * minJWKSRefetch)
+	v.mu.Unlock()
+	if _, err := v.Verify(signToken(t, "new", newKey, validClaims())); err != nil {
+		t.Fatalf("expected rotated key to be fetched, got %v", err)
+	}
+}
+
+func TestVerify_JWKSFetchDoesNotBlockCachedKeys(t *testing.T) {
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var current atomic.Value
+	current.Store(jwks(t, ecJWK("old", &oldKey.PublicKey)))
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		w.Write(current.Load().([]byte))
+	}))
+	defer jwksServer.Close()
+	releaseFetch := sync.OnceFunc(func() { close(release) })
+	defer releaseFetch()
+
+	cfg := testConfig()
+	cfg.JWKSURL = jwksServer.URL
+	v, err := NewVerifier(cfg)
+	if err != nil {
+		t.Fatalf("NewVerifier error: %v", err)
+	}
+	if _, err := v.Verify(signToken(t, "old", oldKey, validClaims())); err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+
+	// Tokens signed with the rotated key wait for a single, slow refetch.
+	current.Store(jwks(t, ecJWK("old", &oldKey.PublicKey), ecJWK("new", &newKey.PublicKey)))
+	v.mu.Lock()
+	v.fetchedAt = time.Now().Add(-2 * minJWKSRefetch)
+	v.mu.Unlock()
+	results := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := v.Verify(signToken(t, "new", newKey, validClaims()))
+			results <- err
+		}()
+	}
+	for fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Meanwhile tokens with a cached key are verified right away.
+	verified := make(chan error, 1)
+	go func() {
+		_, err := v.Verify(signToken(t, "old", oldKey, validClaims()))
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("Verify with a cached key error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Verify with a cached key waited for the JWKS fetch")
+	}
+
+	releaseFetch()
+	for range 2 {
+		if err := <-results; err != nil {
+			t.Errorf("expected rotated key to be fetched, got %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("expected one refetch for concurrent tokens, got %d fetches", n)
+	}
+
+	// Unknown key IDs do not refetch again within minJWKSRefetch.
+	unknownKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	for range 3 {
+		if _, err := v.Verify(signToken(t, "unknown", unknownKey, validClaims())); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected ErrInvalidToken for an unknown key, got %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("expected unknown key IDs to be rate limited, got %d fetches", n)
+	}
+}
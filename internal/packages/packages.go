@@ -0,0 +1,394 @@
+// Package packages keeps the registry of packages offered to agents: the
+// package files on disk, their version, hash and optional signature, the
+// assignments of package versions to groups of agents, and the signed URLs
+// agents download them from.
+package packages
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"opamp-backend/internal/agents"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+// Package types, as in PackageAvailable.
+const (
+	TypeTopLevel = "top_level"
+	TypeAddon    = "addon"
+)
+
+// Errors returned by the registry.
+var (
+	ErrDisabled = errors.New("package registry is not configured")
+	ErrNotFound = errors.New("package not found")
+	ErrExists   = errors.New("package version already exists")
+	ErrInvalid  = errors.New("invalid package")
+	ErrDenied   = errors.New("package download not permitted")
+)
+
+// DownloadURLValidity is how long the download URL offered to an agent can
+// be used.
+const DownloadURLValidity = 24 * time.Hour
+
+// validName restricts package names and versions to safe file names.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// Package is one uploaded version of a package.
+type Package struct {
+	Name       string    `json:"name"`
+	Version    string    `json:"version"`
+	Type       string    `json:"type"`
+	Hash       string    `json:"hash"` // SHA-256 of the file, hex encoded
+	Signature  []byte    `json:"signature,omitempty"`
+	Size       int64     `json:"size"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// Assignment offers a package version to the agents matched by a selector.
+type Assignment struct {
+	Name    string          `json:"name"`
+	Version string          `json:"version"`
+	Agents  agents.Selector `json:"agents"`
+}
+
+// index is the registry's index file.
+type index struct {
+	Packages    []Package    `json:"packages"`
+	Assignments []Assignment `json:"assignments"`
+}
+
+// Registry is a package registry stored in a directory: an index.json file,
+// the package files under files/<name>/<version> and the download.key that
+// signs download URLs.
+type Registry struct {
+	mu          sync.RWMutex
+	dir         string
+	packages    map[string]Package // by name and version
+	assignments []Assignment
+	downloadKey []byte
+}
+
+// Open loads the registry in dir, creating the directory if needed.
+func Open(dir string) (*Registry, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0755); err != nil {
+		return nil, err
+	}
+	r := &Registry{dir: dir, packages: make(map[string]Package)}
+	downloadKey, err := loadDownloadKey(filepath.Join(dir, "download.key"))
+	if err != nil {
+		return nil, err
+	}
+	r.downloadKey = downloadKey
+	data, err := os.ReadFile(r.indexPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var idx index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", r.indexPath(), err)
+	}
+	for _, p := range idx.Packages {
+		r.packages[key(p.Name, p.Version)] = p
+	}
+	r.assignments = idx.Assignments
+	return r, nil
+}
+
+// loadDownloadKey reads the key that signs download URLs, creating it on
+// first use so that URLs stay valid across restarts.
+func loadDownloadKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func key(name, version string) string {
+	return name + "\x00" + version
+}
+
+func (r *Registry) indexPath() string {
+	return filepath.Join(r.dir, "index.json")
+}
+
+// FilePath returns where the file of a package version is stored.
+func (r *Registry) FilePath(name, version string) string {
+	return filepath.Join(r.dir, "files", name, version)
+}
+
+// Add stores a new package version read from content. Versions are immutable:
+// adding one that exists returns ErrExists.
+func (r *Registry) Add(name, version, packageType string, signature []byte, content io.Reader) (Package, error) {
+	if !validName.MatchString(name) || !validName.MatchString(version) {
+		return Package{}, fmt.Errorf("%w: name and version must be non-empty and only contain letters, digits, '.', '_', '+' or '-'", ErrInvalid)
+	}
+	if packageType == "" {
+		packageType = TypeTopLevel
+	}
+	if packageType != TypeTopLevel && packageType != TypeAddon {
+		return Package{}, fmt.Errorf("%w: unknown type %q (expected %s or %s)", ErrInvalid, packageType, TypeTopLevel, TypeAddon)
+	}
+
+	if _, exists := r.Get(name, version); exists {
+		return Package{}, fmt.Errorf("%w: %s %s", ErrExists, name, version)
+	}
+
+	// Stream the upload without holding the lock, which agents' messages
+	// need to look up their packages
+	path := r.FilePath(name, version)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return Package{}, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return Package{}, err
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), content)
+	if err != nil {
+		tmp.Close()
+		return Package{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return Package{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Another upload of the same version may have finished meanwhile
+	if _, exists := r.packages[key(name, version)]; exists {
+		return Package{}, fmt.Errorf("%w: %s %s", ErrExists, name, version)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return Package{}, err
+	}
+
+	p := Package{
+		Name:       name,
+		Version:    version,
+		Type:       packageType,
+		Hash:       hex.EncodeToString(hash.Sum(nil)),
+		Signature:  signature,
+		Size:       size,
+		UploadedAt: time.Now().UTC(),
+	}
+	r.packages[key(name, version)] = p
+	if err := r.save(); err != nil {
+		delete(r.packages, key(name, version))
+		os.Remove(path)
+		return Package{}, err
+	}
+	return p, nil
+}
+
+// Get returns a package version.
+func (r *Registry) Get(name, version string) (Package, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, exists := r.packages[key(name, version)]
+	return p, exists
+}
+
+// List returns every package version, sorted by name and version.
+func (r *Registry) List() []Package {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]Package, 0, len(r.packages))
+	for _, p := range r.packages {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].Version < list[j].Version
+	})
+	return list
+}
+
+// Assign offers a package version to the agents matched by selector,
+// replacing an earlier assignment of the package to the same selector. An
+// empty version removes that assignment.
+func (r *Registry) Assign(name, version string, selector agents.Selector) (Assignment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if version != "" {
+		if _, exists := r.packages[key(name, version)]; !exists {
+			return Assignment{}, fmt.Errorf("%w: %s %s", ErrNotFound, name, version)
+		}
+	}
+
+	assignments := make([]Assignment, 0, len(r.assignments)+1)
+	for _, a := range r.assignments {
+		if a.Name != name || !reflect.DeepEqual(a.Agents, selector) {
+			assignments = append(assignments, a)
+		}
+	}
+	assignment := Assignment{Name: name, Version: version, Agents: selector}
+	if version != "" {
+		assignments = append(assignments, assignment)
+	}
+
+	previous := r.assignments
+	r.assignments = assignments
+	if err := r.save(); err != nil {
+		r.assignments = previous
+		return Assignment{}, err
+	}
+	return assignment, nil
+}
+
+// Assignments returns the assignments in the order they were made.
+func (r *Registry) Assignments() []Assignment {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Assignment(nil), r.assignments...)
+}
+
+// ForAgent returns the package versions assigned to agent by name. Later
+// assignments take precedence over earlier ones.
+func (r *Registry) ForAgent(agent *agents.Agent) map[string]Package {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	assigned := make(map[string]Package)
+	for _, a := range r.assignments {
+		if a.Agents.Matches(agent) {
+			assigned[a.Name] = r.packages[key(a.Name, a.Version)]
+		}
+	}
+	return assigned
+}
+
+// save writes the index through a temporary file so readers never see a
+// partial file. The caller must hold r.mu.
+func (r *Registry) save() error {
+	idx := index{Packages: make([]Package, 0, len(r.packages)), Assignments: r.assignments}
+	for _, p := range r.packages {
+		idx.Packages = append(idx.Packages, p)
+	}
+	sort.Slice(idx.Packages, func(i, j int) bool {
+		return key(idx.Packages[i].Name, idx.Packages[i].Version) < key(idx.Packages[j].Name, idx.Packages[j].Version)
+	})
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(r.dir, ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.indexPath())
+}
+
+// Available builds the PackagesAvailable message offering the assigned
+// packages, each downloaded from the URL returned by downloadURL.
+func Available(assigned map[string]Package, downloadURL func(Package) string) *protobufs.PackagesAvailable {
+	names := make([]string, 0, len(assigned))
+	for name := range assigned {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	available := &protobufs.PackagesAvailable{Packages: make(map[string]*protobufs.PackageAvailable, len(assigned))}
+	all := sha256.New()
+	for _, name := range names {
+		p := assigned[name]
+		hash, _ := hex.DecodeString(p.Hash)
+		packageType := protobufs.PackageType_PackageType_TopLevel
+		if p.Type == TypeAddon {
+			packageType = protobufs.PackageType_PackageType_Addon
+		}
+		available.Packages[name] = &protobufs.PackageAvailable{
+			Type:    packageType,
+			Version: p.Version,
+			Hash:    hash,
+			File: &protobufs.DownloadableFile{
+				DownloadUrl: downloadURL(p),
+				ContentHash: hash,
+				Signature:   p.Signature,
+			},
+		}
+		fmt.Fprintf(all, "%s\x00%s\x00%s\x00", name, p.Version, p.Hash)
+	}
+	available.AllPackagesHash = all.Sum(nil)
+	return available
+}
+
+// DownloadURL returns the URL a package version is served at.
+func DownloadURL(baseURL, name, version string) string {
+	return baseURL + "/packages/" + name + "/" + version
+}
+
+// SignedDownloadURL returns the URL under baseURL from which agentID may
+// download a package version until expires.
+func (r *Registry) SignedDownloadURL(baseURL, agentID, name, version string, expires time.Time) string {
+	query := url.Values{}
+	query.Set("agent_id", agentID)
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", hex.EncodeToString(r.sign(agentID, name, version, expires.Unix())))
+	return DownloadURL(baseURL, name, version) + "?" + query.Encode()
+}
+
+// VerifyDownload checks the query of a signed download URL for a package
+// version at now. It returns the ID of the agent the URL was issued to, or
+// an error wrapping ErrDenied.
+func (r *Registry) VerifyDownload(name, version string, query url.Values, now time.Time) (string, error) {
+	agentID := query.Get("agent_id")
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if agentID == "" || err != nil {
+		return "", fmt.Errorf("%w: the download URL is not signed", ErrDenied)
+	}
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil || !hmac.Equal(signature, r.sign(agentID, name, version, expires)) {
+		return "", fmt.Errorf("%w: invalid signature", ErrDenied)
+	}
+	if now.Unix() > expires {
+		return "", fmt.Errorf("%w: the download URL has expired", ErrDenied)
+	}
+	return agentID, nil
+}
+
+func (r *Registry) sign(agentID, name, version string, expires int64) []byte {
+	mac := hmac.New(sha256.New, r.downloadKey)
+	fmt.Fprintf(mac, "%s\x00%s\x00%s\x00%d", agentID, name, version, expires)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,195 @@
+package packages
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/url"
+	"opamp-backend/internal/agents"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryAddAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	registry, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("collector binary")
+	p, err := registry.Add("otelcol", "0.98.0", "", []byte("sig"), bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	sum := sha256.Sum256(content)
+	if p.Hash != hex.EncodeToString(sum[:]) || p.Size != int64(len(content)) || p.Type != TypeTopLevel {
+		t.Errorf("Add() = %+v", p)
+	}
+	if data, err := os.ReadFile(registry.FilePath("otelcol", "0.98.0")); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("stored file = %q, %v", data, err)
+	}
+
+	if _, err := registry.Add("otelcol", "0.98.0", "", nil, strings.NewReader("other")); !errors.Is(err, ErrExists) {
+		t.Errorf("Add() of an existing version error = %v, want ErrExists", err)
+	}
+	for _, name := range []string{"", "../etc", "a/b"} {
+		if _, err := registry.Add(name, "1", "", nil, strings.NewReader("x")); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Add(%q) error = %v, want ErrInvalid", name, err)
+		}
+	}
+	if _, err := registry.Add("otelcol", "1", "plugin", nil, strings.NewReader("x")); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Add() with an unknown type error = %v, want ErrInvalid", err)
+	}
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := reopened.Get("otelcol", "0.98.0"); !ok || got.Hash != p.Hash || string(got.Signature) != "sig" {
+		t.Errorf("reopened registry has %+v, %v", got, ok)
+	}
+}
+
+func TestRegistryAddDoesNotBlockLookups(t *testing.T) {
+	registry, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An upload still streaming its content
+	upload, writer := io.Pipe()
+	done := make(chan error)
+	go func() {
+		_, err := registry.Add("otelcol", "1.0", "", nil, upload)
+		done <- err
+	}()
+	writer.Write([]byte("first half"))
+
+	lookup := make(chan struct{})
+	go func() {
+		registry.ForAgent(&agents.Agent{ID: "a"})
+		registry.Assign("otelcol", "", agents.Selector{})
+		close(lookup)
+	}()
+	select {
+	case <-lookup:
+	case <-time.After(time.Second):
+		t.Fatal("Expected lookups and assignments not to wait for an upload")
+	}
+
+	writer.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if p, ok := registry.Get("otelcol", "1.0"); !ok || p.Size != int64(len("first half")) {
+		t.Errorf("Get() = %+v, %v", p, ok)
+	}
+}
+
+func TestRegistryAssignments(t *testing.T) {
+	registry, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []string{"1.0", "2.0"} {
+		if _, err := registry.Add("otelcol", version, "", nil, strings.NewReader(version)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := registry.Assign("otelcol", "3.0", agents.Selector{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Assign() of a missing version error = %v, want ErrNotFound", err)
+	}
+
+	canary := agents.Selector{Labels: map[string]string{"ring": "canary"}}
+	if _, err := registry.Assign("otelcol", "1.0", agents.Selector{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.Assign("otelcol", "2.0", canary); err != nil {
+		t.Fatal(err)
+	}
+
+	stable := &agents.Agent{ID: "a"}
+	canaryAgent := &agents.Agent{ID: "b", Labels: map[string]string{"ring": "canary"}}
+	if got := registry.ForAgent(stable)["otelcol"].Version; got != "1.0" {
+		t.Errorf("stable agent is assigned %q, want 1.0", got)
+	}
+	if got := registry.ForAgent(canaryAgent)["otelcol"].Version; got != "2.0" {
+		t.Errorf("canary agent is assigned %q, want 2.0", got)
+	}
+
+	// Removing the canary assignment falls back to the fleet-wide one.
+	if _, err := registry.Assign("otelcol", "", canary); err != nil {
+		t.Fatal(err)
+	}
+	if got := registry.ForAgent(canaryAgent)["otelcol"].Version; got != "1.0" {
+		t.Errorf("canary agent is assigned %q after removal, want 1.0", got)
+	}
+	if len(registry.Assignments()) != 1 {
+		t.Errorf("Assignments() = %+v", registry.Assignments())
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	assigned := map[string]Package{
+		"otelcol": {Name: "otelcol", Version: "1.0", Type: TypeTopLevel, Hash: "00ff"},
+		"plugin":  {Name: "plugin", Version: "0.1", Type: TypeAddon, Hash: "aa"},
+	}
+	downloadURL := func(p Package) string { return DownloadURL("https://backend:8081", p.Name, p.Version) }
+	available := Available(assigned, downloadURL)
+	if got := available.Packages["otelcol"].File.DownloadUrl; got != "https://backend:8081/packages/otelcol/1.0" {
+		t.Errorf("download URL = %q", got)
+	}
+	if !bytes.Equal(available.Packages["plugin"].Hash, []byte{0xaa}) {
+		t.Errorf("package hash = %x", available.Packages["plugin"].Hash)
+	}
+	if again := Available(assigned, downloadURL); !bytes.Equal(again.AllPackagesHash, available.AllPackagesHash) {
+		t.Error("AllPackagesHash is not stable")
+	}
+	delete(assigned, "plugin")
+	if bytes.Equal(Available(assigned, downloadURL).AllPackagesHash, available.AllPackagesHash) {
+		t.Error("AllPackagesHash did not change with the packages")
+	}
+}
+
+func TestSignedDownloadURL(t *testing.T) {
+	dir := t.TempDir()
+	registry, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	signed := registry.SignedDownloadURL("https://backend:8081", "0f01", "otelcol", "1.0", now.Add(time.Hour))
+	base, rawQuery, _ := strings.Cut(signed, "?")
+	if base != "https://backend:8081/packages/otelcol/1.0" {
+		t.Errorf("download URL = %q", signed)
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The key is kept, so URLs stay valid after a restart.
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agentID, err := reopened.VerifyDownload("otelcol", "1.0", query, now); err != nil || agentID != "0f01" {
+		t.Errorf("VerifyDownload() = %q, %v", agentID, err)
+	}
+
+	if _, err := registry.VerifyDownload("otelcol", "2.0", query, now); !errors.Is(err, ErrDenied) {
+		t.Errorf("VerifyDownload() for another version = %v, want ErrDenied", err)
+	}
+	if _, err := registry.VerifyDownload("otelcol", "1.0", query, now.Add(2*time.Hour)); !errors.Is(err, ErrDenied) {
+		t.Errorf("VerifyDownload() after expiry = %v, want ErrDenied", err)
+	}
+	if _, err := registry.VerifyDownload("otelcol", "1.0", url.Values{}, now); !errors.Is(err, ErrDenied) {
+		t.Errorf("VerifyDownload() without signature = %v, want ErrDenied", err)
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"opamp-backend/internal/agentauth"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/metrics"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	opampTypes "github.com/open-telemetry/opamp-go/server/types"
+)
+
+var rejectedConnections = metrics.NewCounter(
+	"opamp_rejected_connections_total",
+	"Agent connections rejected by OpAMP authentication.",
+	"reason",
+)
+
+// newAgent creates the agent record for a connection, including the identity
+// it authenticated with.
+func newAgent(agentID string, conn opampTypes.Connection, transport string, identity agentauth.Identity, clientCert *agentauth.ClientCert) *agents.Agent {
+	agent := &agents.Agent{
+		ID:           agentID,
+		Conn:         conn,
+		Transport:    transport,
+		AuthIdentity: identity.String(),
+	}
+	if clientCert != nil {
+		agent.ClientCertSubject = clientCert.Subject
+		agent.ClientCertSANs = clientCert.SANs
+	}
+	return agent
+}
+
+// rejectConnection builds the response for a connection request that failed
+// authentication in OnConnecting.
+func rejectConnection(request *http.Request, err error) opampTypes.ConnectionResponse {
+	status := http.StatusUnauthorized
+	reason := agentauth.ReasonInvalidCredentials
+	var authErr *agentauth.Error
+	if errors.As(err, &authErr) {
+		status = authErr.Status
+		reason = authErr.Reason
+	}
+
+	rejectedConnections.Inc(reason)
+	logger.Warn("Rejected agent connection",
+		"remote_addr", request.RemoteAddr,
+		"reason", reason,
+		"error", err)
+
+	response := opampTypes.ConnectionResponse{
+		Accept:         false,
+		HTTPStatusCode: status,
+	}
+	if status == http.StatusUnauthorized {
+		response.HTTPResponseHeader = map[string]string{
+			"WWW-Authenticate": agentauth.SchemeSharedSecret + ", " + agentauth.SchemeEnrollment + ", " + agentauth.SchemeBearer,
+		}
+	}
+	return response
+}
+
+// rejectAgent ends the session of an agent whose credential does not permit
+// the instance UID it reported, or whose enrollment was rejected. It returns the error to include in the
+// response to the agent's message.
+func (s *Server) rejectAgent(conn opampTypes.Connection, agentID, transport string, err error) *protobufs.ServerErrorResponse {
+	reason := agentauth.ReasonAgentIDMismatch
+	var authErr *agentauth.Error
+	if errors.As(err, &authErr) {
+		reason = authErr.Reason
+	} else if errors.Is(err, errEnrollmentRejected) {
+		reason = ReasonEnrollmentRejected
+	}
+
+	rejectedConnections.Inc(reason)
+	logger.Warn("Rejected agent", "agent_id", agentID, "reason", reason, "error", err)
+
+	s.agentManager.DeregisterAgent(agentID)
+	if transport == agents.TransportWebSocket {
+		conn.Disconnect()
+	}
+
+	return &protobufs.ServerErrorResponse{
+		Type:         protobufs.ServerErrorResponseType_ServerErrorResponseType_BadRequest,
+		ErrorMessage: err.Error(),
+	}
+}
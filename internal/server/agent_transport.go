@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"opamp-backend/internal/agents"
+	"strings"
+	"time"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	opampTypes "github.com/open-telemetry/opamp-go/server/types"
+)
+
+// defaultHTTPIdleTimeout removes plain HTTP agents that stopped polling when
+// no opamp.http_idle_timeout is configured.
+const defaultHTTPIdleTimeout = 5 * time.Minute
+
+// sendToAgent delivers a message to an agent. Agents polling over plain HTTP
+// cannot be sent to directly, so their messages are queued and returned in
+// the response to their next poll. Every message advertises the server's
+// capabilities. Messages the agent has not advertised the capability to
+// accept are not sent and return an error wrapping agents.ErrUnsupported.
+// Remote configurations using components the agent reported it does not
+// include are rejected with an error wrapping agents.ErrMissingComponents.
+func (s *Server) sendToAgent(ctx context.Context, agent *agents.Agent, message *protobufs.ServerToAgent) error {
+	for _, capability := range agents.RequiredCapabilities(message) {
+		if err := agent.RequireCapability(capability); err != nil {
+			return err
+		}
+	}
+	if custom := message.CustomMessage; custom != nil {
+		if err := agent.RequireCustomCapability(custom.Capability); err != nil {
+			return err
+		}
+	}
+	if err := agent.CheckComponents(message.RemoteConfig); err != nil {
+		return err
+	}
+	message.Capabilities = serverCapabilities(s.getConfig())
+
+	if agent.Transport == agents.TransportHTTP {
+		if err := s.agentManager.QueueMessage(agent.ID, message); err != nil {
+			return err
+		}
+		logger.DebugContext(ctx, "Queued message for the agent's next poll", "agent_id", agent.ID)
+		return nil
+	}
+
+	conn, ok := agent.Conn.(opampTypes.Connection)
+	if !ok || conn == nil {
+		return fmt.Errorf("agent %s connection is not valid", agent.ID)
+	}
+	return conn.Send(ctx, message)
+}
+
+// deliverQueued adds the messages queued for an HTTP agent to the response
+// to its poll.
+func (s *Server) deliverQueued(agentID string, response *protobufs.ServerToAgent) {
+	if queued := s.agentManager.TakeQueuedMessage(agentID); queued != nil {
+		agents.MergeServerToAgent(response, queued)
+		logger.Debug("Delivered queued message", "agent_id", agentID)
+	}
+}
+
+// sweepIdleHTTPAgents periodically removes agents connected over plain HTTP
+// that have stopped polling, until ctx is cancelled.
+func (s *Server) sweepIdleHTTPAgents(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			timeout := s.getConfig().OpAMP.HTTPIdleTimeout
+			if timeout <= 0 {
+				timeout = defaultHTTPIdleTimeout
+			}
+			for _, id := range s.agentManager.RemoveIdleAgents(agents.TransportHTTP, now.Add(-timeout)) {
+				logger.Info("Removed agent that stopped polling", "agent_id", id, "idle_timeout", timeout)
+			}
+		}
+	}
+}
+
+// isUnsupported reports whether err is a push the agent did not advertise
+// the capability to accept.
+func isUnsupported(err error) bool {
+	return errors.Is(err, agents.ErrUnsupported)
+}
+
+// unsupportedNote describes the agents skipped by a debug endpoint because
+// they do not accept remote configuration.
+func unsupportedNote(agentIDs []string) string {
+	if len(agentIDs) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("; %d agents do not accept remote configuration (unsupported): %s", len(agentIDs), strings.Join(agentIDs, ", "))
+}
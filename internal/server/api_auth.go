@@ -0,0 +1,50 @@
+package server
+
+import (
+	"opamp-backend/internal/config"
+	"opamp-backend/internal/middleware"
+	"opamp-backend/internal/oidc"
+)
+
+// apiAuth holds the API tokens and OIDC verifier loaded from a configuration.
+type apiAuth struct {
+	tokens   middleware.TokenSet
+	verifier *oidc.Verifier
+}
+
+// configureAPIAuth installs the API tokens and OIDC verifier from cfg. Nothing
+// is changed if either fails to load.
+func configureAPIAuth(cfg config.Config) error {
+	auth, err := loadAPIAuth(cfg)
+	if err != nil {
+		return err
+	}
+	auth.use()
+	return nil
+}
+
+// loadAPIAuth loads the API tokens and OIDC verifier from cfg without
+// installing them.
+func loadAPIAuth(cfg config.Config) (apiAuth, error) {
+	configured, err := cfg.APITokens()
+	if err != nil {
+		return apiAuth{}, err
+	}
+	tokens, err := middleware.ParseTokens(configured)
+	if err != nil {
+		return apiAuth{}, err
+	}
+
+	var verifier *oidc.Verifier
+	if cfg.API.OIDC.Enabled {
+		if verifier, err = oidc.NewVerifier(cfg.API.OIDC); err != nil {
+			return apiAuth{}, err
+		}
+	}
+	return apiAuth{tokens: tokens, verifier: verifier}, nil
+}
+
+func (a apiAuth) use() {
+	middleware.UseTokens(a.tokens)
+	middleware.SetJWTVerifier(a.verifier)
+}
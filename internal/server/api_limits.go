@@ -0,0 +1,19 @@
+package server
+
+import (
+	"net/http"
+	"opamp-backend/internal/middleware"
+)
+
+// apiRoute registers an authenticated API handler, applying the caller rate
+// limits and the route's body size limit after authentication.
+func (s *Server) apiRoute(mux *http.ServeMux, path, scope string, handler http.Handler) {
+	bodyLimit := func() int64 { return s.getConfig().API.Limits.BodyLimit(path) }
+	mux.Handle(path, middleware.RequireScope(scope, s.rateLimiter.Limit(middleware.MaxBytes(bodyLimit, handler))))
+}
+
+// fanOutRoute registers an API handler that pushes to many agents, so it is
+// additionally capped by the fan-out concurrency limit.
+func (s *Server) fanOutRoute(mux *http.ServeMux, path, scope string, handler http.Handler) {
+	s.apiRoute(mux, path, scope, s.fanOuts.Limit(handler))
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/api"
+	"opamp-backend/internal/apitokens"
+	"opamp-backend/internal/config"
+	"time"
+)
+
+var _ api.TokenServer = (*Server)(nil)
+
+// ListAPITokens returns the minted tokens without their hashes.
+func (s *Server) ListAPITokens() ([]apitokens.Token, error) {
+	if s.tokenStore == nil {
+		return nil, apitokens.ErrDisabled
+	}
+	return s.tokenStore.List(), nil
+}
+
+// MintAPIToken creates a token and returns its secret, which is only
+// available now.
+func (s *Server) MintAPIToken(name string, scopes []string, selector agents.Selector, expiresAt *time.Time) (string, apitokens.Token, error) {
+	if s.tokenStore == nil {
+		return "", apitokens.Token{}, apitokens.ErrDisabled
+	}
+	if err := config.ValidateScopes(scopes); err != nil {
+		return "", apitokens.Token{}, fmt.Errorf("%w: %v", apitokens.ErrInvalid, err)
+	}
+	cfg := s.getConfig()
+	configured, err := cfg.APITokens()
+	if err != nil {
+		return "", apitokens.Token{}, err
+	}
+	for _, t := range configured {
+		if t.Name == name {
+			return "", apitokens.Token{}, fmt.Errorf("%w: name %q is used by a configured token", apitokens.ErrInvalid, name)
+		}
+	}
+	return s.tokenStore.Mint(name, scopes, selector, expiresAt)
+}
+
+// ExpireAPIToken sets when a minted token stops being accepted.
+func (s *Server) ExpireAPIToken(id string, at time.Time) (apitokens.Token, error) {
+	if s.tokenStore == nil {
+		return apitokens.Token{}, apitokens.ErrDisabled
+	}
+	return s.tokenStore.Expire(id, at)
+}
+
+// RevokeAPIToken disables a minted token permanently.
+func (s *Server) RevokeAPIToken(id string) (apitokens.Token, error) {
+	if s.tokenStore == nil {
+		return apitokens.Token{}, apitokens.ErrDisabled
+	}
+	return s.tokenStore.Revoke(id)
+}
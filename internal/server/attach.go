@@ -0,0 +1,43 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/open-telemetry/opamp-go/server"
+)
+
+// opampPath is where agents reach the OpAMP endpoint, on either listener.
+const opampPath = "/v1/opamp"
+
+// attachOpamp serves OpAMP from the API listener instead of a listener of
+// its own. Agent requests bypass the API middleware; OnConnecting applies
+// agent authentication as it does on the dedicated listener. The returned
+// ConnContext must be set on the http.Server.
+func (s *Server) attachOpamp(apiHandler http.Handler) (http.Handler, server.ConnContext, error) {
+	opampHandler, connContext, err := s.opampServer.Attach(s.opampSettings())
+	if err != nil {
+		return nil, nil, err
+	}
+	root := http.NewServeMux()
+	root.Handle(opampPath, http.HandlerFunc(opampHandler))
+	root.Handle("/", apiHandler)
+	return root, connContext, nil
+}
+
+// attachListener wraps the shared listener in the OpAMP TLS configuration, if
+// any, so that agents can still present client certificates.
+func (s *Server) attachListener(l net.Listener) (net.Listener, error) {
+	cfg := s.getConfig()
+	if !cfg.TLSEnabled() {
+		return l, nil
+	}
+	certStore, err := s.loadCertStore(cfg)
+	if err != nil {
+		s.setTLSLoaded(false)
+		return nil, err
+	}
+	s.setTLSLoaded(true)
+	return tls.NewListener(l, certStore.TLSConfig()), nil
+}
@@ -0,0 +1,16 @@
+package server
+
+import (
+	"opamp-backend/internal/api"
+	"opamp-backend/internal/audit"
+)
+
+var _ api.AuditServer = (*Server)(nil)
+
+// QueryAudit searches the audit log.
+func (s *Server) QueryAudit(q audit.Query) ([]*audit.Entry, error) {
+	if s.auditLog == nil {
+		return nil, audit.ErrDisabled
+	}
+	return s.auditLog.Search(q)
+}
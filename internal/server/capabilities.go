@@ -0,0 +1,28 @@
+package server
+
+import (
+	"opamp-backend/internal/config"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+// serverCapabilities returns the capabilities advertised in every message to
+// agents, derived from the features enabled in cfg.
+func serverCapabilities(cfg config.Config) uint64 {
+	// Status reports, remote configuration (log level updates) and effective
+	// configuration reports are always handled.
+	caps := protobufs.ServerCapabilities_ServerCapabilities_AcceptsStatus |
+		protobufs.ServerCapabilities_ServerCapabilities_OffersRemoteConfig |
+		protobufs.ServerCapabilities_ServerCapabilities_AcceptsEffectiveConfig
+	if len(cfg.ConnectionSettings) > 0 || cfg.Certificates.Enabled() {
+		caps |= protobufs.ServerCapabilities_ServerCapabilities_OffersConnectionSettings
+	}
+	if cfg.Certificates.Enabled() {
+		caps |= protobufs.ServerCapabilities_ServerCapabilities_AcceptsConnectionSettingsRequest
+	}
+	if cfg.Packages.Enabled() {
+		caps |= protobufs.ServerCapabilities_ServerCapabilities_OffersPackages |
+			protobufs.ServerCapabilities_ServerCapabilities_AcceptsPackagesStatus
+	}
+	return uint64(caps)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"opamp-backend/internal/agentauth"
+	"opamp-backend/internal/api"
+	"opamp-backend/internal/config"
+	"opamp-backend/internal/issuer"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+var _ api.CertificateServer = (*Server)(nil)
+
+// certificatePolicy returns the issuing policy configured in cfg.
+func certificatePolicy(cfg config.CertificatesConfig) issuer.Policy {
+	return issuer.Policy{
+		Validity:    cfg.ValidityPeriod(),
+		RenewBefore: cfg.RenewalPeriod(),
+		AutoApprove: cfg.Approval == config.ApprovalAuto,
+		Agents:      cfg.Agents,
+	}
+}
+
+// requestCertificate records the CSR an agent sent, if any. Certificates
+// issued for it are offered with the agent's connection settings. bound
+// reports whether the agent's ID is tied to how it authenticated, see
+// agentIDBound.
+func (s *Server) requestCertificate(agentID string, request *protobufs.ConnectionSettingsRequest, bound bool) {
+	csr := request.GetOpamp().GetCertificateRequest().GetCsr()
+	if len(csr) == 0 {
+		return
+	}
+	agent, exists := s.agentManager.GetAgent(agentID)
+	if !exists {
+		return
+	}
+	record, err := s.issuer.Request(agent, csr, bound)
+	if err != nil {
+		logger.Warn("Rejected certificate request", "agent_id", agentID, "operation", "certificates", "error", err)
+		return
+	}
+	logger.Info("Received certificate request", "agent_id", agentID, "operation", "certificates", "subject", record.Subject, "state", record.State)
+}
+
+// agentIDBound reports whether an agent's ID is tied to what it
+// authenticated with: a credential bound to the agent, a client certificate
+// matched against its instance UID, or approval through enrollment. Only
+// such agents have certificate requests approved automatically, since the
+// issued certificate names the agent ID.
+func (s *Server) agentIDBound(identity agentauth.Identity, clientCert *agentauth.ClientCert) bool {
+	if identity.AgentID != "" || s.enrollment.Enabled() {
+		return true
+	}
+	return clientCert != nil && s.getConfig().OpAMP.TLS.Identity.Match == config.IdentityMatchInstanceUID
+}
+
+// ListCertificates returns the certificate requests in the given state, or
+// all requests if state is empty.
+func (s *Server) ListCertificates(state string) []issuer.Record {
+	return s.issuer.List(state)
+}
+
+// ApproveCertificate signs the pending certificate request of an agent and
+// sends the certificate if the agent is connected.
+func (s *Server) ApproveCertificate(ctx context.Context, agentID string) (issuer.Record, error) {
+	record, err := s.issuer.Approve(agentID)
+	if err != nil {
+		return record, err
+	}
+	logger.InfoContext(ctx, "Certificate request approved", "agent_id", agentID, "operation", "certificates", "serial_number", record.SerialNumber)
+	if agent, exists := s.agentManager.GetAgent(agentID); exists {
+		if err := s.sendConnectionSettings(ctx, agent); err != nil {
+			logger.WarnContext(ctx, "Failed to send certificate", "agent_id", agentID, "operation", "certificates", "error", err)
+		}
+	}
+	return record, nil
+}
+
+// RejectCertificate rejects the pending certificate request of an agent.
+func (s *Server) RejectCertificate(ctx context.Context, agentID string) (issuer.Record, error) {
+	record, err := s.issuer.Reject(agentID)
+	if err != nil {
+		return record, err
+	}
+	logger.InfoContext(ctx, "Certificate request rejected", "agent_id", agentID, "operation", "certificates")
+	return record, nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/api"
+	"opamp-backend/internal/connsettings"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+var _ api.ConnectionSettingsServer = (*Server)(nil)
+
+// pendingConnectionSettings returns the connection settings to offer an
+// agent, including the client certificate issued to it, or nil if there are
+// none or the agent was already offered them.
+func (s *Server) pendingConnectionSettings(agent *agents.Agent) *protobufs.ConnectionSettingsOffers {
+	if agent.Pending {
+		return nil
+	}
+	offers := s.connSettings.For(agent)
+	if agent.HasCapability(protobufs.AgentCapabilities_AgentCapabilities_AcceptsOpAMPConnectionSettings) {
+		certificate, err := s.issuer.Certificate(agent.ID)
+		if err != nil {
+			logger.Error("Failed to renew certificate", "agent_id", agent.ID, "operation", "certificates", "error", err)
+		}
+		if certificate != nil {
+			offers = connsettings.WithCertificate(offers, s.getConfig().Certificates.Endpoint, certificate)
+		}
+	}
+	if offers == nil || !s.agentManager.NeedsConnectionSettings(agent.ID, offers.Hash) {
+		return nil
+	}
+	return offers
+}
+
+// offerConnectionSettings adds the connection settings an agent was not yet
+// offered to response.
+func (s *Server) offerConnectionSettings(agentID string, response *protobufs.ServerToAgent) {
+	agent, exists := s.agentManager.GetAgent(agentID)
+	if !exists {
+		return
+	}
+	if offers := s.pendingConnectionSettings(agent); offers != nil {
+		response.ConnectionSettings = offers
+		s.agentManager.SetConnectionOffer(agentID, offers.Hash, connsettings.HasOwnTelemetry(offers))
+		logger.Info("Offering connection settings", "agent_id", agentID, "operation", "connection_settings")
+	}
+}
+
+// pushConnectionSettings sends changed connection settings to the connected
+// agents, for example after a reload.
+func (s *Server) pushConnectionSettings(ctx context.Context) {
+	for _, agent := range s.agentManager.GetAllAgents() {
+		if err := s.sendConnectionSettings(ctx, agent); err != nil {
+			logger.WarnContext(ctx, "Failed to send connection settings", "agent_id", agent.ID, "operation", "connection_settings", "error", err)
+		}
+	}
+}
+
+// sendConnectionSettings sends an agent the connection settings it was not
+// yet offered, if any.
+func (s *Server) sendConnectionSettings(ctx context.Context, agent *agents.Agent) error {
+	offers := s.pendingConnectionSettings(agent)
+	if offers == nil {
+		return nil
+	}
+	message := &protobufs.ServerToAgent{
+		InstanceUid:        []byte(agent.ID),
+		ConnectionSettings: offers,
+	}
+	if err := s.sendToAgent(ctx, agent, message); err != nil {
+		return err
+	}
+	s.agentManager.SetConnectionOffer(agent.ID, offers.Hash, connsettings.HasOwnTelemetry(offers))
+	logger.InfoContext(ctx, "Sent connection settings", "agent_id", agent.ID, "operation", "connection_settings")
+	return nil
+}
+
+// ListConnectionOffers returns the connection settings last offered to each
+// agent.
+func (s *Server) ListConnectionOffers() []agents.ConnectionOffer {
+	return s.agentManager.ConnectionOffers()
+}
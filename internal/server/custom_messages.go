@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/api"
+	"opamp-backend/internal/audit"
+	"slices"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+var _ api.CustomMessageServer = (*Server)(nil)
+
+// CustomMessageHandler handles the custom messages an agent sends for one
+// capability. A non-nil reply is sent back to the agent in the response.
+type CustomMessageHandler func(ctx context.Context, agent *agents.Agent, message *protobufs.CustomMessage) *protobufs.CustomMessage
+
+// RegisterCustomMessageHandler handles the custom messages of capability in
+// Go. Handlers must be registered before Start so that their capabilities
+// are advertised to agents.
+func (s *Server) RegisterCustomMessageHandler(capability string, handler CustomMessageHandler) {
+	s.customMu.Lock()
+	defer s.customMu.Unlock()
+	s.customHandlers[capability] = handler
+}
+
+// customCapabilities returns the custom capabilities advertised to agents:
+// those configured and those with a registered handler. The OpAMP server
+// sends them in the first response on each WebSocket connection and in every
+// HTTP response.
+func (s *Server) customCapabilities() []string {
+	capabilities := slices.Clone(s.getConfig().CustomCapabilities)
+	s.customMu.RLock()
+	for capability := range s.customHandlers {
+		if !slices.Contains(capabilities, capability) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	s.customMu.RUnlock()
+	slices.Sort(capabilities)
+	return capabilities
+}
+
+// receiveCustomMessage records a custom message sent by an agent and passes
+// it to the handler registered for its capability, adding any reply to
+// response. Messages from agents pending enrollment are only recorded.
+func (s *Server) receiveCustomMessage(ctx context.Context, agentID string, message *protobufs.CustomMessage, response *protobufs.ServerToAgent) {
+	if message == nil {
+		return
+	}
+	if err := s.agentManager.AddCustomMessage(agentID, message); err != nil {
+		return
+	}
+	logger.Debug("Received custom message", "agent_id", agentID, "capability", message.Capability, "type", message.Type, "bytes", len(message.Data))
+
+	s.customMu.RLock()
+	handler, exists := s.customHandlers[message.Capability]
+	s.customMu.RUnlock()
+	agent, _ := s.agentManager.GetAgent(agentID)
+	if !exists || agent == nil || agent.Pending {
+		return
+	}
+	if reply := handler(ctx, agent, message); reply != nil {
+		response.CustomMessage = reply
+	}
+}
+
+// SendCustomMessage sends a custom message to an agent that declared its
+// capability. The message is recorded as a target of the audited request in
+// ctx, if any.
+func (s *Server) SendCustomMessage(ctx context.Context, agentID string, message *protobufs.CustomMessage) (err error) {
+	defer func() {
+		audit.AddTarget(ctx, agentID, "", message.Capability+"/"+message.Type, err)
+	}()
+
+	agent, exists := s.agentManager.GetAgent(agentID)
+	if !exists {
+		return fmt.Errorf("agent %s not found", agentID)
+	}
+	if err := checkManaged(agent); err != nil {
+		return err
+	}
+	if err := s.sendToAgent(ctx, agent, &protobufs.ServerToAgent{
+		InstanceUid:   []byte(agentID),
+		CustomMessage: message,
+	}); err != nil {
+		logger.WarnContext(ctx, "Failed to send custom message", "agent_id", agentID, "capability", message.Capability, "error", err)
+		return err
+	}
+	logger.InfoContext(ctx, "Custom message sent", "agent_id", agentID, "capability", message.Capability, "type", message.Type)
+	return nil
+}
+
+// CustomMessages returns the custom messages last received from an agent.
+func (s *Server) CustomMessages(agentID string) ([]agents.CustomMessage, error) {
+	return s.agentManager.CustomMessages(agentID)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"opamp-backend/internal/agentauth"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/api"
+	"opamp-backend/internal/common"
+	"opamp-backend/internal/enrollment"
+
+	opampTypes "github.com/open-telemetry/opamp-go/server/types"
+)
+
+var _ api.EnrollmentServer = (*Server)(nil)
+
+// ReasonEnrollmentRejected is recorded when a rejected agent connects.
+const ReasonEnrollmentRejected = "enrollment_rejected"
+
+var errEnrollmentRejected = errors.New("agent enrollment was rejected")
+
+// ListEnrollments returns the enrollment records in the given state, or all
+// records if state is empty.
+func (s *Server) ListEnrollments(state string) []common.EnrollmentRecord {
+	return s.enrollment.List(state)
+}
+
+// ApproveAgent approves a pending agent so that it is managed from its next
+// message on.
+func (s *Server) ApproveAgent(agentID string) (common.EnrollmentRecord, error) {
+	record, err := s.enrollment.Approve(agentID)
+	if err != nil {
+		return record, err
+	}
+	s.agentManager.SetAgentPending(agentID, false)
+	logger.Info("Agent enrollment approved", "agent_id", agentID, "operation", "enrollment")
+	return record, nil
+}
+
+// RejectAgent rejects an agent and disconnects it if it is connected.
+func (s *Server) RejectAgent(agentID string) (common.EnrollmentRecord, error) {
+	record, err := s.enrollment.Reject(agentID)
+	if err != nil {
+		return record, err
+	}
+	if agent, exists := s.agentManager.GetAgent(agentID); exists {
+		s.agentManager.DeregisterAgent(agentID)
+		if conn, ok := agent.Conn.(opampTypes.Connection); ok && conn != nil && agent.Transport == agents.TransportWebSocket {
+			conn.Disconnect()
+		}
+	}
+	logger.Info("Agent enrollment rejected", "agent_id", agentID, "operation", "enrollment")
+	return record, nil
+}
+
+// checkEnrollment evaluates the enrollment state of an identified agent and
+// records whether it is pending. It returns errEnrollmentRejected for agents
+// that must be disconnected.
+func (s *Server) checkEnrollment(agentID string, clientCert *agentauth.ClientCert) error {
+	candidate := enrollment.Candidate{AgentID: agentID, ClientCertNames: clientCert.Names()}
+	if agent, exists := s.agentManager.GetAgent(agentID); exists {
+		candidate.Labels = agent.Labels
+	}
+	if clientCert != nil {
+		candidate.ClientCertSubject = clientCert.Subject
+	}
+
+	state := s.enrollment.Evaluate(candidate)
+	switch state {
+	case enrollment.StateRejected:
+		return errEnrollmentRejected
+	case enrollment.StatePending:
+		s.agentManager.SetAgentPending(agentID, true)
+	default:
+		s.agentManager.SetAgentPending(agentID, false)
+	}
+	return nil
+}
+
+// checkManaged returns an error if an agent may not receive configuration
+// because it is awaiting enrollment approval.
+func checkManaged(agent *agents.Agent) error {
+	if agent.Pending {
+		return fmt.Errorf("agent %s is pending enrollment approval", agent.ID)
+	}
+	return nil
+}
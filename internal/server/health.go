@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"opamp-backend/internal/api"
+	"opamp-backend/internal/common"
+	"sort"
+)
+
+var _ api.ReadinessServer = (*Server)(nil)
+
+// RegisterReadinessCheck adds a named probe that must succeed for the server
+// to report ready. Subsystems that depend on external state, such as a storage
+// backend, register a check here so that /readyz reflects their reachability.
+func (s *Server) RegisterReadinessCheck(name string, check func() error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.readinessChecks[name] = check
+}
+
+// CheckReadiness evaluates all readiness probes and returns their results.
+func (s *Server) CheckReadiness() []common.ReadinessCheck {
+	cfg := s.getConfig()
+
+	s.stateMu.RLock()
+	listening := s.opampListening
+	tlsLoaded := s.tlsLoaded
+	restarting := s.restarting
+	names := make([]string, 0, len(s.readinessChecks))
+	for name := range s.readinessChecks {
+		names = append(names, name)
+	}
+	checks := make(map[string]func() error, len(s.readinessChecks))
+	for name, check := range s.readinessChecks {
+		checks[name] = check
+	}
+	s.stateMu.RUnlock()
+
+	results := []common.ReadinessCheck{
+		{Name: "opamp_listener", Ready: listening},
+		{Name: "opamp_restart", Ready: !restarting},
+	}
+	if !listening {
+		results[0].Message = fmt.Sprintf("OpAMP listener is not bound on %s", cfg.OpAMP.ListenAddress)
+	}
+	if restarting {
+		results[1].Message = "OpAMP server is restarting"
+	}
+
+	if s.stopping.Load() {
+		results = append(results, common.ReadinessCheck{Name: "shutdown", Ready: false, Message: "Server is shutting down"})
+	}
+
+	// TLS is only checked when the listener is configured to use it.
+	if cfg.TLSEnabled() {
+		tlsCheck := common.ReadinessCheck{Name: "opamp_tls", Ready: tlsLoaded}
+		if !tlsLoaded {
+			tlsCheck.Message = "TLS certificates are not loaded"
+		}
+		results = append(results, tlsCheck)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		check := common.ReadinessCheck{Name: name, Ready: true}
+		if err := checks[name](); err != nil {
+			check.Ready = false
+			check.Message = err.Error()
+		}
+		results = append(results, check)
+	}
+
+	return results
+}
+
+// APIAddr returns the address the API listener is bound to, or nil before
+// Start has opened it.
+func (s *Server) APIAddr() net.Addr {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+	return s.apiAddr
+}
+
+// OpAMPAddr returns the address agents connect to, or nil if the OpAMP
+// server is not listening. With opamp.attach it is the API address.
+func (s *Server) OpAMPAddr() net.Addr {
+	if s.getConfig().OpAMP.Attach {
+		return s.APIAddr()
+	}
+	s.restartOpampMu.Lock()
+	defer s.restartOpampMu.Unlock()
+	return s.opampServer.Addr()
+}
+
+func (s *Server) setOpampListening(listening bool) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.opampListening = listening
+}
+
+func (s *Server) setTLSLoaded(loaded bool) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.tlsLoaded = loaded
+}
+
+func (s *Server) setRestarting(restarting bool) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.restarting = restarting
+}
@@ -7,18 +7,29 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
+	"opamp-backend/internal/agentauth"
 	"opamp-backend/internal/agents"
 	"opamp-backend/internal/api"
+	"opamp-backend/internal/apitokens"
+	"opamp-backend/internal/audit"
 	"opamp-backend/internal/common"
 	"opamp-backend/internal/config"
+	"opamp-backend/internal/connsettings"
+	"opamp-backend/internal/enrollment"
+	"opamp-backend/internal/issuer"
+	"opamp-backend/internal/logging"
+	"opamp-backend/internal/metrics"
 	"opamp-backend/internal/middleware"
+	"opamp-backend/internal/packages"
+	"os"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/open-telemetry/opamp-go/protobufs"
@@ -27,27 +38,29 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
-// SimpleLogger implements the Logger interface required by opamp-go.
-type SimpleLogger struct{}
+var logger = logging.For(logging.SubsystemOpAMP)
 
-func (l *SimpleLogger) Debug(msg string, args ...interface{}) {
-	log.Printf("DEBUG: "+msg, args...)
-}
-
-func (l *SimpleLogger) Debugf(ctx context.Context, format string, args ...interface{}) {
-	log.Printf("DEBUG: "+format, args...)
-}
-
-func (l *SimpleLogger) Info(msg string, args ...interface{}) {
-	log.Printf("INFO: "+msg, args...)
-}
+var fullStateRequests = metrics.NewCounter(
+	"opamp_full_state_requests_total",
+	"Responses asking an agent to report its full state.",
+	"reason",
+)
 
-func (l *SimpleLogger) Error(msg string, args ...interface{}) {
-	log.Printf("ERROR: "+msg, args...)
+// configHashString returns the hex-encoded SHA256 of a configuration, used to
+// identify configs in log records without printing their content.
+func configHashString(cfg string) string {
+	hash := sha256.Sum256([]byte(cfg))
+	return fmt.Sprintf("%x", hash[:])
 }
 
-func (l *SimpleLogger) Errorf(ctx context.Context, format string, args ...interface{}) {
-	log.Printf("ERROR: "+format, args...)
+// currentConfigHash returns the hash of the configuration an agent is
+// currently believed to run, for audit records.
+func currentConfigHash(agentID string) string {
+	current, err := config.GetCurrentCollectorConfig(agentID)
+	if err != nil {
+		return ""
+	}
+	return configHashString(current)
 }
 
 // Helper function to get keys from a map
@@ -62,11 +75,46 @@ func getMapKeys(m map[string]*protobufs.AgentConfigFile) []string {
 // Server wraps both the OpAMP server and the HTTP API server.
 type Server struct {
 	opampServer    server.OpAMPServer
-	config         config.Config
-	httpServer     *http.Server
+	configPath     string
 	agentManager   *agents.Manager
 	restartOpampMu sync.Mutex
-	stopping       bool
+	stopping       atomic.Bool
+
+	// Running configuration and TLS certificate, guarded by configMu and
+	// replaced by ReloadConfig.
+	configMu     sync.RWMutex
+	config       config.Config
+	certStore    *config.CertificateStore
+	agentAuth    *agentauth.Authenticator
+	enrollment   *enrollment.Registry
+	auditLog     *audit.Log
+	tokenStore   *apitokens.Store
+	packages     *packages.Registry
+	connSettings *connsettings.Offers
+	issuer       *issuer.Issuer
+	rateLimiter  *middleware.RateLimiter
+	fanOuts      *middleware.ConcurrencyLimit
+	watchCancel  context.CancelFunc
+	sweepCancel  context.CancelFunc
+
+	// In-flight OnMessage handlers, drained on shutdown.
+	inflightMu sync.Mutex
+	inflight   sync.WaitGroup
+	draining   bool
+
+	// Handlers of custom capabilities, guarded by customMu.
+	customMu       sync.RWMutex
+	customHandlers map[string]CustomMessageHandler
+
+	// Readiness state, guarded by stateMu.
+	stateMu         sync.RWMutex
+	opampListening  bool
+	apiAddr         net.Addr
+	httpServer      *http.Server
+	tlsLoaded       bool
+	restarting      bool
+	readinessChecks map[string]func() error
+	shutdownHooks   []namedHook
 }
 
 // NewServer initializes a new Server instance using the configuration file.
@@ -75,22 +123,82 @@ func NewServer(configPath string) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if err := logging.Configure(cfg.Logging.Level, cfg.Logging.Format, cfg.Logging.Subsystems); err != nil {
+		return nil, err
+	}
+
+	if err := configureAPIAuth(cfg); err != nil {
+		return nil, err
+	}
 
-	logger := &SimpleLogger{}
-	opampSrv := server.New(logger)
+	opampSrv := server.New(logging.NewOpampLogger(logger))
+
+	s := &Server{
+		opampServer:     opampSrv,
+		configPath:      configPath,
+		config:          cfg,
+		agentAuth:       agentauth.New(cfg.OpAMP.Auth),
+		agentManager:    agents.NewManager(),
+		rateLimiter:     middleware.NewRateLimiter(cfg.API.Limits),
+		fanOuts:         middleware.NewConcurrencyLimit(cfg.API.Limits.FanOuts()),
+		customHandlers:  make(map[string]CustomMessageHandler),
+		readinessChecks: make(map[string]func() error),
+	}
 
-	return &Server{
-		opampServer:  opampSrv,
-		config:       cfg,
-		agentManager: agents.NewManager(),
-	}, nil
+	if s.enrollment, err = enrollment.New(cfg.OpAMP.Enrollment); err != nil {
+		return nil, fmt.Errorf("failed to open enrollment store: %w", err)
+	}
+
+	if cfg.API.TokenStore != "" {
+		if s.tokenStore, err = apitokens.Open(cfg.API.TokenStore); err != nil {
+			return nil, fmt.Errorf("failed to open api token store: %w", err)
+		}
+	}
+	middleware.SetTokenStore(s.tokenStore)
+
+	if s.connSettings, err = connsettings.New(cfg.ConnectionSettings); err != nil {
+		return nil, err
+	}
+
+	if s.issuer, err = issuer.Open(cfg.Certificates.Store); err != nil {
+		return nil, fmt.Errorf("failed to open certificate store: %w", err)
+	}
+	if err := s.issuer.Configure(cfg.Certificates.CACertFile, cfg.Certificates.CAKeyFile, certificatePolicy(cfg.Certificates)); err != nil {
+		return nil, err
+	}
+
+	if cfg.Packages.Enabled() {
+		if s.packages, err = packages.Open(cfg.Packages.Dir); err != nil {
+			return nil, fmt.Errorf("failed to open package registry: %w", err)
+		}
+	}
+
+	if cfg.Audit.File != "" {
+		if s.auditLog, err = audit.Open(cfg.Audit.File); err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		s.RegisterReadinessCheck("audit_log", s.auditLog.Check)
+		s.RegisterShutdownHook("audit_log", func(ctx context.Context) error {
+			return s.auditLog.Close()
+		})
+	}
+
+	return s, nil
 }
 
-// GetAgentIDs returns just the IDs of all registered agents
+// GetAgentIDs returns just the IDs of all managed agents, leaving out those
+// pending enrollment approval.
 func (s *Server) GetAgentIDs() []string {
 	agents := s.agentManager.GetAllAgents()
 	ids := make([]string, 0, len(agents))
 	for _, agent := range agents {
+		if agent.Pending {
+			continue
+		}
 		ids = append(ids, agent.ID)
 	}
 	return ids
@@ -107,93 +215,75 @@ func (s *Server) RequestAgentConfig(agentID string) error {
 	if !exists {
 		return fmt.Errorf("agent %s not found", agentID)
 	}
-
-	conn, ok := agent.Conn.(opampTypes.Connection)
-	if !ok {
-		return fmt.Errorf("agent connection is not valid")
+	if err := checkManaged(agent); err != nil {
+		return err
 	}
 
-	// Create a message to request the agent's configuration
+	// Ask the agent to report its full state, including its effective configuration
 	message := &protobufs.ServerToAgent{
-		InstanceUid:  []byte(agentID),
-		Capabilities: uint64(protobufs.ServerCapabilities_ServerCapabilities_AcceptsEffectiveConfig),
+		InstanceUid: []byte(agentID),
+		Flags:       uint64(protobufs.ServerToAgentFlags_ServerToAgentFlags_ReportFullState),
 	}
 
 	ctx := context.Background()
-	if err := conn.Send(ctx, message); err != nil {
-		log.Printf("Failed to send configuration request: %v", err)
+	if err := s.sendToAgent(ctx, agent, message); err != nil {
+		logger.Error("Failed to send configuration request", "agent_id", agentID, "operation", "request_config", "error", err)
 		return err
 	}
 
-	log.Printf("Configuration request sent to agent %s", agentID)
+	logger.Info("Configuration request sent", "agent_id", agentID, "operation", "request_config")
 	return nil
 }
 
-// UpdateAgentLogLevel updates the log level for a specific agent
-func (s *Server) UpdateAgentLogLevel(agentID string, logLevel string) error {
-	log.Printf("UpdateAgentLogLevel called for agent %s with level %s", agentID, logLevel)
+// UpdateAgentLogLevel updates the log level for a specific agent. The push is
+// recorded as a target of the audited request in ctx, if any.
+func (s *Server) UpdateAgentLogLevel(ctx context.Context, agentID string, logLevel string) (err error) {
+	log := logger.With("agent_id", agentID, "operation", "update_log_level")
+	log.DebugContext(ctx, "Updating agent log level", "log_level", logLevel)
+
+	var beforeHash, afterHash string
+	defer func() {
+		audit.AddTarget(ctx, agentID, beforeHash, afterHash, err)
+	}()
 
 	agent, exists := s.agentManager.GetAgent(agentID)
 	if !exists {
-		log.Printf("Agent %s not found in manager", agentID)
+		log.Warn("Agent not found in manager")
 		return fmt.Errorf("agent %s not found", agentID)
 	}
+	if err := checkManaged(agent); err != nil {
+		log.Warn("Agent is pending enrollment approval")
+		return err
+	}
+	if err := agent.RequireCapability(protobufs.AgentCapabilities_AgentCapabilities_AcceptsRemoteConfig); err != nil {
+		log.Warn("Agent does not accept remote configuration")
+		return err
+	}
 
 	if agent.Conn == nil {
-		log.Printf("Agent %s has nil connection", agentID)
+		log.Warn("Agent has nil connection")
 		return fmt.Errorf("agent %s connection is nil", agentID)
 	}
 
-	log.Printf("Retrieving current collector config for agent %s", agentID)
 	currentConfig, err := config.GetCurrentCollectorConfig(agentID)
 	if err != nil {
-		log.Printf("Failed to get current collector config: %v", err)
+		log.Error("Failed to get current collector config", "error", err)
 		return err
 	}
+	beforeHash = configHashString(currentConfig)
+	log.Debug("Retrieved current collector config", "config_hash", beforeHash, "config_bytes", len(currentConfig))
 
-	// Print a preview of the current config
-	configLines := strings.Split(currentConfig, "\n")
-	previewLines := 10
-	if len(configLines) < previewLines {
-		previewLines = len(configLines)
-	}
-	configPreview := strings.Join(configLines[:previewLines], "\n")
-	log.Printf("Current config preview for agent %s: \n%s...", agentID, configPreview)
-
-	log.Printf("Updating config with log level: %s", logLevel)
 	updatedConfig, err := config.UpdateLogLevelInConfig(currentConfig, logLevel)
 	if err != nil {
-		log.Printf("Failed to update log level in config: %v", err)
+		log.Error("Failed to update log level in config", "error", err)
 		return err
 	}
-
-	// Print a preview of the updated config
-	updatedLines := strings.Split(updatedConfig, "\n")
-	previewLines = 10
-	if len(updatedLines) < previewLines {
-		previewLines = len(updatedLines)
-	}
-	updatedPreview := strings.Join(updatedLines[:previewLines], "\n")
-	log.Printf("Updated config preview for agent %s: \n%s...", agentID, updatedPreview)
-
-	// Update the agent's stored configuration.
-	log.Printf("Updating stored configuration for agent %s", agentID)
-	s.agentManager.UpdateAgentConfig(agentID, updatedConfig)
-
-	// Assert that the stored connection implements opampTypes.Connection.
-	conn, ok := agent.Conn.(opampTypes.Connection)
-	if !ok {
-		log.Printf("Agent connection not valid (type assertion failed)")
-		return fmt.Errorf("agent connection is not valid")
-	}
-
-	// Create a context and a ServerToAgent message.
-	ctx := context.Background()
+	afterHash = configHashString(updatedConfig)
+	log.Debug("Built updated collector config", "config_hash", afterHash, "config_bytes", len(updatedConfig))
 
 	// Calculate hash of the config for tracking changes
 	configHash := sha256.Sum256([]byte(updatedConfig))
 
-	log.Printf("Creating ServerToAgent message with updated config for agent %s", agentID)
 	// Construct the ServerToAgent message with the config update
 	message := &protobufs.ServerToAgent{
 		InstanceUid: []byte(agentID), // Use the agent's ID as the instance UID
@@ -208,18 +298,24 @@ func (s *Server) UpdateAgentLogLevel(agentID string, logLevel string) error {
 			},
 			ConfigHash: configHash[:], // Use the hash we calculated
 		},
-		// Set the appropriate capability flag
-		Capabilities: uint64(protobufs.ServerCapabilities_ServerCapabilities_OffersRemoteConfig),
 	}
 
-	// Send the message.
-	log.Printf("Sending configuration update to agent %s", agentID)
-	if err := conn.Send(ctx, message); err != nil {
-		log.Printf("Failed to send configuration update: %v", err)
-		return fmt.Errorf("failed to send configuration update: %v", err)
+	// Configurations the agent's binary cannot run are not stored.
+	if err := agent.CheckComponents(message.RemoteConfig); err != nil {
+		log.Warn("Configuration uses components the agent does not include", "error", err)
+		return err
+	}
+
+	// Update the agent's stored configuration.
+	s.agentManager.UpdateAgentConfig(agentID, updatedConfig)
+
+	// Send the message, or queue it for agents polling over plain HTTP.
+	if err := s.sendToAgent(ctx, agent, message); err != nil {
+		log.Error("Failed to send configuration update", "error", err)
+		return fmt.Errorf("failed to send configuration update: %w", err)
 	}
 
-	log.Printf("Configuration update successfully sent to agent %s", agentID)
+	log.Info("Configuration update sent", "log_level", logLevel, "config_hash", fmt.Sprintf("%x", configHash[:]), "transport", agent.Transport)
 	return nil
 }
 
@@ -233,242 +329,430 @@ func (s *Server) RestartOpampServer() {
 	s.restartOpampMu.Lock()
 	defer s.restartOpampMu.Unlock()
 
-	if s.stopping {
+	if s.stopping.Load() {
 		return
 	}
 
-	log.Println("Restarting OpAMP server after crash...")
+	logger.Warn("Restarting OpAMP server after crash", "operation", "restart_opamp")
+	s.setRestarting(true)
 
 	// Small delay to allow resources to be freed
 	time.Sleep(2 * time.Second)
 
 	// Create a new OpAMP server instance
-	s.opampServer = server.New(&SimpleLogger{})
+	s.opampServer = server.New(logging.NewOpampLogger(logger))
 
 	// Start it again
 	go s.startOpampServer()
 }
 
+// loadCertStore returns the certificate store for the OpAMP listener, loading
+// it on first use. The store is shared across OpAMP server restarts so that
+// reloaded certificates survive them.
+func (s *Server) loadCertStore(cfg config.Config) (*config.CertificateStore, error) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	if s.certStore == nil {
+		store, err := config.NewCertificateStore(cfg.OpAMP.TLS.CertFile, cfg.OpAMP.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.LoadClientCAs(cfg.OpAMP.TLS.ClientCAFile, cfg.OpAMP.TLS.ClientAuth); err != nil {
+			return nil, err
+		}
+		s.certStore = store
+	}
+	return s.certStore, nil
+}
+
 // startOpampServer starts the OpAMP server in a safe way
 func (s *Server) startOpampServer() {
 	// Wrap the entire function in a recovery handler
 	defer func() {
 		if r := recover(); r != nil {
 			stack := debug.Stack()
-			log.Printf("PANIC RECOVERED from OpAMP server: %v\n%s", r, stack)
+			logger.Error("Panic recovered from OpAMP server", "panic", r, "stack", string(stack))
+			s.setOpampListening(false)
 
 			// Restart the server if it wasn't intentionally stopped
-			if !s.stopping {
+			if !s.stopping.Load() {
 				go s.RestartOpampServer()
 			}
 		}
 	}()
 
+	cfg := s.getConfig()
+
 	var tlsConfig *tls.Config
-	if s.config.OpAMP.TLS.CertFile != "" && s.config.OpAMP.TLS.KeyFile != "" {
-		var err error
-		tlsConfig, err = s.config.TLSConfig()
+	if cfg.TLSEnabled() {
+		certStore, err := s.loadCertStore(cfg)
 		if err != nil {
-			log.Printf("Error in TLS configuration: %v", err)
+			logger.Error("Error in TLS configuration", "error", err)
+			s.setTLSLoaded(false)
+			s.setRestarting(false)
 			return
 		}
+		tlsConfig = certStore.TLSConfig()
+		s.setTLSLoaded(true)
 	}
 
 	startSettings := server.StartSettings{
-		ListenEndpoint: s.config.OpAMP.ListenAddress,
-		ListenPath:     "/v1/opamp",
+		ListenEndpoint: cfg.OpAMP.ListenAddress,
+		ListenPath:     opampPath,
 		TLSConfig:      tlsConfig,
-		Settings: server.Settings{
-			Callbacks: opampTypes.Callbacks{
-				// Update the OnConnecting callback in the Start method
-				OnConnecting: func(request *http.Request) opampTypes.ConnectionResponse {
-					// Wrap this entire callback in panic recovery
-					var result opampTypes.ConnectionResponse
-
-					func() {
-						defer func() {
-							if r := recover(); r != nil {
-								stack := debug.Stack()
-								log.Printf("PANIC RECOVERED in OnConnecting: %v\n%s", r, stack)
-								// Default to rejecting the connection if we panic
-								result = opampTypes.ConnectionResponse{Accept: false}
+		Settings:       s.opampSettings(),
+	}
+
+	// Start the OpAMP server. Holding restartOpampMu orders the start
+	// against Shutdown, which either runs first and keeps the listener from
+	// binding or waits for it to bind and then stops it.
+	s.restartOpampMu.Lock()
+	defer s.restartOpampMu.Unlock()
+	if s.stopping.Load() {
+		s.setRestarting(false)
+		return
+	}
+	err := s.opampServer.Start(startSettings)
+	s.setOpampListening(err == nil && s.opampServer.Addr() != nil)
+	s.setRestarting(false)
+	if err != nil {
+		logger.Error("OpAMP server failed", "listen_address", cfg.OpAMP.ListenAddress, "error", err)
+	}
+}
+
+// opampSettings returns the OpAMP callbacks and the custom capabilities
+// advertised to agents, shared by the dedicated OpAMP listener and by attach
+// mode.
+func (s *Server) opampSettings() server.Settings {
+	return server.Settings{
+		CustomCapabilities: s.customCapabilities(),
+		Callbacks: opampTypes.Callbacks{
+			// Update the OnConnecting callback in the Start method
+			OnConnecting: func(request *http.Request) opampTypes.ConnectionResponse {
+				// Wrap this entire callback in panic recovery
+				var result opampTypes.ConnectionResponse
+
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							stack := debug.Stack()
+							logger.Error("Panic recovered in OnConnecting", "panic", r, "stack", string(stack))
+							// Default to rejecting the connection if we panic
+							result = opampTypes.ConnectionResponse{Accept: false}
+						}
+					}()
+
+					logger.Info("Agent connecting", "remote_addr", request.RemoteAddr)
+
+					identity, err := s.agentAuth.Authenticate(request)
+					if err != nil {
+						result = rejectConnection(request, err)
+						return
+					}
+					clientCert := agentauth.ClientCertFromRequest(request)
+					// The agent ID the client certificate was last verified for
+					verifiedAgentID := ""
+
+					// Extract the agent ID
+					// Instead of using X-Agent-ID header, we'll use the instance_uid from
+					// the first message. For now, use remoteAddr as temporary ID
+					agentID := request.RemoteAddr
+					transport := agents.TransportWebSocket
+					if request.Header.Get("Content-Type") == "application/x-protobuf" {
+						transport = agents.TransportHTTP
+					}
+
+					// Create connection callbacks
+					callbacks := opampTypes.ConnectionCallbacks{
+						OnConnected: func(ctx context.Context, conn opampTypes.Connection) {
+							// Wrap this callback in panic recovery
+							defer func() {
+								if r := recover(); r != nil {
+									logger.Error("Panic recovered in OnConnected", "agent_id", agentID, "panic", r)
+								}
+							}()
+
+							if conn == nil {
+								logger.Warn("OnConnected called with nil connection object", "agent_id", agentID)
+								return
+							}
+
+							// Plain HTTP agents are registered, or found again, once
+							// their message identifies them
+							if transport == agents.TransportHTTP {
+								return
 							}
-						}()
 
-						log.Printf("Agent connecting from: %s", request.RemoteAddr)
+							// Register the agent with the temporary ID; it is pending
+							// until it identifies itself if enrollment is enabled
+							agent := newAgent(agentID, conn, transport, identity, clientCert)
+							agent.Pending = s.enrollment.Enabled()
+							s.agentManager.RegisterAgent(agent)
+							logger.Info("Agent connected", "agent_id", agentID)
+						},
+						// Replace the OnMessage callback in the server.Start() method with this enhanced version:
 
-						// Extract the agent ID
-						// Instead of using X-Agent-ID header, we'll use the instance_uid from
-						// the first message. For now, use remoteAddr as temporary ID
-						agentID := request.RemoteAddr
+						OnMessage: func(ctx context.Context, conn opampTypes.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+							// Wrap this callback in panic recovery and ensure we always return something
+							var response *protobufs.ServerToAgent = &protobufs.ServerToAgent{
+								Capabilities: serverCapabilities(s.getConfig()),
+							}
 
-						// Create connection callbacks
-						callbacks := opampTypes.ConnectionCallbacks{
-							OnConnected: func(ctx context.Context, conn opampTypes.Connection) {
-								// Wrap this callback in panic recovery
+							// Do not start processing new messages once shutdown has begun
+							if !s.beginMessage() {
+								return response
+							}
+							defer s.inflight.Done()
+
+							func() {
 								defer func() {
 									if r := recover(); r != nil {
-										log.Printf("PANIC RECOVERED in OnConnected: %v", r)
+										logger.Error("Panic recovered in OnMessage", "agent_id", agentID, "panic", r)
 									}
 								}()
 
 								if conn == nil {
-									log.Printf("OnConnected called with nil connection object for agent %s", agentID)
+									logger.Warn("OnMessage called with nil connection object", "agent_id", agentID)
 									return
 								}
 
-								// Register the agent with the temporary ID
-								s.agentManager.RegisterAgent(&agents.Agent{
-									ID:   agentID,
-									Conn: conn,
-								})
-								log.Printf("Agent connected: %s", agentID)
-							},
-							// Replace the OnMessage callback in the server.Start() method with this enhanced version:
-
-							OnMessage: func(ctx context.Context, conn opampTypes.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
-								// Wrap this callback in panic recovery and ensure we always return something
-								var response *protobufs.ServerToAgent = &protobufs.ServerToAgent{}
+								// Message can be nil in some cases
+								if message == nil {
+									return
+								}
 
-								func() {
-									defer func() {
-										if r := recover(); r != nil {
-											log.Printf("PANIC RECOVERED in OnMessage: %v", r)
-										}
-									}()
+								// Check if this is the first status message and contains instance_uid
+								if len(message.InstanceUid) > 0 {
+									// Convert bytes to hex representation for safer handling
+									instanceID := fmt.Sprintf("%x", message.InstanceUid)
 
-									if conn == nil {
-										log.Printf("OnMessage called with nil connection object for agent %s", agentID)
+									if err := identity.CheckAgentID(instanceID); err != nil {
+										response.ErrorResponse = s.rejectAgent(conn, agentID, transport, err)
 										return
 									}
 
-									// Message can be nil in some cases
-									if message == nil {
-										return
+									if existing, known := s.agentManager.GetAgent(instanceID); known && transport == agents.TransportHTTP {
+										// A known agent polling again keeps its record and queue,
+										// provided it is the same plain HTTP agent
+										err := identity.CheckSession(instanceID, existing.AuthIdentity, existing.ClientCertSubject, clientCert)
+										if err == nil && existing.Transport != agents.TransportHTTP {
+											err = fmt.Errorf("agent %s is connected over %s", instanceID, existing.Transport)
+										}
+										if err != nil {
+											response.ErrorResponse = s.rejectAgent(conn, agentID, transport, err)
+											return
+										}
+										s.agentManager.DeregisterAgent(agentID)
+										agentID = instanceID
+									} else if instanceID != agentID && instanceID != "" {
+										// Found the real agent ID! Update our registry
+										logger.Info("Updating agent ID", "agent_id", instanceID, "previous_id", agentID)
+
+										// First deregister the temporary ID
+										s.agentManager.DeregisterAgent(agentID)
+
+										// Then register with the real ID
+										agent := newAgent(instanceID, conn, transport, identity, clientCert)
+										agent.Pending = s.enrollment.Enabled()
+										s.agentManager.RegisterAgent(agent)
+
+										// Update our local variable
+										agentID = instanceID
 									}
+								}
 
-									// Check if this is the first status message and contains instance_uid
-									if len(message.InstanceUid) > 0 {
-										// Convert bytes to hex representation for safer handling
-										instanceID := fmt.Sprintf("%x", message.InstanceUid)
+								s.agentManager.TouchAgent(agentID, conn)
 
-										if instanceID != agentID && instanceID != "" {
-											// Found the real agent ID! Update our registry
-											log.Printf("Updating agent ID from %s to %s", agentID, instanceID)
+								// Ask for the full state if we missed messages or do not
+								// know the agent, for example after a restart
+								if len(message.InstanceUid) > 0 {
+									if reason := s.agentManager.ObserveSequenceNum(agentID, message.SequenceNum); reason != "" {
+										fullStateRequests.Inc(reason)
+										logger.Info("Requesting full state from agent", "agent_id", agentID, "reason", reason, "sequence_num", message.SequenceNum)
+										response.Flags |= uint64(protobufs.ServerToAgentFlags_ServerToAgentFlags_ReportFullState)
+									}
+									s.agentManager.ObserveRestart(agentID, message.SequenceNum)
+								}
 
-											// First deregister the temporary ID
-											s.agentManager.DeregisterAgent(agentID)
+								if desc := message.GetAgentDescription(); desc != nil {
+									s.agentManager.UpdateAgentDescription(agentID, desc)
+								}
+								if health := message.GetHealth(); health != nil {
+									s.agentManager.UpdateAgentHealth(agentID, health)
+								}
+								if message.Capabilities != 0 {
+									s.agentManager.UpdateAgentCapabilities(agentID, message.Capabilities)
+								}
+								if custom := message.GetCustomCapabilities(); custom != nil {
+									s.agentManager.UpdateAgentCustomCapabilities(agentID, custom.Capabilities)
+								}
+								if components := message.GetAvailableComponents(); components != nil {
+									if needsFull, err := s.agentManager.UpdateAgentAvailableComponents(agentID, components); err == nil && needsFull {
+										response.Flags |= uint64(protobufs.ServerToAgentFlags_ServerToAgentFlags_ReportAvailableComponents)
+									}
+								}
+								if statuses := message.GetPackageStatuses(); statuses != nil {
+									s.agentManager.UpdateAgentPackageStatuses(agentID, statuses)
+									if statuses.ErrorMessage != "" {
+										logger.Warn("Agent reported a package error", "agent_id", agentID, "error", statuses.ErrorMessage)
+									}
+								}
 
-											// Then register with the real ID
-											s.agentManager.RegisterAgent(&agents.Agent{
-												ID:   instanceID,
-												Conn: conn,
-											})
+								// Verify the client certificate once the agent has identified
+								// itself, and again whenever the connection switches instance UID
+								if verifiedAgentID != agentID && len(message.InstanceUid) > 0 {
+									agent, _ := s.agentManager.GetAgent(agentID)
+									var labels map[string]string
+									if agent != nil {
+										labels = agent.Labels
+									}
+									rule := s.getConfig().OpAMP.TLS.Identity
+									if err := agentauth.VerifyClientCert(rule, clientCert, message.InstanceUid, labels); err != nil {
+										response.ErrorResponse = s.rejectAgent(conn, agentID, transport, err)
+										return
+									}
+									verifiedAgentID = agentID
+								}
 
-											// Update our local variable
-											agentID = instanceID
-										}
+								// Agents pending approval stay connected but receive no configuration
+								if len(message.InstanceUid) > 0 {
+									if err := s.checkEnrollment(agentID, clientCert); err != nil {
+										response.ErrorResponse = s.rejectAgent(conn, agentID, transport, err)
+										return
 									}
+									s.offerPackages(agentID, response)
+									s.requestCertificate(agentID, message.GetConnectionSettingsRequest(), s.agentIDBound(identity, clientCert))
+									s.offerConnectionSettings(agentID, response)
+									s.receiveCustomMessage(ctx, agentID, message.GetCustomMessage(), response)
+								}
 
-									// Check if the message contains effective configuration
-									if message.GetEffectiveConfig() != nil && message.GetEffectiveConfig().GetConfigMap() != nil {
-										effectiveConfig := message.GetEffectiveConfig().GetConfigMap()
-
-										// Log the available keys in the effective config
-										availableKeys := getMapKeys(effectiveConfig.ConfigMap)
-										log.Printf("Received effective config with keys: %v", availableKeys)
-
-										// Try to process the effective configuration with a more flexible approach
-										foundEffectiveConfig := false
-										for key, configFile := range effectiveConfig.ConfigMap {
-											log.Printf("Processing config key: %s", key)
-
-											if configFile != nil {
-												// Store the effective configuration
-												effectiveConfigContent := string(configFile.Body)
-
-												// Log a preview of the effective config
-												configLines := strings.Split(effectiveConfigContent, "\n")
-												previewLines := 10
-												if len(configLines) < previewLines {
-													previewLines = len(configLines)
-												}
-												configPreview := strings.Join(configLines[:previewLines], "\n")
-
-												log.Printf("Received effective configuration from agent %s with key '%s': \n%s...",
-													agentID, key, configPreview)
-												s.agentManager.UpdateAgentEffectiveConfig(agentID, effectiveConfigContent)
-												log.Printf("Updated stored effective configuration for agent %s", agentID)
-												foundEffectiveConfig = true
-												break // Use the first valid config we find
-											}
+								// Check if the message contains effective configuration
+								if message.GetEffectiveConfig() != nil && message.GetEffectiveConfig().GetConfigMap() != nil {
+									effectiveConfig := message.GetEffectiveConfig().GetConfigMap()
+
+									// Log the available keys in the effective config
+									availableKeys := getMapKeys(effectiveConfig.ConfigMap)
+									logger.Debug("Received effective config", "agent_id", agentID, "keys", availableKeys)
+
+									// Try to process the effective configuration with a more flexible approach
+									foundEffectiveConfig := false
+									for key, configFile := range effectiveConfig.ConfigMap {
+										if configFile != nil {
+											// Store the effective configuration
+											effectiveConfigContent := string(configFile.Body)
+
+											s.agentManager.UpdateAgentEffectiveConfig(agentID, effectiveConfigContent)
+											logger.Info("Updated stored effective configuration",
+												"agent_id", agentID,
+												"config_key", key,
+												"config_hash", configHashString(effectiveConfigContent),
+												"config_bytes", len(effectiveConfigContent))
+											foundEffectiveConfig = true
+											break // Use the first valid config we find
 										}
+									}
 
-										if !foundEffectiveConfig {
-											log.Printf("Received effective config but no valid config content found for agent %s", agentID)
-										}
+									if !foundEffectiveConfig {
+										logger.Warn("Received effective config but no valid config content found", "agent_id", agentID)
 									}
+								}
 
-									// Set instance ID in response
-									response.InstanceUid = message.InstanceUid
+								// Set instance ID in response
+								response.InstanceUid = message.InstanceUid
 
-									// Check for disconnect message but DO NOT deregister here
-									if message.GetAgentDisconnect() != nil {
-										log.Printf("Agent signaled disconnect: %s", agentID)
+								// WebSocket agents are deregistered when the connection
+								// closes; HTTP agents only when they say goodbye or go idle
+								if message.GetAgentDisconnect() != nil {
+									logger.Info("Agent signaled disconnect", "agent_id", agentID)
+									if transport == agents.TransportHTTP {
+										s.agentManager.DeregisterAgent(agentID)
 									}
-								}()
+									return
+								}
 
-								return response
-							},
-							OnConnectionClose: func(conn opampTypes.Connection) {
-								// Wrap this callback in panic recovery
-								defer func() {
-									if r := recover(); r != nil {
-										log.Printf("PANIC RECOVERED in OnConnectionClose: %v", r)
+								if transport == agents.TransportHTTP {
+									if agent, ok := s.agentManager.GetAgent(agentID); ok && !agent.Pending {
+										s.deliverQueued(agentID, response)
 									}
-								}()
+								}
+							}()
 
-								// Handle deregistration only here, not in OnMessage
-								s.agentManager.DeregisterAgent(agentID)
-								log.Printf("Agent connection closed: %s", agentID)
-							},
-						}
+							return response
+						},
+						OnConnectionClose: func(conn opampTypes.Connection) {
+							// Wrap this callback in panic recovery
+							defer func() {
+								if r := recover(); r != nil {
+									logger.Error("Panic recovered in OnConnectionClose", "agent_id", agentID, "panic", r)
+								}
+							}()
 
-						// Return a connection response that accepts the connection
-						// and sets up our callbacks
-						result = opampTypes.ConnectionResponse{
-							Accept:              true,
-							ConnectionCallbacks: callbacks,
-						}
-					}()
+							// Each plain HTTP request is a connection of its own; the
+							// agent stays registered between polls
+							if transport == agents.TransportHTTP {
+								return
+							}
 
-					return result
-				},
+							// Handle deregistration only here, not in OnMessage
+							s.agentManager.DeregisterAgent(agentID)
+							logger.Info("Agent connection closed", "agent_id", agentID)
+						},
+					}
+
+					// Return a connection response that accepts the connection
+					// and sets up our callbacks
+					result = opampTypes.ConnectionResponse{
+						Accept:              true,
+						ConnectionCallbacks: callbacks,
+					}
+				}()
+
+				return result
 			},
 		},
 	}
-
-	// Start the OpAMP server
-	if err := s.opampServer.Start(startSettings); err != nil {
-		log.Printf("OpAMP Server failed: %v", err)
-	}
 }
 
 func (s *Server) Start() {
-	s.stopping = false
+	s.stopping.Store(false)
 	common.SetServerInstance(s)
 
-	// Start the OpAMP server in a goroutine
-	go s.startOpampServer()
+	// Start the OpAMP server in a goroutine, unless it shares the API listener
+	if !s.getConfig().OpAMP.Attach {
+		go s.startOpampServer()
+	}
 
 	// Set up the HTTP API.
 	mux := http.NewServeMux()
-	mux.Handle("/api/config", middleware.AuthMiddleware(http.HandlerFunc(api.HandleConfigUpdate())))
-	mux.Handle("/api/loglevel", middleware.AuthMiddleware(http.HandlerFunc(api.HandleLogLevelUpdate())))
-	mux.Handle("/api/agent/loglevel", middleware.AuthMiddleware(http.HandlerFunc(api.HandleAgentLogLevelUpdate())))
-	mux.Handle("/api/agents", middleware.AuthMiddleware(http.HandlerFunc(api.HandleListAgents())))
-
-	mux.Handle("/api/debug/trigger-logs", middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/healthz", api.HandleHealthz())
+	mux.Handle("/readyz", api.HandleReadyz())
+	s.apiRoute(mux, "/api/config", config.ScopeConfigWrite, http.HandlerFunc(api.HandleConfigUpdate()))
+	s.fanOutRoute(mux, "/api/loglevel", config.ScopeLogLevel, http.HandlerFunc(api.HandleLogLevelUpdate()))
+	s.apiRoute(mux, "/api/agent/loglevel", config.ScopeLogLevel, http.HandlerFunc(api.HandleAgentLogLevelUpdate()))
+	s.apiRoute(mux, "/api/agents", config.ScopeRead, http.HandlerFunc(api.HandleListAgents()))
+	s.fanOutRoute(mux, "/api/agents/restart", config.ScopeConfigWrite, http.HandlerFunc(api.HandleRestartAgents()))
+	s.fanOutRoute(mux, "/api/agents/custom-messages", config.ScopeConfigWrite, http.HandlerFunc(api.HandleCustomMessages()))
+	s.apiRoute(mux, "/api/connection-settings", config.ScopeRead, http.HandlerFunc(api.HandleListConnectionOffers()))
+	s.apiRoute(mux, "/api/certificates", config.ScopeRead, http.HandlerFunc(api.HandleListCertificates()))
+	s.apiRoute(mux, "/api/certificates/approve", config.ScopeAdmin, http.HandlerFunc(api.HandleApproveCertificate()))
+	s.apiRoute(mux, "/api/certificates/reject", config.ScopeAdmin, http.HandlerFunc(api.HandleRejectCertificate()))
+	s.apiRoute(mux, "/api/packages", config.ScopeAdmin, http.HandlerFunc(api.HandlePackages()))
+	s.fanOutRoute(mux, "/api/packages/assign", config.ScopeAdmin, http.HandlerFunc(api.HandleAssignPackage()))
+	// Agents download packages without an API token, through the signed,
+	// expiring URL they were offered; files are checked against the hash
+	// and signature they were offered with
+	mux.Handle("GET /packages/{name}/{version}", api.HandlePackageDownload())
+	s.apiRoute(mux, "/metrics", config.ScopeRead, metrics.Handler())
+	s.apiRoute(mux, "/api/admin/loglevel", config.ScopeAdmin, http.HandlerFunc(api.HandleServerLogLevel()))
+	s.apiRoute(mux, "/api/enrollments", config.ScopeRead, http.HandlerFunc(api.HandleListEnrollments()))
+	s.apiRoute(mux, "/api/enrollments/approve", config.ScopeAdmin, http.HandlerFunc(api.HandleApproveEnrollment()))
+	s.apiRoute(mux, "/api/enrollments/reject", config.ScopeAdmin, http.HandlerFunc(api.HandleRejectEnrollment()))
+	s.apiRoute(mux, "/api/audit", config.ScopeAdmin, http.HandlerFunc(api.HandleAuditQuery()))
+	s.apiRoute(mux, "/api/tokens", config.ScopeAdmin, http.HandlerFunc(api.HandleAPITokens()))
+	s.apiRoute(mux, "/api/tokens/expire", config.ScopeAdmin, http.HandlerFunc(api.HandleExpireAPIToken()))
+	s.apiRoute(mux, "/api/tokens/revoke", config.ScopeAdmin, http.HandlerFunc(api.HandleRevokeAPIToken()))
+
+	s.fanOutRoute(mux, "/api/debug/trigger-logs", config.ScopeDebug, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Get log level to generate
 		level := r.URL.Query().Get("level")
 		if level == "" {
@@ -492,14 +776,10 @@ func (s *Server) Start() {
 		}
 
 		// Create a test message to send to all agents
+		var unsupported []string
 		for _, agent := range agents {
-			// Skip agents with nil connection
-			if agent.Conn == nil {
-				continue
-			}
-
-			conn, ok := agent.Conn.(opampTypes.Connection)
-			if !ok {
+			// Skip agents with nil connection, pending enrollment or outside the caller's selector
+			if agent.Conn == nil || agent.Pending || !middleware.AgentAllowed(r.Context(), agent) {
 				continue
 			}
 
@@ -536,25 +816,28 @@ func (s *Server) Start() {
 					},
 					ConfigHash: configHash[:],
 				},
-				Capabilities: uint64(protobufs.ServerCapabilities_ServerCapabilities_OffersRemoteConfig),
 			}
 
 			// Send the message
-			ctx := context.Background()
-			if err := conn.Send(ctx, message); err != nil {
-				log.Printf("Failed to send test message to agent %s: %v", agent.ID, err)
+			beforeHash := currentConfigHash(agent.ID)
+			err := s.sendToAgent(r.Context(), agent, message)
+			audit.AddTarget(r.Context(), agent.ID, beforeHash, configHashString(testConfig), err)
+			if isUnsupported(err) {
+				unsupported = append(unsupported, agent.ID)
+			} else if err != nil {
+				logger.ErrorContext(r.Context(), "Failed to send test message", "agent_id", agent.ID, "operation", "trigger_logs", "error", err)
 			} else {
-				log.Printf("Sent test config to agent %s to generate %d %s logs",
-					agent.ID, count, level)
+				logger.InfoContext(r.Context(), "Sent test config to generate logs",
+					"agent_id", agent.ID, "operation", "trigger_logs", "count", count, "log_level", level)
 			}
 		}
 
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(fmt.Sprintf("Requested %d %s-level log messages from all agents", count, level)))
-	})))
+		w.Write([]byte(fmt.Sprintf("Requested %d %s-level log messages from all agents", count, level) + unsupportedNote(unsupported)))
+	}))
 
 	// Add this endpoint to create synthetic logs by manipulating configurations
-	mux.Handle("/api/debug/synthetic-logs", middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	s.fanOutRoute(mux, "/api/debug/synthetic-logs", config.ScopeDebug, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// First update log level to ensure logs will be visible
 		level := r.URL.Query().Get("level")
 		if level == "" {
@@ -569,24 +852,25 @@ func (s *Server) Start() {
 		}
 
 		// Update log level for all agents
+		var unsupported []string
 		for _, agentID := range s.GetAgentIDs() {
-			err := s.UpdateAgentLogLevel(agentID, level)
-			if err != nil {
-				log.Printf("Failed to update log level for agent %s: %v", agentID, err)
+			if agent, _ := s.agentManager.GetAgent(agentID); !middleware.AgentAllowed(r.Context(), agent) {
+				continue
+			}
+			err := s.UpdateAgentLogLevel(r.Context(), agentID, level)
+			if isUnsupported(err) {
+				unsupported = append(unsupported, agentID)
+			} else if err != nil {
+				logger.ErrorContext(r.Context(), "Failed to update log level", "agent_id", agentID, "operation", "synthetic_logs", "error", err)
 			} else {
-				log.Printf("Updated log level to %s for agent %s", level, agentID)
+				logger.InfoContext(r.Context(), "Updated log level", "agent_id", agentID, "operation", "synthetic_logs", "log_level", level)
 			}
 		}
 
 		// Now send some configuration modifications that will cause log entries
 		// For instance, try to send an invalid config that will be rejected and logged
 		for _, agent := range agents {
-			if agent.Conn == nil {
-				continue
-			}
-
-			conn, ok := agent.Conn.(opampTypes.Connection)
-			if !ok {
+			if agent.Conn == nil || agent.Pending || !middleware.AgentAllowed(r.Context(), agent) || slices.Contains(unsupported, agent.ID) {
 				continue
 			}
 
@@ -617,26 +901,32 @@ processors:
 					},
 					ConfigHash: configHash[:],
 				},
-				Capabilities: uint64(protobufs.ServerCapabilities_ServerCapabilities_OffersRemoteConfig),
 			}
 
-			ctx := context.Background()
-			if err := conn.Send(ctx, message); err != nil {
-				log.Printf("Failed to send test config to agent %s: %v", agent.ID, err)
+			beforeHash := currentConfigHash(agent.ID)
+			err := s.sendToAgent(r.Context(), agent, message)
+			audit.AddTarget(r.Context(), agent.ID, beforeHash, configHashString(invalidConfig), err)
+			if isUnsupported(err) {
+				unsupported = append(unsupported, agent.ID)
+			} else if err != nil {
+				logger.ErrorContext(r.Context(), "Failed to send test config", "agent_id", agent.ID, "operation", "synthetic_logs", "error", err)
 			} else {
-				log.Printf("Sent test config to agent %s to generate %s logs", agent.ID, level)
+				logger.InfoContext(r.Context(), "Sent test config to generate logs", "agent_id", agent.ID, "operation", "synthetic_logs", "log_level", level)
 			}
 		}
 
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(fmt.Sprintf("Updated log level to %s and sent synthetic configuration to generate logs", level)))
-	})))
+		w.Write([]byte(fmt.Sprintf("Updated log level to %s and sent synthetic configuration to generate logs", level) + unsupportedNote(unsupported)))
+	}))
 	// Add a debug endpoint to test agent connectivity
-	mux.Handle("/api/debug/agents", middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	s.apiRoute(mux, "/api/debug/agents", config.ScopeDebug, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		agents := s.agentManager.GetAllAgents()
 		result := make([]map[string]interface{}, 0, len(agents))
 
 		for _, agent := range agents {
+			if !middleware.AgentAllowed(r.Context(), agent) {
+				continue
+			}
 			agentData := map[string]interface{}{
 				"id":       agent.ID,
 				"id_len":   len(agent.ID),
@@ -647,10 +937,10 @@ processors:
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(result)
-	})))
+	}))
 
 	// Add a debug endpoint to inspect agent configuration
-	mux.Handle("/api/debug/agent-config", middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	s.apiRoute(mux, "/api/debug/agent-config", config.ScopeDebug, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		agentID := r.URL.Query().Get("agent_id")
 		if agentID == "" {
 			// If no specific agent ID, list all agents with their config status
@@ -658,6 +948,9 @@ processors:
 			result := make([]map[string]interface{}, 0, len(agents))
 
 			for _, agent := range agents {
+				if !middleware.AgentAllowed(r.Context(), agent) {
+					continue
+				}
 				configInfo := map[string]interface{}{
 					"agent_id":             agent.ID,
 					"has_effective_config": agent.EffectiveConfig != "",
@@ -684,7 +977,7 @@ processors:
 
 		// If specific agent ID is provided, show detailed config info
 		agent, exists := s.agentManager.GetAgent(agentID)
-		if !exists {
+		if !exists || !middleware.AgentAllowed(r.Context(), agent) {
 			http.Error(w, "Agent not found", http.StatusNotFound)
 			return
 		}
@@ -768,29 +1061,50 @@ processors:
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(result)
-	})))
+	}))
+
+	sweepCtx, cancelSweep := context.WithCancel(context.Background())
+	s.sweepCancel = cancelSweep
+	go s.sweepIdleHTTPAgents(sweepCtx)
+
+	cfg := s.getConfig()
+	if cfg.Reload.Watch {
+		watchCtx, cancel := context.WithCancel(context.Background())
+		s.watchCancel = cancel
+		go s.watchConfigFiles(watchCtx, cfg.Reload.Interval)
+	}
 
-	l, err := net.Listen("tcp", s.config.API.ListenAddress)
+	l, err := net.Listen("tcp", cfg.API.ListenAddress)
 	if err != nil {
-		log.Fatalf("Failed to listen on %s: %v", s.config.API.ListenAddress, err)
+		logger.Error("Failed to listen", "listen_address", cfg.API.ListenAddress, "error", err)
+		os.Exit(1)
 	}
-	s.httpServer = &http.Server{
-		Handler: mux,
+	httpServer := &http.Server{
+		Handler: middleware.RequestID(middleware.Audit(s.auditLog, mux)),
 	}
-
-	log.Printf("API server listening on %s", l.Addr().String())
-	if err := s.httpServer.Serve(l); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("HTTP server failed: %v", err)
+	if cfg.OpAMP.Attach {
+		handler, connContext, err := s.attachOpamp(httpServer.Handler)
+		if err == nil {
+			l, err = s.attachListener(l)
+		}
+		if err != nil {
+			logger.Error("Failed to attach OpAMP to the API listener", "error", err)
+			os.Exit(1)
+		}
+		httpServer.Handler = handler
+		httpServer.ConnContext = connContext
+		s.setOpampListening(true)
+		logger.Info("OpAMP attached to the API listener", "listen_address", l.Addr().String(), "path", opampPath)
 	}
-}
 
-// Stop cleanly stops the server
-func (s *Server) Stop() {
-	s.stopping = true
+	s.stateMu.Lock()
+	s.apiAddr = l.Addr()
+	s.httpServer = httpServer
+	s.stateMu.Unlock()
 
-	if s.httpServer != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		s.httpServer.Shutdown(ctx)
+	logger.Info("API server listening", "listen_address", l.Addr().String())
+	if err := httpServer.Serve(l); err != nil && err != http.ErrServerClosed {
+		logger.Error("HTTP server failed", "error", err)
+		os.Exit(1)
 	}
 }
@@ -1,12 +1,34 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/issuer"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"google.golang.org/protobuf/proto"
 )
 
 func createTempConfig(content string) (string, error) {
@@ -21,40 +43,1245 @@ func createTempConfig(content string) (string, error) {
 	return tmpFile.Name(), nil
 }
 
+// startTestServer starts a server with the given backend.yaml content and
+// waits until /readyz reports it ready. Listen addresses in the content use
+// port 0; tests reach the server through opampURL and apiURL. setup runs
+// before Start. The server is stopped when the test ends.
+func startTestServer(t *testing.T, content string, setup ...func(*Server)) *Server {
+	t.Helper()
+
+	configPath := filepath.Join(t.TempDir(), "backend.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	s, err := NewServer(configPath)
+	if err != nil {
+		t.Fatalf("NewServer error: %v", err)
+	}
+	for _, fn := range setup {
+		fn(s)
+	}
+	t.Cleanup(s.Stop)
+	go s.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if addr := s.APIAddr(); addr != nil {
+			if resp, err := http.Get("http://" + addr.String() + "/readyz"); err == nil {
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					return s
+				}
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Server did not become ready")
+	return nil
+}
+
+// opampURL returns the URL of the server's OpAMP endpoint for scheme, e.g. "ws".
+func opampURL(s *Server, scheme string) string {
+	return scheme + "://" + s.OpAMPAddr().String() + opampPath
+}
+
+// apiURL returns the URL of path on the server's API listener.
+func apiURL(s *Server, path string) string {
+	return "http://" + s.APIAddr().String() + path
+}
+
+// exchangeAgentMessage sends an AgentToServer message over an OpAMP WebSocket
+// connection and returns the server's response.
+func exchangeAgentMessage(t *testing.T, conn *websocket.Conn, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+	t.Helper()
+
+	data, err := proto.Marshal(message)
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	// OpAMP WebSocket messages start with a zero header byte.
+	if err := conn.WriteMessage(websocket.BinaryMessage, append([]byte{0}, data...)); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, reply, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	response := &protobufs.ServerToAgent{}
+	if err := proto.Unmarshal(reply[1:], response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	return response
+}
+
 const testConfigWebSocket = `
 opamp:
-  listen_address: "127.0.0.1:4322"
+  listen_address: "127.0.0.1:0"
   tls:
     cert_file: ""
     key_file: ""
 api:
-  listen_address: "127.0.0.1:8081"
+  listen_address: "127.0.0.1:0"
 `
 
 func TestOpAMPWebSocketConnection(t *testing.T) {
-	// Create temporary configuration file.
-	configPath, err := createTempConfig(testConfigWebSocket)
+	s := startTestServer(t, testConfigWebSocket)
+
+	// Dial the WebSocket endpoint.
+	wsURL := opampURL(s, "ws")
+	dialer := websocket.Dialer{}
+	conn, _, err := dialer.Dial(wsURL, nil)
 	if err != nil {
-		t.Fatalf("Failed to create temp config: %v", err)
+		t.Fatalf("Failed to establish WebSocket connection: %v", err)
 	}
-	defer os.Remove(configPath)
+	conn.Close()
+}
 
-	s, err := NewServer(configPath)
+const testConfigAgentAuth = `
+opamp:
+  listen_address: "127.0.0.1:0"
+  auth:
+    enabled: true
+    shared_secrets: ["agent-secret"]
+api:
+  listen_address: "127.0.0.1:0"
+`
+
+func TestOpAMPAgentAuthentication(t *testing.T) {
+	s := startTestServer(t, testConfigAgentAuth)
+
+	wsURL := opampURL(s, "ws")
+	before := rejectedConnections.Value("missing_credentials")
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil {
+		t.Fatal("Expected connection without credentials to be rejected")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for missing credentials, got %v", resp)
+	}
+	if after := rejectedConnections.Value("missing_credentials"); after != before+1 {
+		t.Errorf("Expected rejection to be counted, got %d -> %d", before, after)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Secret-Key agent-secret"}})
 	if err != nil {
-		t.Fatalf("NewServer error: %v", err)
+		t.Fatalf("Expected connection with shared secret to be accepted: %v", err)
 	}
+	conn.Close()
+}
 
-	// Start the server in a goroutine.
-	go s.Start()
-	// Allow server time to start.
-	time.Sleep(500 * time.Millisecond)
+const testConfigEnrollment = `
+opamp:
+  listen_address: "127.0.0.1:0"
+  enrollment:
+    enabled: true
+    auto_approve:
+      - name: "prod"
+        attributes:
+          deployment.environment: "prod"
+api:
+  listen_address: "127.0.0.1:0"
+`
 
-	// Dial the WebSocket endpoint.
-	wsURL := "ws://127.0.0.1:4322/v1/opamp"
-	dialer := websocket.Dialer{}
-	conn, _, err := dialer.Dial(wsURL, nil)
+func TestOpAMPEnrollment(t *testing.T) {
+	s := startTestServer(t, testConfigEnrollment)
+
+	description := func(environment string) *protobufs.AgentDescription {
+		return &protobufs.AgentDescription{
+			NonIdentifyingAttributes: []*protobufs.KeyValue{{
+				Key:   "deployment.environment",
+				Value: &protobufs.AnyValue{Value: &protobufs.AnyValue_StringValue{StringValue: environment}},
+			}},
+		}
+	}
+	connect := func(uid []byte, environment string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(opampURL(s, "ws"), nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		exchangeAgentMessage(t, conn, &protobufs.AgentToServer{InstanceUid: uid, AgentDescription: description(environment)})
+		return conn
+	}
+
+	pending := connect([]byte{0x01, 0x02}, "staging")
+	defer pending.Close()
+	approved := connect([]byte{0x03, 0x04}, "prod")
+	defer approved.Close()
+
+	if ids := s.GetAgentIDs(); len(ids) != 1 || ids[0] != "0304" {
+		t.Fatalf("Expected only the auto-approved agent to be managed, got %v", ids)
+	}
+	if err := s.UpdateAgentLogLevel(context.Background(), "0102", "debug"); err == nil {
+		t.Error("Expected configuration push to a pending agent to fail")
+	}
+
+	if _, err := s.ApproveAgent("0102"); err != nil {
+		t.Fatalf("ApproveAgent error: %v", err)
+	}
+	if agent, _ := s.GetAgent("0102"); agent == nil || agent.Pending {
+		t.Error("Expected approved agent to be managed")
+	}
+
+	if _, err := s.RejectAgent("0304"); err != nil {
+		t.Fatalf("RejectAgent error: %v", err)
+	}
+	if _, exists := s.GetAgent("0304"); exists {
+		t.Error("Expected rejected agent to be removed")
+	}
+	rejected, _, err := websocket.DefaultDialer.Dial(opampURL(s, "ws"), nil)
 	if err != nil {
-		t.Fatalf("Failed to establish WebSocket connection: %v", err)
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer rejected.Close()
+	data, _ := proto.Marshal(&protobufs.AgentToServer{InstanceUid: []byte{0x03, 0x04}, AgentDescription: description("prod")})
+	rejected.WriteMessage(websocket.BinaryMessage, append([]byte{0}, data...))
+	rejected.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := rejected.ReadMessage(); err == nil {
+		t.Error("Expected rejected agent to be disconnected on reconnect")
+	}
+	if _, exists := s.GetAgent("0304"); exists {
+		t.Error("Expected rejected agent to be deregistered")
+	}
+}
+
+const testConfigHTTPPolling = `
+opamp:
+  listen_address: "127.0.0.1:0"
+api:
+  listen_address: "127.0.0.1:0"
+`
+
+// pollAgentMessage sends an AgentToServer message as a plain HTTP OpAMP
+// request and returns the server's response.
+func pollAgentMessage(t *testing.T, url string, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+	t.Helper()
+	return pollAgentMessageAs(t, url, "", message)
+}
+
+// pollAgentMessageAs is pollAgentMessage with an Authorization header.
+func pollAgentMessageAs(t *testing.T, url, authorization string, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+	t.Helper()
+
+	data, err := proto.Marshal(message)
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to poll: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from poll, got %d", resp.StatusCode)
+	}
+	reply, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	response := &protobufs.ServerToAgent{}
+	if err := proto.Unmarshal(reply, response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	return response
+}
+
+func TestOpAMPPlainHTTPPolling(t *testing.T) {
+	s := startTestServer(t, testConfigHTTPPolling)
+
+	url := opampURL(s, "http")
+	uid := []byte{0x0a, 0x0b}
+	pollAgentMessage(t, url, &protobufs.AgentToServer{InstanceUid: uid})
+
+	agent, exists := s.GetAgent("0a0b")
+	if !exists || agent.Transport != agents.TransportHTTP {
+		t.Fatalf("Expected HTTP agent to stay registered after its poll, got %+v", agent)
+	}
+
+	// Remote config is not sent until the agent advertises it accepts it.
+	if err := s.UpdateAgentLogLevel(context.Background(), "0a0b", "debug"); !errors.Is(err, agents.ErrUnsupported) {
+		t.Fatalf("Expected ErrUnsupported before AcceptsRemoteConfig, got %v", err)
+	}
+	if response := pollAgentMessage(t, url, &protobufs.AgentToServer{
+		InstanceUid:  uid,
+		Capabilities: uint64(protobufs.AgentCapabilities_AgentCapabilities_AcceptsRemoteConfig),
+	}); response.GetRemoteConfig() != nil {
+		t.Fatal("Expected no remote config to be queued for an unsupported push")
+	}
+
+	// Two pushes between polls are merged into the next response.
+	if err := s.UpdateAgentLogLevel(context.Background(), "0a0b", "debug"); err != nil {
+		t.Fatalf("UpdateAgentLogLevel error: %v", err)
+	}
+	if err := s.RequestAgentConfig("0a0b"); err != nil {
+		t.Fatalf("RequestAgentConfig error: %v", err)
+	}
+
+	response := pollAgentMessage(t, url, &protobufs.AgentToServer{InstanceUid: uid, SequenceNum: 1})
+	if response.GetRemoteConfig() == nil {
+		t.Fatal("Expected queued remote config in the poll response")
+	}
+	wantCaps := uint64(protobufs.ServerCapabilities_ServerCapabilities_OffersRemoteConfig | protobufs.ServerCapabilities_ServerCapabilities_AcceptsEffectiveConfig)
+	if response.Capabilities&wantCaps != wantCaps {
+		t.Errorf("Expected capabilities of both queued messages, got %b", response.Capabilities)
+	}
+	if string(response.InstanceUid) != string(uid) {
+		t.Errorf("Expected response for instance %x, got %x", uid, response.InstanceUid)
+	}
+
+	if response := pollAgentMessage(t, url, &protobufs.AgentToServer{InstanceUid: uid, SequenceNum: 2}); response.GetRemoteConfig() != nil {
+		t.Error("Expected the queue to be empty after delivery")
+	}
+
+	pollAgentMessage(t, url, &protobufs.AgentToServer{InstanceUid: uid, SequenceNum: 3, AgentDisconnect: &protobufs.AgentDisconnect{}})
+	if _, exists := s.GetAgent("0a0b"); exists {
+		t.Error("Expected agent to be removed after AgentDisconnect")
+	}
+}
+
+const testConfigHTTPSessions = `
+opamp:
+  listen_address: "127.0.0.1:0"
+  auth:
+    enabled: true
+    bearer_tokens:
+      - name: "agent-a"
+        token: "token-a"
+      - name: "agent-b"
+        token: "token-b"
+api:
+  listen_address: "127.0.0.1:0"
+`
+
+func TestOpAMPPlainHTTPSessionTakeover(t *testing.T) {
+	s := startTestServer(t, testConfigHTTPSessions)
+	url := opampURL(s, "http")
+	remoteConfig := uint64(protobufs.AgentCapabilities_AgentCapabilities_AcceptsRemoteConfig)
+
+	pollAgentMessageAs(t, url, "Bearer token-a", &protobufs.AgentToServer{InstanceUid: []byte{0x0c, 0x01}, Capabilities: remoteConfig})
+	if err := s.UpdateAgentLogLevel(context.Background(), "0c01", "debug"); err != nil {
+		t.Fatalf("UpdateAgentLogLevel error: %v", err)
+	}
+
+	// Another credential cannot continue the session or take its queue
+	response := pollAgentMessageAs(t, url, "Bearer token-b", &protobufs.AgentToServer{InstanceUid: []byte{0x0c, 0x01}, SequenceNum: 1})
+	if response.ErrorResponse == nil || response.RemoteConfig != nil {
+		t.Fatalf("Expected a poll with another credential to be refused, got %v", response)
+	}
+	response = pollAgentMessageAs(t, url, "Bearer token-a", &protobufs.AgentToServer{InstanceUid: []byte{0x0c, 0x01}, SequenceNum: 1})
+	if response.ErrorResponse != nil || response.RemoteConfig == nil {
+		t.Fatalf("Expected the agent to receive its queued config, got %v", response)
+	}
+	if ids := s.GetAgentIDs(); len(ids) != 1 || ids[0] != "0c01" {
+		t.Errorf("Expected only the polling agent to be registered, got %v", ids)
+	}
+
+	// A WebSocket agent's record is not taken over by a poll
+	conn, _, err := websocket.DefaultDialer.Dial(opampURL(s, "ws"), http.Header{"Authorization": {"Bearer token-a"}})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	exchangeAgentMessage(t, conn, &protobufs.AgentToServer{InstanceUid: []byte{0x0c, 0x02}})
+	response = pollAgentMessageAs(t, url, "Bearer token-a", &protobufs.AgentToServer{InstanceUid: []byte{0x0c, 0x02}, SequenceNum: 1})
+	if response.ErrorResponse == nil {
+		t.Error("Expected a poll for a WebSocket agent to be refused")
+	}
+	if agent, _ := s.GetAgent("0c02"); agent == nil || agent.Transport != agents.TransportWebSocket {
+		t.Errorf("Expected the WebSocket agent to keep its connection, got %+v", agent)
+	}
+}
+
+const testConfigAttach = `
+opamp:
+  attach: true
+  auth:
+    enabled: true
+    shared_secrets:
+      - "agent-secret"
+api:
+  listen_address: "127.0.0.1:0"
+`
+
+func TestOpAMPAttachMode(t *testing.T) {
+	s := startTestServer(t, testConfigAttach)
+
+	// Agents authenticate with agent credentials, not API tokens.
+	if _, resp, err := websocket.DefaultDialer.Dial(opampURL(s, "ws"), nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an agent without credentials, got %v", resp)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(opampURL(s, "ws"), http.Header{"Authorization": {"Secret-Key agent-secret"}})
+	if err != nil {
+		t.Fatalf("Failed to connect to the attached OpAMP endpoint: %v", err)
+	}
+	defer conn.Close()
+	exchangeAgentMessage(t, conn, &protobufs.AgentToServer{InstanceUid: []byte{0x0c, 0x0d}})
+	if _, exists := s.GetAgent("0c0d"); !exists {
+		t.Error("Expected agent connected through the API listener to be registered")
+	}
+
+	// The API on the same port still requires an API token.
+	resp, err := http.Get(apiURL(s, "/api/agents"))
+	if err != nil {
+		t.Fatalf("API request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 from the API without a token, got %d", resp.StatusCode)
+	}
+}
+
+const testConfigSequenceNumbers = `
+opamp:
+  listen_address: "127.0.0.1:0"
+api:
+  listen_address: "127.0.0.1:0"
+`
+
+func TestOpAMPSequenceNumbersAndCapabilities(t *testing.T) {
+	s := startTestServer(t, testConfigSequenceNumbers)
+
+	reportFullState := uint64(protobufs.ServerToAgentFlags_ServerToAgentFlags_ReportFullState)
+
+	conn, _, err := websocket.DefaultDialer.Dial(opampURL(s, "ws"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	uid := []byte{0x05, 0x06}
+	health := &protobufs.ComponentHealth{Healthy: false, LastError: "exporter failing"}
+	agentCaps := uint64(protobufs.AgentCapabilities_AgentCapabilities_ReportsStatus | protobufs.AgentCapabilities_AgentCapabilities_ReportsHealth)
+	resp := exchangeAgentMessage(t, conn, &protobufs.AgentToServer{InstanceUid: uid, Health: health, Capabilities: agentCaps})
+	if resp.Flags&reportFullState != 0 {
+		t.Error("Did not expect a full state request for a fresh start")
+	}
+	if resp.Capabilities != serverCapabilities(s.getConfig()) {
+		t.Errorf("Expected the server capabilities in every response, got %b", resp.Capabilities)
+	}
+	if agent, _ := s.GetAgent("0506"); agent == nil || !agent.HasCapability(protobufs.AgentCapabilities_AgentCapabilities_ReportsHealth) || agent.HasCapability(protobufs.AgentCapabilities_AgentCapabilities_AcceptsRemoteConfig) {
+		t.Errorf("Expected the advertised agent capabilities to be recorded, got %+v", agent)
+	}
+	if resp := exchangeAgentMessage(t, conn, &protobufs.AgentToServer{InstanceUid: uid, SequenceNum: 1}); resp.Flags&reportFullState != 0 {
+		t.Error("Did not expect a full state request for the next message")
+	}
+	if resp := exchangeAgentMessage(t, conn, &protobufs.AgentToServer{InstanceUid: uid, SequenceNum: 3}); resp.Flags&reportFullState == 0 {
+		t.Error("Expected a full state request after a missed message")
+	}
+	if agent, _ := s.GetAgent("0506"); agent == nil || agent.Health == nil || agent.Health.LastError != "exporter failing" {
+		t.Errorf("Expected reported health to be stored, got %+v", agent)
+	}
+
+	// A polling agent the server has not seen, e.g. after a restart.
+	resp = pollAgentMessage(t, opampURL(s, "http"), &protobufs.AgentToServer{InstanceUid: []byte{0x07}, SequenceNum: 42})
+	if resp.Flags&reportFullState == 0 {
+		t.Error("Expected a full state request from an unknown agent")
+	}
+}
+
+const testConfigRestart = `
+opamp:
+  listen_address: "127.0.0.1:0"
+api:
+  listen_address: "127.0.0.1:0"
+  tokens:
+    - name: "ops"
+      token: "ops-token"
+      scopes: ["config:write"]
+`
+
+func TestOpAMPRestartCommand(t *testing.T) {
+	s := startTestServer(t, testConfigRestart)
+
+	url := opampURL(s, "http")
+	collector := &protobufs.AgentDescription{
+		IdentifyingAttributes: []*protobufs.KeyValue{{
+			Key:   "service.name",
+			Value: &protobufs.AnyValue{Value: &protobufs.AnyValue_StringValue{StringValue: "collector"}},
+		}},
+	}
+	restartable := []byte{0x0e, 0x01}
+	pollAgentMessage(t, url, &protobufs.AgentToServer{
+		InstanceUid:      restartable,
+		Capabilities:     uint64(protobufs.AgentCapabilities_AgentCapabilities_AcceptsRestartCommand),
+		AgentDescription: collector,
+	})
+	pollAgentMessage(t, url, &protobufs.AgentToServer{
+		InstanceUid:      []byte{0x0e, 0x02},
+		AgentDescription: collector,
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, apiURL(s, "/api/agents/restart"),
+		strings.NewReader(`{"agents": {"labels": {"service.name": "collector"}}}`))
+	req.Header.Set("Authorization", "Bearer ops-token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Restart request failed: %v", err)
+	}
+	var body struct {
+		Requested int `json:"requested"`
+		Results   map[string]struct {
+			Status string `json:"status"`
+		} `json:"results"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Errorf("Expected 206 when one agent cannot restart, got %d", resp.StatusCode)
+	}
+	if body.Results["0e01"].Status != agents.RestartRequested || body.Results["0e02"].Status != "unsupported" {
+		t.Errorf("Unexpected per-agent results: %+v", body.Results)
+	}
+
+	response := pollAgentMessage(t, url, &protobufs.AgentToServer{InstanceUid: restartable, SequenceNum: 1})
+	if response.Command == nil || response.Command.Type != protobufs.CommandType_CommandType_Restart {
+		t.Fatalf("Expected the restart command in the poll response, got %v", response.Command)
+	}
+	if restart, _ := s.agentManager.GetRestart("0e01"); restart.State != agents.RestartRequested {
+		t.Errorf("Expected restart to wait for the agent, got %q", restart.State)
+	}
+
+	// The restarted agent starts counting its messages from zero again.
+	pollAgentMessage(t, url, &protobufs.AgentToServer{InstanceUid: restartable})
+	restart, exists := s.agentManager.GetRestart("0e01")
+	if !exists || restart.State != agents.RestartCompleted || restart.ReconnectedAt == nil {
+		t.Errorf("Expected the restart to be completed, got %+v", restart)
+	}
+}
+
+const testConfigPackages = `
+opamp:
+  listen_address: "127.0.0.1:0"
+api:
+  listen_address: "127.0.0.1:0"
+  tokens:
+    - name: "admin"
+      token: "admin-token"
+      scopes: ["admin"]
+packages:
+  dir: "%s"
+  download_url: "http://packages.example.com/"
+`
+
+func TestOpAMPPackages(t *testing.T) {
+	s := startTestServer(t, fmt.Sprintf(testConfigPackages, t.TempDir()))
+
+	apiRequest := func(method, path string, body io.Reader) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, apiURL(s, path), body)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		return resp
+	}
+
+	content := "collector binary"
+	resp := apiRequest(http.MethodPost, "/api/packages?name=otelcol&version=1.0", strings.NewReader(content))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected 201 from upload, got %d", resp.StatusCode)
+	}
+
+	url := opampURL(s, "http")
+	uid := []byte{0x0f, 0x01}
+	first := pollAgentMessage(t, url, &protobufs.AgentToServer{
+		InstanceUid:  uid,
+		Capabilities: uint64(protobufs.AgentCapabilities_AgentCapabilities_AcceptsPackages | protobufs.AgentCapabilities_AgentCapabilities_ReportsPackageStatuses),
+	})
+	wantCaps := uint64(protobufs.ServerCapabilities_ServerCapabilities_OffersPackages | protobufs.ServerCapabilities_ServerCapabilities_AcceptsPackagesStatus)
+	if first.Capabilities&wantCaps != wantCaps {
+		t.Errorf("Expected package capabilities to be advertised, got %b", first.Capabilities)
+	}
+	if first.PackagesAvailable != nil {
+		t.Error("Expected no packages before an assignment")
+	}
+
+	resp = apiRequest(http.MethodPost, "/api/packages/assign", strings.NewReader(`{"name": "otelcol", "version": "1.0"}`))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from assign, got %d", resp.StatusCode)
+	}
+
+	response := pollAgentMessage(t, url, &protobufs.AgentToServer{InstanceUid: uid, SequenceNum: 1})
+	offered := response.GetPackagesAvailable().GetPackages()["otelcol"]
+	if offered == nil || offered.Version != "1.0" {
+		t.Fatalf("Expected otelcol 1.0 to be offered, got %v", response.PackagesAvailable)
+	}
+
+	// The download URL is the configured one; fetch its path from this server
+	path, found := strings.CutPrefix(offered.File.DownloadUrl, "http://packages.example.com")
+	if !found {
+		t.Fatalf("Expected a download URL under download_url, got %q", offered.File.DownloadUrl)
+	}
+	download, err := http.Get(apiURL(s, path))
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	data, _ := io.ReadAll(download.Body)
+	download.Body.Close()
+	if download.StatusCode != http.StatusOK || string(data) != content {
+		t.Errorf("Download returned %d %q", download.StatusCode, data)
 	}
+
+	// Downloads need the signature issued to the agent for that version.
+	unsigned, _, _ := strings.Cut(path, "?")
+	for _, denied := range []string{unsigned, strings.Replace(path, "agent_id=0f01", "agent_id=0f02", 1), strings.Replace(path, "/1.0?", "/2.0?", 1)} {
+		resp, err := http.Get(apiURL(s, denied))
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected 403 downloading %s, got %d", denied, resp.StatusCode)
+		}
+	}
+
+	// Once installed, the agent is not offered the same packages again.
+	pollAgentMessage(t, url, &protobufs.AgentToServer{InstanceUid: uid, SequenceNum: 2, PackageStatuses: &protobufs.PackageStatuses{
+		ServerProvidedAllPackagesHash: response.PackagesAvailable.AllPackagesHash,
+		Packages: map[string]*protobufs.PackageStatus{
+			"otelcol": {Name: "otelcol", AgentHasVersion: "1.0", ServerOfferedVersion: "1.0", Status: protobufs.PackageStatusEnum_PackageStatusEnum_Installed},
+		},
+	}})
+	agent, _ := s.GetAgent("0f01")
+	if status := agent.PackageStatuses.GetPackages()["otelcol"]; status.GetStatus() != protobufs.PackageStatusEnum_PackageStatusEnum_Installed {
+		t.Errorf("Expected the installed status to be recorded, got %v", status)
+	}
+	if s.agentManager.NeedsPackages("0f01", response.PackagesAvailable.AllPackagesHash) {
+		t.Error("Expected the agent to have its packages")
+	}
+}
+
+const testConfigConnectionSettings = `
+opamp:
+  listen_address: "127.0.0.1:0"
+api:
+  listen_address: "127.0.0.1:0"
+connection_settings:
+  - name: "rotate"
+    opamp:
+      endpoint: "ws://opamp.example.com/v1/opamp"
+      headers:
+        Authorization: "Secret-Key %s"
+`
+
+func TestOpAMPConnectionSettingsOffers(t *testing.T) {
+	s := startTestServer(t, fmt.Sprintf(testConfigConnectionSettings, "first"))
+
+	hello := &protobufs.AgentToServer{
+		InstanceUid:  []byte{0x10, 0x01},
+		Capabilities: uint64(protobufs.AgentCapabilities_AgentCapabilities_AcceptsOpAMPConnectionSettings),
+	}
+	connect := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(opampURL(s, "ws"), nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		return conn
+	}
+
+	conn := connect()
+	response := exchangeAgentMessage(t, conn, hello)
+	headers := response.GetConnectionSettings().GetOpamp().GetHeaders().GetHeaders()
+	if len(headers) != 1 || headers[0].Value != "Secret-Key first" {
+		t.Fatalf("Expected the OpAMP connection settings to be offered, got %v", response.ConnectionSettings)
+	}
+	if response.Capabilities&uint64(protobufs.ServerCapabilities_ServerCapabilities_OffersConnectionSettings) == 0 {
+		t.Errorf("Expected OffersConnectionSettings to be advertised, got %b", response.Capabilities)
+	}
+
+	// The agent applies the settings by reconnecting and is not offered them again.
 	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	conn = connect()
+	defer conn.Close()
+	if response := exchangeAgentMessage(t, conn, hello); response.ConnectionSettings != nil {
+		t.Error("Expected no offer after reconnecting with the offered settings")
+	}
+	offers := s.ListConnectionOffers()
+	if len(offers) != 1 || offers[0].ReconnectedAt == nil {
+		t.Errorf("Expected the reconnect to be recorded, got %+v", offers)
+	}
+
+	// Changed settings are pushed to connected agents on reload.
+	if err := os.WriteFile(s.configPath, []byte(fmt.Sprintf(testConfigConnectionSettings, "second")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadConfig(); err != nil {
+		t.Fatalf("ReloadConfig error: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, reply, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read pushed settings: %v", err)
+	}
+	pushed := &protobufs.ServerToAgent{}
+	if err := proto.Unmarshal(reply[1:], pushed); err != nil {
+		t.Fatalf("Failed to unmarshal pushed settings: %v", err)
+	}
+	if headers := pushed.GetConnectionSettings().GetOpamp().GetHeaders().GetHeaders(); len(headers) != 1 || headers[0].Value != "Secret-Key second" {
+		t.Errorf("Expected the rotated header to be pushed, got %v", pushed.ConnectionSettings)
+	}
+}
+
+const testConfigOwnTelemetry = `
+opamp:
+  listen_address: "127.0.0.1:0"
+api:
+  listen_address: "127.0.0.1:0"
+connection_settings:
+  - name: "self-monitoring"
+    own_metrics:
+      endpoint: "https://otlp.example.com:4318/v1/metrics"
+    own_logs:
+      endpoint: "https://otlp.example.com:4318/v1/logs"
+`
+
+func TestOpAMPOwnTelemetryOffers(t *testing.T) {
+	s := startTestServer(t, testConfigOwnTelemetry)
+
+	conn, _, err := websocket.DefaultDialer.Dial(opampURL(s, "ws"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	uid := []byte{0x11, 0x01}
+	response := exchangeAgentMessage(t, conn, &protobufs.AgentToServer{
+		InstanceUid:  uid,
+		Capabilities: uint64(protobufs.AgentCapabilities_AgentCapabilities_ReportsOwnMetrics | protobufs.AgentCapabilities_AgentCapabilities_ReportsHealth),
+		Health:       &protobufs.ComponentHealth{Healthy: true},
+	})
+	settings := response.GetConnectionSettings()
+	if settings.GetOwnMetrics().GetDestinationEndpoint() != "https://otlp.example.com:4318/v1/metrics" {
+		t.Fatalf("Expected the own metrics destination to be offered, got %v", settings)
+	}
+	if settings.OwnLogs != nil {
+		t.Errorf("Expected no logs destination for an agent that does not report its own logs, got %v", settings.OwnLogs)
+	}
+	offers := s.ListConnectionOffers()
+	if len(offers) != 1 || offers[0].OwnTelemetry != agents.OwnTelemetryOffered {
+		t.Fatalf("Expected the offer to await verification, got %+v", offers)
+	}
+
+	// The agent reports its health after applying the settings.
+	exchangeAgentMessage(t, conn, &protobufs.AgentToServer{
+		InstanceUid: uid,
+		SequenceNum: 1,
+		Health:      &protobufs.ComponentHealth{Healthy: false, LastError: "exporter: connection refused"},
+	})
+	offers = s.ListConnectionOffers()
+	if len(offers) != 1 || offers[0].OwnTelemetry != agents.OwnTelemetryUnhealthy || offers[0].OwnTelemetryError != "exporter: connection refused" || offers[0].VerifiedAt == nil {
+		t.Errorf("Expected the offer to be marked unhealthy, got %+v", offers)
+	}
+}
+
+const testConfigCertificates = `
+opamp:
+  listen_address: "127.0.0.1:0"
+api:
+  listen_address: "127.0.0.1:0"
+certificates:
+  ca_cert_file: "%s"
+  ca_key_file: "%s"
+  endpoint: "wss://opamp.example.com/v1/opamp"
+  validity: 24h
+`
+
+// writeTestCA writes a self-signed CA certificate and key to dir.
+func writeTestCA(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "agents-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestOpAMPCertificateRequests(t *testing.T) {
+	caCert, caKey := writeTestCA(t, t.TempDir())
+	s := startTestServer(t, fmt.Sprintf(testConfigCertificates, caCert, caKey))
+
+	conn, _, err := websocket.DefaultDialer.Dial(opampURL(s, "ws"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "collector"}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := exchangeAgentMessage(t, conn, &protobufs.AgentToServer{
+		InstanceUid:  []byte{0x12, 0x01},
+		Capabilities: uint64(protobufs.AgentCapabilities_AgentCapabilities_AcceptsOpAMPConnectionSettings),
+		ConnectionSettingsRequest: &protobufs.ConnectionSettingsRequest{Opamp: &protobufs.OpAMPConnectionSettingsRequest{
+			CertificateRequest: &protobufs.CertificateRequest{Csr: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})},
+		}},
+	})
+	if response.Capabilities&uint64(protobufs.ServerCapabilities_ServerCapabilities_AcceptsConnectionSettingsRequest) == 0 {
+		t.Errorf("Expected AcceptsConnectionSettingsRequest to be advertised, got %b", response.Capabilities)
+	}
+	if response.ConnectionSettings != nil {
+		t.Fatalf("Expected no certificate before approval, got %v", response.ConnectionSettings)
+	}
+	if pending := s.ListCertificates(issuer.StatePending); len(pending) != 1 || pending[0].AgentID != "1201" {
+		t.Fatalf("Expected the request to await approval, got %+v", pending)
+	}
+
+	// Approving the request sends the certificate to the connected agent.
+	if _, err := s.ApproveCertificate(context.Background(), "1201"); err != nil {
+		t.Fatalf("ApproveCertificate error: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, reply, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read pushed certificate: %v", err)
+	}
+	pushed := &protobufs.ServerToAgent{}
+	if err := proto.Unmarshal(reply[1:], pushed); err != nil {
+		t.Fatalf("Failed to unmarshal pushed certificate: %v", err)
+	}
+	settings := pushed.GetConnectionSettings().GetOpamp()
+	if settings.GetDestinationEndpoint() != "wss://opamp.example.com/v1/opamp" {
+		t.Errorf("Expected the configured endpoint, got %q", settings.GetDestinationEndpoint())
+	}
+	block, _ := pem.Decode(settings.GetCertificate().GetCert())
+	if block == nil {
+		t.Fatalf("Expected a certificate, got %v", settings.GetCertificate())
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "1201" || !key.PublicKey.Equal(cert.PublicKey) {
+		t.Errorf("Expected a certificate for the agent's key with the agent ID as common name, got %q", cert.Subject)
+	}
+	if issued := s.ListCertificates(issuer.StateIssued); len(issued) != 1 || issued[0].NotAfter == nil {
+		t.Errorf("Expected the issued certificate to be tracked, got %+v", issued)
+	}
+}
+
+const testConfigCustomMessages = `
+opamp:
+  listen_address: "127.0.0.1:0"
+api:
+  listen_address: "127.0.0.1:0"
+custom_capabilities:
+  - "com.example.passthrough"
+`
+
+func TestOpAMPCustomMessages(t *testing.T) {
+	s := startTestServer(t, testConfigCustomMessages, func(s *Server) {
+		s.RegisterCustomMessageHandler("com.example.echo", func(ctx context.Context, agent *agents.Agent, message *protobufs.CustomMessage) *protobufs.CustomMessage {
+			return &protobufs.CustomMessage{Capability: message.Capability, Type: "reply", Data: message.Data}
+		})
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(opampURL(s, "ws"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	uid := []byte{0x13, 0x01}
+	response := exchangeAgentMessage(t, conn, &protobufs.AgentToServer{
+		InstanceUid:        uid,
+		CustomCapabilities: &protobufs.CustomCapabilities{Capabilities: []string{"com.example.echo", "com.example.passthrough"}},
+	})
+	if got := response.GetCustomCapabilities().GetCapabilities(); len(got) != 2 || got[0] != "com.example.echo" || got[1] != "com.example.passthrough" {
+		t.Errorf("Expected the configured and handled capabilities to be advertised, got %v", got)
+	}
+
+	// Handled capabilities are answered in the response
+	response = exchangeAgentMessage(t, conn, &protobufs.AgentToServer{
+		InstanceUid:   uid,
+		SequenceNum:   1,
+		CustomMessage: &protobufs.CustomMessage{Capability: "com.example.echo", Type: "ping", Data: []byte("hello")},
+	})
+	if reply := response.GetCustomMessage(); reply.GetType() != "reply" || string(reply.GetData()) != "hello" {
+		t.Errorf("Expected the handler's reply, got %v", reply)
+	}
+
+	exchangeAgentMessage(t, conn, &protobufs.AgentToServer{
+		InstanceUid:   uid,
+		SequenceNum:   2,
+		CustomMessage: &protobufs.CustomMessage{Capability: "com.example.passthrough", Type: "event", Data: []byte("42")},
+	})
+	messages, err := s.CustomMessages("1301")
+	if err != nil || len(messages) != 2 || messages[1].Type != "event" || string(messages[1].Data) != "42" {
+		t.Errorf("Expected the received messages to be recorded, got %+v, %v", messages, err)
+	}
+
+	if err := s.SendCustomMessage(context.Background(), "1301", &protobufs.CustomMessage{Capability: "com.example.other", Type: "x"}); !errors.Is(err, agents.ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for an undeclared capability, got %v", err)
+	}
+	if err := s.SendCustomMessage(context.Background(), "1301", &protobufs.CustomMessage{Capability: "com.example.passthrough", Type: "command", Data: []byte("run")}); err != nil {
+		t.Fatalf("SendCustomMessage error: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, reply, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read pushed message: %v", err)
+	}
+	pushed := &protobufs.ServerToAgent{}
+	if err := proto.Unmarshal(reply[1:], pushed); err != nil {
+		t.Fatalf("Failed to unmarshal pushed message: %v", err)
+	}
+	if custom := pushed.GetCustomMessage(); custom.GetType() != "command" || string(custom.GetData()) != "run" {
+		t.Errorf("Expected the custom message to be pushed, got %v", custom)
+	}
+}
+
+const testConfigAvailableComponents = `
+opamp:
+  listen_address: "127.0.0.1:0"
+api:
+  listen_address: "127.0.0.1:0"
+`
+
+// availableComponents builds the AvailableComponents a collector with the
+// given component types by kind reports.
+func availableComponents(hash string, types map[string][]string) *protobufs.AvailableComponents {
+	components := make(map[string]*protobufs.ComponentDetails, len(types))
+	for kind, names := range types {
+		details := &protobufs.ComponentDetails{SubComponentMap: make(map[string]*protobufs.ComponentDetails)}
+		for _, name := range names {
+			details.SubComponentMap[name] = &protobufs.ComponentDetails{}
+		}
+		components[kind] = details
+	}
+	return &protobufs.AvailableComponents{Components: components, Hash: []byte(hash)}
+}
+
+func TestOpAMPAvailableComponents(t *testing.T) {
+	s := startTestServer(t, testConfigAvailableComponents)
+
+	conn, _, err := websocket.DefaultDialer.Dial(opampURL(s, "ws"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	uid := []byte{0x14, 0x01}
+	reportFull := uint64(protobufs.ServerToAgentFlags_ServerToAgentFlags_ReportAvailableComponents)
+
+	// A hash the server has no components for is answered with a request for the full list
+	response := exchangeAgentMessage(t, conn, &protobufs.AgentToServer{
+		InstanceUid: uid,
+		Capabilities: uint64(protobufs.AgentCapabilities_AgentCapabilities_AcceptsRemoteConfig |
+			protobufs.AgentCapabilities_AgentCapabilities_ReportsAvailableComponents),
+		AvailableComponents: &protobufs.AvailableComponents{Hash: []byte("v1")},
+		EffectiveConfig: &protobufs.EffectiveConfig{ConfigMap: &protobufs.AgentConfigMap{ConfigMap: map[string]*protobufs.AgentConfigFile{
+			"collector": {Body: []byte("receivers:\n  otlp/grpc:\nprocessors:\n  batch:\nexporters:\n  debug:\nservice:\n  telemetry:\n    logs:\n      level: info\n")},
+		}}},
+	})
+	if response.Flags&reportFull == 0 {
+		t.Errorf("Expected the full component list to be requested, got flags %d", response.Flags)
+	}
+
+	response = exchangeAgentMessage(t, conn, &protobufs.AgentToServer{
+		InstanceUid: uid,
+		SequenceNum: 1,
+		AvailableComponents: availableComponents("v1", map[string][]string{
+			"receivers": {"otlp"},
+			"exporters": {"debug", "otlphttp"},
+		}),
+	})
+	if response.Flags&reportFull != 0 {
+		t.Error("Expected no further request once the full list was reported")
+	}
+	agent, _ := s.GetAgent("1401")
+	if types := agent.ComponentTypes(); len(types["exporters"]) != 2 || types["exporters"][0] != "debug" || types["receivers"][0] != "otlp" {
+		t.Errorf("Expected the reported components to be stored, got %v", types)
+	}
+
+	// The effective config uses the batch processor, which the binary lacks
+	err = s.UpdateAgentLogLevel(context.Background(), "1401", "debug")
+	if !errors.Is(err, agents.ErrMissingComponents) || !strings.Contains(err.Error(), "processors/batch") {
+		t.Fatalf("Expected the push to be rejected for processors/batch, got %v", err)
+	}
+	if agent, _ := s.GetAgent("1401"); agent.Config != "" {
+		t.Error("Expected the rejected configuration not to be stored")
+	}
+
+	// The unchanged hash does not ask for the list again
+	response = exchangeAgentMessage(t, conn, &protobufs.AgentToServer{
+		InstanceUid:         uid,
+		SequenceNum:         2,
+		AvailableComponents: &protobufs.AvailableComponents{Hash: []byte("v1")},
+	})
+	if response.Flags&reportFull != 0 {
+		t.Error("Expected no request for the list of a known hash")
+	}
+
+	exchangeAgentMessage(t, conn, &protobufs.AgentToServer{
+		InstanceUid: uid,
+		SequenceNum: 3,
+		AvailableComponents: availableComponents("v2", map[string][]string{
+			"receivers":  {"otlp"},
+			"processors": {"batch"},
+			"exporters":  {"debug"},
+		}),
+	})
+	if err := s.UpdateAgentLogLevel(context.Background(), "1401", "debug"); err != nil {
+		t.Fatalf("Expected the push to be sent once the binary includes batch, got %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, reply, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read pushed message: %v", err)
+	}
+	pushed := &protobufs.ServerToAgent{}
+	if err := proto.Unmarshal(reply[1:], pushed); err != nil {
+		t.Fatalf("Failed to unmarshal pushed message: %v", err)
+	}
+	if pushed.GetRemoteConfig() == nil {
+		t.Error("Expected the remote config to be pushed")
+	}
+}
+
+// issueTestCert writes a certificate for cn signed by the CA in caCertFile and
+// caKeyFile to dir and returns the certificate and key paths.
+func issueTestCert(t *testing.T, dir, caCertFile, caKeyFile, cn string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	caPEM, err := os.ReadFile(caCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKeyPEM, err := os.ReadFile(caKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(caPEM)
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ = pem.Decode(caKeyPEM)
+	caKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, cn+".crt"), filepath.Join(dir, cn+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+const testConfigClientAuth = `
+opamp:
+  listen_address: "127.0.0.1:0"
+  tls:
+    cert_file: "%s"
+    key_file: "%s"
+    client_ca_file: "%s"
+    client_auth: require
+api:
+  listen_address: "127.0.0.1:0"
+`
+
+func TestOpAMPClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	caA, caAKey := writeTestCA(t, t.TempDir())
+	caB, caBKey := writeTestCA(t, t.TempDir())
+	serverCert, serverKey := issueTestCert(t, dir, caA, caAKey, "server", x509.ExtKeyUsageServerAuth)
+	certA, keyA := issueTestCert(t, dir, caA, caAKey, "agent-a", x509.ExtKeyUsageClientAuth)
+	certB, keyB := issueTestCert(t, dir, caB, caBKey, "agent-b", x509.ExtKeyUsageClientAuth)
+
+	// The client CA bundle is a copy so that it can be swapped later.
+	clientCAFile := filepath.Join(dir, "client-ca.crt")
+	copyFile := func(src string) {
+		t.Helper()
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(clientCAFile, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copyFile(caA)
+
+	s := startTestServer(t, fmt.Sprintf(testConfigClientAuth, serverCert, serverKey, clientCAFile))
+
+	roots := x509.NewCertPool()
+	serverCA, err := os.ReadFile(caA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots.AppendCertsFromPEM(serverCA)
+
+	// dial connects to the OpAMP listener, presenting the given client
+	// certificate unless certFile is empty.
+	dial := func(certFile, keyFile string) error {
+		t.Helper()
+		tlsConfig := &tls.Config{RootCAs: roots}
+		if certFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		dialer := websocket.Dialer{TLSClientConfig: tlsConfig, HandshakeTimeout: 5 * time.Second}
+		conn, _, err := dialer.Dial(opampURL(s, "wss"), nil)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	if err := dial("", ""); err == nil {
+		t.Error("connection without a client certificate was accepted")
+	}
+	if err := dial(certA, keyA); err != nil {
+		t.Errorf("certificate from the configured CA was rejected: %v", err)
+	}
+	if err := dial(certB, keyB); err == nil {
+		t.Error("certificate from another CA was accepted")
+	}
+
+	// Swap the client CA bundle and reload without restarting the listener.
+	copyFile(caB)
+	if err := s.ReloadConfig(); err != nil {
+		t.Fatalf("ReloadConfig error: %v", err)
+	}
+	if err := dial(certB, keyB); err != nil {
+		t.Errorf("certificate from the new CA was rejected after reload: %v", err)
+	}
+	if err := dial(certA, keyA); err == nil {
+		t.Error("certificate from the old CA was accepted after reload")
+	}
+}
+
+const testConfigClientIdentity = `
+opamp:
+  listen_address: "127.0.0.1:0"
+  tls:
+    cert_file: "%s"
+    key_file: "%s"
+    client_ca_file: "%s"
+    identity:
+      match: instance_uid
+api:
+  listen_address: "127.0.0.1:0"
+`
+
+func TestOpAMPClientCertificateInstanceUIDSwitch(t *testing.T) {
+	dir := t.TempDir()
+	uidA := []byte("agent-a-instance")
+	uidB := []byte("agent-b-instance")
+	ca, caKey := writeTestCA(t, t.TempDir())
+	serverCert, serverKey := issueTestCert(t, dir, ca, caKey, "server", x509.ExtKeyUsageServerAuth)
+	certA, keyA := issueTestCert(t, dir, ca, caKey, fmt.Sprintf("%x", uidA), x509.ExtKeyUsageClientAuth)
+
+	s := startTestServer(t, fmt.Sprintf(testConfigClientIdentity, serverCert, serverKey, ca))
+
+	roots := x509.NewCertPool()
+	caPEM, err := os.ReadFile(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots.AppendCertsFromPEM(caPEM)
+	cert, err := tls.LoadX509KeyPair(certA, keyA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{cert}}}
+	conn, _, err := dialer.Dial(opampURL(s, "wss"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	response := exchangeAgentMessage(t, conn, &protobufs.AgentToServer{InstanceUid: uidA, SequenceNum: 1})
+	if response.ErrorResponse != nil {
+		t.Fatalf("agent matching its certificate was rejected: %s", response.ErrorResponse.ErrorMessage)
+	}
+
+	// The same connection now claims to be another agent.
+	data, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: uidB, SequenceNum: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, append([]byte{0}, data...)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, reply, err := conn.ReadMessage(); err == nil {
+		response := &protobufs.ServerToAgent{}
+		if err := proto.Unmarshal(reply[1:], response); err != nil {
+			t.Fatal(err)
+		}
+		if response.ErrorResponse == nil {
+			t.Fatal("switching to an instance UID the certificate does not name was accepted")
+		}
+	}
+	if ids := s.GetAgentIDs(); len(ids) != 0 {
+		t.Errorf("agents after the rejected switch = %v, want none", ids)
+	}
 }
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"opamp-backend/internal/api"
@@ -73,3 +74,51 @@ func TestServerHTTPAPI(t *testing.T) {
 		t.Errorf("Expected response body %q, got %q", expected, buf.String())
 	}
 }
+
+func TestServerReadiness(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "backend.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(`
+opamp:
+  listen_address: "127.0.0.1:34322"
+api:
+  listen_address: "127.0.0.1:38082"
+`)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	s, err := NewServer(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("NewServer error: %v", err)
+	}
+	defer func() {
+		s.Stop()
+		time.Sleep(100 * time.Millisecond)
+	}()
+
+	go s.Start()
+	time.Sleep(500 * time.Millisecond)
+
+	resp, err := http.Get("http://127.0.0.1:38082/readyz")
+	if err != nil {
+		t.Fatalf("readyz request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 OK from /readyz, got %d", resp.StatusCode)
+	}
+
+	s.RegisterReadinessCheck("storage", func() error { return errors.New("unreachable") })
+	resp, err = http.Get("http://127.0.0.1:38082/readyz")
+	if err != nil {
+		t.Fatalf("readyz request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 from /readyz with failing check, got %d", resp.StatusCode)
+	}
+}
@@ -1,6 +1,9 @@
 package server
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -10,3 +13,25 @@ func TestNewServer_InvalidConfig(t *testing.T) {
 		t.Error("expected error when config file does not exist")
 	}
 }
+
+func TestNewServer_ValidatesConfig(t *testing.T) {
+	// An auto-approve rule without criteria would approve every agent.
+	configPath := filepath.Join(t.TempDir(), "backend.yaml")
+	content := `
+opamp:
+  listen_address: "127.0.0.1:0"
+  enrollment:
+    enabled: true
+    auto_approve:
+      - name: "everyone"
+api:
+  listen_address: "127.0.0.1:0"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewServer(configPath)
+	if err == nil || !strings.Contains(err.Error(), "auto_approve[0]") {
+		t.Errorf("NewServer error = %v, want an auto_approve validation error", err)
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/api"
+	"opamp-backend/internal/audit"
+	"opamp-backend/internal/middleware"
+	"opamp-backend/internal/packages"
+	"time"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+var _ api.PackageServer = (*Server)(nil)
+
+// ListPackages returns the registered package versions and their
+// assignments.
+func (s *Server) ListPackages() ([]packages.Package, []packages.Assignment, error) {
+	if s.packages == nil {
+		return nil, nil, packages.ErrDisabled
+	}
+	return s.packages.List(), s.packages.Assignments(), nil
+}
+
+// AddPackage stores a new package version in the registry.
+func (s *Server) AddPackage(name, version, packageType string, signature []byte, content io.Reader) (packages.Package, error) {
+	if s.packages == nil {
+		return packages.Package{}, packages.ErrDisabled
+	}
+	p, err := s.packages.Add(name, version, packageType, signature, content)
+	if err != nil {
+		return p, err
+	}
+	logger.Info("Package added", "package", name, "version", version, "hash", p.Hash, "bytes", p.Size, "operation", "packages")
+	return p, nil
+}
+
+// PackageFile returns a package version and the path of its file for a
+// download through a signed URL with the given query. The URL must have been
+// issued to an existing agent that is still assigned that version.
+func (s *Server) PackageFile(name, version string, query url.Values) (packages.Package, string, error) {
+	if s.packages == nil {
+		return packages.Package{}, "", packages.ErrDisabled
+	}
+	agentID, err := s.packages.VerifyDownload(name, version, query, time.Now())
+	if err != nil {
+		return packages.Package{}, "", err
+	}
+	agent, exists := s.agentManager.GetAgent(agentID)
+	if !exists || s.packages.ForAgent(agent)[name].Version != version {
+		return packages.Package{}, "", fmt.Errorf("%w: %s is not assigned to agent %s", packages.ErrDenied, name+" "+version, agentID)
+	}
+	p, exists := s.packages.Get(name, version)
+	if !exists {
+		return p, "", packages.ErrNotFound
+	}
+	return p, s.packages.FilePath(name, version), nil
+}
+
+// downloadURLs returns the function building the signed URLs from which an
+// agent downloads its packages.
+func (s *Server) downloadURLs(agentID string) func(packages.Package) string {
+	baseURL := s.getConfig().Packages.BaseURL()
+	expires := time.Now().Add(packages.DownloadURLValidity)
+	return func(p packages.Package) string {
+		return s.packages.SignedDownloadURL(baseURL, agentID, p.Name, p.Version, expires)
+	}
+}
+
+// AssignPackage assigns a package version to the agents matched by selector
+// (an empty version removes the assignment) and sends the resulting packages
+// to the connected agents the caller may change. It returns the outcome for
+// each of them.
+func (s *Server) AssignPackage(ctx context.Context, name, version string, selector agents.Selector) (packages.Assignment, map[string]error, error) {
+	if s.packages == nil {
+		return packages.Assignment{}, nil, packages.ErrDisabled
+	}
+	assignment, err := s.packages.Assign(name, version, selector)
+	if err != nil {
+		return assignment, nil, err
+	}
+	logger.InfoContext(ctx, "Package assigned", "package", name, "version", version, "operation", "packages")
+
+	results := make(map[string]error)
+	for _, agent := range s.agentManager.GetAllAgents() {
+		if !selector.Matches(agent) || !middleware.AgentAllowed(ctx, agent) {
+			continue
+		}
+		results[agent.ID] = s.pushPackages(ctx, agent)
+	}
+	return assignment, results, nil
+}
+
+// pushPackages sends an agent every package assigned to it.
+func (s *Server) pushPackages(ctx context.Context, agent *agents.Agent) (err error) {
+	defer func() {
+		audit.AddTarget(ctx, agent.ID, "", "", err)
+	}()
+	if err := checkManaged(agent); err != nil {
+		return err
+	}
+
+	available := packages.Available(s.packages.ForAgent(agent), s.downloadURLs(agent.ID))
+	message := &protobufs.ServerToAgent{
+		InstanceUid:       []byte(agent.ID),
+		PackagesAvailable: available,
+	}
+	if err := s.sendToAgent(ctx, agent, message); err != nil {
+		logger.WarnContext(ctx, "Failed to send packages", "agent_id", agent.ID, "operation", "packages", "error", err)
+		return err
+	}
+	s.agentManager.SetOfferedPackages(agent.ID, available.AllPackagesHash)
+	return nil
+}
+
+// offerPackages adds the packages assigned to an agent to response unless the
+// agent already has them or was already offered them.
+func (s *Server) offerPackages(agentID string, response *protobufs.ServerToAgent) {
+	if s.packages == nil {
+		return
+	}
+	agent, exists := s.agentManager.GetAgent(agentID)
+	if !exists || agent.Pending || !agent.HasCapability(protobufs.AgentCapabilities_AgentCapabilities_AcceptsPackages) {
+		return
+	}
+	assigned := s.packages.ForAgent(agent)
+	if len(assigned) == 0 && len(agent.PackageStatuses.GetServerProvidedAllPackagesHash()) == 0 {
+		return
+	}
+	available := packages.Available(assigned, s.downloadURLs(agentID))
+	if !s.agentManager.NeedsPackages(agentID, available.AllPackagesHash) {
+		return
+	}
+	response.PackagesAvailable = available
+	s.agentManager.SetOfferedPackages(agentID, available.AllPackagesHash)
+	logger.Info("Offering packages", "agent_id", agentID, "packages", len(assigned), "operation", "packages")
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"opamp-backend/internal/apitokens"
+	"opamp-backend/internal/config"
+	"opamp-backend/internal/connsettings"
+	"opamp-backend/internal/issuer"
+	"opamp-backend/internal/logging"
+	"os"
+	"slices"
+	"time"
+)
+
+// defaultReloadInterval is how often watched files are checked when
+// reload.interval is not configured.
+const defaultReloadInterval = 5 * time.Second
+
+// ReloadConfig re-reads backend.yaml and applies the settings that can change
+// at runtime: logging, API tokens and OIDC, agent authentication, enrollment rules,
+// the connection settings offered to agents, the CA signing agent
+// certificates and the OpAMP TLS certificate and
+// client CA bundle. The reload is rejected, leaving the
+// running configuration untouched, if the new file fails validation,
+// references files that fail to load or changes settings that require a
+// restart.
+func (s *Server) ReloadConfig() error {
+	log := logger.With("operation", "reload_config")
+
+	newCfg, err := config.LoadConfig(s.configPath)
+	if err != nil {
+		log.Error("Rejected configuration reload", "error", err)
+		return fmt.Errorf("failed to load %s: %w", s.configPath, err)
+	}
+	if err := newCfg.Validate(); err != nil {
+		log.Error("Rejected configuration reload", "error", err)
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	oldCfg := s.getConfig()
+	if err := checkRestartRequired(oldCfg, newCfg); err != nil {
+		log.Error("Rejected configuration reload", "error", err)
+		return err
+	}
+
+	// Everything is loaded before anything changes, so that a file that
+	// fails to load leaves the running configuration untouched.
+	loaded, err := s.loadConfig(newCfg)
+	if err != nil {
+		log.Error("Rejected configuration reload", "error", err)
+		return err
+	}
+	s.applyConfig(newCfg, loaded)
+	log.Info("Configuration reloaded", "path", s.configPath)
+
+	// Agents that stay connected are sent changed connection settings now
+	s.pushConnectionSettings(context.Background())
+	return nil
+}
+
+// loadedConfig holds what loadConfig read from the files a configuration
+// references.
+type loadedConfig struct {
+	logging    logging.Settings
+	apiAuth    apiAuth
+	tokens     *apitokens.Snapshot
+	connection connsettings.Groups
+	ca         *issuer.CA
+	tls        *config.TLSFiles
+}
+
+// loadConfig reads and parses everything newCfg references without changing
+// the running server.
+func (s *Server) loadConfig(newCfg config.Config) (loadedConfig, error) {
+	var loaded loadedConfig
+	var err error
+	if loaded.logging, err = logging.ParseSettings(newCfg.Logging.Level, newCfg.Logging.Format, newCfg.Logging.Subsystems); err != nil {
+		return loaded, err
+	}
+	if loaded.apiAuth, err = loadAPIAuth(newCfg); err != nil {
+		return loaded, err
+	}
+	if s.tokenStore != nil {
+		tokens, err := s.tokenStore.Read()
+		if err != nil {
+			return loaded, fmt.Errorf("failed to reload api token store: %w", err)
+		}
+		loaded.tokens = &tokens
+	}
+	if loaded.connection, err = connsettings.Load(newCfg.ConnectionSettings); err != nil {
+		return loaded, err
+	}
+	if loaded.ca, err = issuer.LoadCA(newCfg.Certificates.CACertFile, newCfg.Certificates.CAKeyFile); err != nil {
+		return loaded, err
+	}
+	if newCfg.TLSEnabled() && s.certStore != nil {
+		files, err := config.LoadTLSFiles(newCfg.OpAMP.TLS.CertFile, newCfg.OpAMP.TLS.KeyFile, newCfg.OpAMP.TLS.ClientCAFile, newCfg.OpAMP.TLS.ClientAuth)
+		if err != nil {
+			return loaded, err
+		}
+		loaded.tls = &files
+	}
+	return loaded, nil
+}
+
+// applyConfig makes newCfg and what loadConfig read for it the running
+// configuration, all at once.
+func (s *Server) applyConfig(newCfg config.Config, loaded loadedConfig) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	logging.Apply(loaded.logging)
+	loaded.apiAuth.use()
+	if loaded.tokens != nil {
+		s.tokenStore.Use(*loaded.tokens)
+	}
+	s.connSettings.Use(loaded.connection)
+	s.issuer.Use(loaded.ca, certificatePolicy(newCfg.Certificates))
+	if loaded.tls != nil {
+		s.certStore.Use(*loaded.tls)
+	}
+	s.config = newCfg
+	s.agentAuth.Update(newCfg.OpAMP.Auth)
+	s.enrollment.Update(newCfg.OpAMP.Enrollment)
+	s.rateLimiter.Update(newCfg.API.Limits)
+	s.fanOuts.SetMax(newCfg.API.Limits.FanOuts())
+}
+
+// checkRestartRequired rejects changes to settings that are bound when the
+// listeners start.
+func checkRestartRequired(oldCfg, newCfg config.Config) error {
+	if oldCfg.OpAMP.Attach != newCfg.OpAMP.Attach {
+		return fmt.Errorf("opamp.attach cannot change without a restart")
+	}
+	if oldCfg.OpAMP.ListenAddress != newCfg.OpAMP.ListenAddress {
+		return fmt.Errorf("opamp.listen_address cannot change without a restart")
+	}
+	if oldCfg.API.ListenAddress != newCfg.API.ListenAddress {
+		return fmt.Errorf("api.listen_address cannot change without a restart")
+	}
+	if oldCfg.API.TokenStore != newCfg.API.TokenStore {
+		return fmt.Errorf("api.token_store cannot change without a restart")
+	}
+	if oldCfg.Packages.Dir != newCfg.Packages.Dir {
+		return fmt.Errorf("packages.dir cannot change without a restart")
+	}
+	if !slices.Equal(oldCfg.CustomCapabilities, newCfg.CustomCapabilities) {
+		return fmt.Errorf("custom_capabilities cannot change without a restart")
+	}
+	if oldCfg.OpAMP.Enrollment.Store != newCfg.OpAMP.Enrollment.Store {
+		return fmt.Errorf("opamp.enrollment.store cannot change without a restart")
+	}
+	if oldCfg.Certificates.Store != newCfg.Certificates.Store {
+		return fmt.Errorf("certificates.store cannot change without a restart")
+	}
+	if oldCfg.Audit.File != newCfg.Audit.File {
+		return fmt.Errorf("audit.file cannot change without a restart")
+	}
+	if oldCfg.TLSEnabled() != newCfg.TLSEnabled() {
+		return fmt.Errorf("enabling or disabling opamp.tls requires a restart")
+	}
+	return nil
+}
+
+// getConfig returns a copy of the running configuration.
+func (s *Server) getConfig() config.Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.config
+}
+
+// watchConfigFiles reloads the configuration whenever backend.yaml or the TLS
+// or token files referenced by it change on disk, until ctx is cancelled.
+func (s *Server) watchConfigFiles(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := s.watchedFilesVersion()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := s.watchedFilesVersion()
+			if current == last {
+				continue
+			}
+			last = current
+			logger.Info("Detected configuration file change", "operation", "reload_config")
+			s.ReloadConfig()
+		}
+	}
+}
+
+// watchedFilesVersion summarises the size and modification time of every
+// watched file so that any change produces a different value. The API token
+// store is not watched: the server writes it on every mint and revoke, and the
+// store re-reads it on change by itself.
+func (s *Server) watchedFilesVersion() string {
+	cfg := s.getConfig()
+	version := ""
+	paths := []string{s.configPath, cfg.OpAMP.TLS.CertFile, cfg.OpAMP.TLS.KeyFile, cfg.OpAMP.TLS.ClientCAFile, cfg.API.TokenFile, cfg.API.OIDC.JWKSFile, cfg.Certificates.CACertFile, cfg.Certificates.CAKeyFile}
+	for _, group := range cfg.ConnectionSettings {
+		paths = append(paths, group.CAFiles()...)
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			version += path + ":missing;"
+			continue
+		}
+		version += fmt.Sprintf("%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+	}
+	return version
+}
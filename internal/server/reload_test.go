@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/logging"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfigReload = `
+opamp:
+  listen_address: "127.0.0.1:34324"
+api:
+  listen_address: "127.0.0.1:38084"
+logging:
+  level: info
+`
+
+func TestReloadConfig(t *testing.T) {
+	configPath, err := createTempConfig(testConfigReload)
+	if err != nil {
+		t.Fatalf("Failed to create temp config: %v", err)
+	}
+	defer os.Remove(configPath)
+	defer logging.Configure("info", "text", nil)
+
+	s, err := NewServer(configPath)
+	if err != nil {
+		t.Fatalf("NewServer error: %v", err)
+	}
+
+	// A valid change is applied.
+	if err := os.WriteFile(configPath, []byte(testConfigReload+"  subsystems:\n    opamp: debug\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadConfig(); err != nil {
+		t.Fatalf("ReloadConfig error: %v", err)
+	}
+	if level := logging.Levels()[logging.SubsystemOpAMP]; level != "debug" {
+		t.Errorf("Expected opamp log level debug after reload, got %s", level)
+	}
+
+	// An invalid file is rejected and the running config is kept.
+	if err := os.WriteFile(configPath, []byte(testConfigReload+"  format: xml\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadConfig(); err == nil {
+		t.Error("Expected invalid configuration to be rejected")
+	}
+	if level := logging.Levels()[logging.SubsystemOpAMP]; level != "debug" {
+		t.Errorf("Expected log level to be unchanged after rejected reload, got %s", level)
+	}
+
+	// Listen addresses cannot change at runtime.
+	if err := os.WriteFile(configPath, []byte(`
+opamp:
+  listen_address: "127.0.0.1:34325"
+api:
+  listen_address: "127.0.0.1:38084"
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadConfig(); err == nil {
+		t.Error("Expected listen address change to be rejected")
+	}
+	if addr := s.getConfig().OpAMP.ListenAddress; addr != "127.0.0.1:34324" {
+		t.Errorf("Expected listen address to be unchanged, got %s", addr)
+	}
+}
+
+func TestReloadConfig_AllOrNothing(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "api-tokens.json")
+	config := strings.Replace(testConfigReload, "api:\n", fmt.Sprintf("api:\n  token_store: %q\n", storePath), 1)
+	configPath, err := createTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create temp config: %v", err)
+	}
+	defer os.Remove(configPath)
+	defer logging.Configure("info", "text", nil)
+
+	s, err := NewServer(configPath)
+	if err != nil {
+		t.Fatalf("NewServer error: %v", err)
+	}
+
+	// The new logging level is valid, but the token store no longer parses,
+	// so the level is not applied either.
+	if err := os.WriteFile(storePath, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, []byte(config+"  subsystems:\n    opamp: debug\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadConfig(); err == nil {
+		t.Fatal("Expected a reload with an unreadable token store to be rejected")
+	}
+	if level := logging.Levels()[logging.SubsystemOpAMP]; level != "info" {
+		t.Errorf("Expected opamp log level to stay info, got %s", level)
+	}
+	if subsystems := s.getConfig().Logging.Subsystems; len(subsystems) != 0 {
+		t.Errorf("Expected the running configuration to be kept, got subsystems %v", subsystems)
+	}
+}
+
+func TestWatchConfigFiles_IgnoresTokenStore(t *testing.T) {
+	defer logging.Configure("info", "text", nil)
+	storePath := filepath.Join(t.TempDir(), "api-tokens.json")
+	s := startTestServer(t, fmt.Sprintf(`
+opamp:
+  listen_address: "127.0.0.1:0"
+api:
+  listen_address: "127.0.0.1:0"
+  token_store: %q
+reload:
+  watch: true
+  interval: 10ms
+`, storePath))
+
+	if err := logging.SetLevel(logging.SubsystemOpAMP, "debug"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.tokenStore.Mint("ci", []string{"read"}, agents.Selector{}, nil); err != nil {
+		t.Fatalf("Mint error: %v", err)
+	}
+
+	// Give the watcher several polls to notice the rewritten store.
+	time.Sleep(100 * time.Millisecond)
+	if level := logging.Levels()[logging.SubsystemOpAMP]; level != "debug" {
+		t.Errorf("Expected opamp log level to stay debug after minting a token, got %s", level)
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"opamp-backend/internal/agents"
+	"opamp-backend/internal/api"
+	"opamp-backend/internal/audit"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+var _ api.RestartServer = (*Server)(nil)
+
+// RestartAgent sends the restart command to an agent and tracks it until the
+// agent comes back. The command is recorded as a target of the audited
+// request in ctx, if any.
+func (s *Server) RestartAgent(ctx context.Context, agentID string) (err error) {
+	defer func() {
+		audit.AddTarget(ctx, agentID, "", "", err)
+	}()
+
+	agent, exists := s.agentManager.GetAgent(agentID)
+	if !exists {
+		return fmt.Errorf("agent %s not found", agentID)
+	}
+	if err := checkManaged(agent); err != nil {
+		return err
+	}
+
+	message := &protobufs.ServerToAgent{
+		InstanceUid: []byte(agentID),
+		Command: &protobufs.ServerToAgentCommand{
+			Type: protobufs.CommandType_CommandType_Restart,
+		},
+	}
+
+	// Track the restart before sending so that a quick disconnect is seen
+	s.agentManager.StartRestart(agentID)
+	if err := s.sendToAgent(ctx, agent, message); err != nil {
+		s.agentManager.CancelRestart(agentID)
+		logger.WarnContext(ctx, "Failed to send restart command", "agent_id", agentID, "operation", "restart", "error", err)
+		return err
+	}
+
+	logger.InfoContext(ctx, "Restart command sent", "agent_id", agentID, "operation", "restart")
+	return nil
+}
+
+// ListRestarts returns the last restart requested for each agent.
+func (s *Server) ListRestarts() []agents.Restart {
+	return s.agentManager.Restarts()
+}
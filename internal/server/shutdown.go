@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"opamp-backend/internal/agents"
+	"time"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	opampTypes "github.com/open-telemetry/opamp-go/server/types"
+)
+
+// defaultShutdownTimeout bounds Stop when no shutdown_timeout is configured.
+const defaultShutdownTimeout = 10 * time.Second
+
+// shutdownRetryAfter is how long agents are asked to wait before
+// reconnecting when the server shuts down.
+const shutdownRetryAfter = 5 * time.Second
+
+// RegisterShutdownHook adds a function that runs at the end of Shutdown,
+// after agent connections are closed and in-flight messages have drained.
+// Subsystems that buffer state, such as a storage backend, flush it here.
+func (s *Server) RegisterShutdownHook(name string, hook func(ctx context.Context) error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.shutdownHooks = append(s.shutdownHooks, namedHook{name: name, fn: hook})
+}
+
+type namedHook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Stop cleanly stops the server using the configured shutdown timeout.
+func (s *Server) Stop() {
+	timeout := s.getConfig().ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		logger.Error("Shutdown did not complete cleanly", "operation", "shutdown", "error", err)
+	}
+}
+
+// Shutdown stops the HTTP API and the OpAMP listener, closes every agent
+// connection, waits for in-flight OnMessage handlers and runs the registered
+// shutdown hooks. It returns early with an error if ctx expires first.
+func (s *Server) Shutdown(ctx context.Context) error {
+	log := logger.With("operation", "shutdown")
+	s.stopping.Store(true)
+
+	var errs []error
+
+	if s.watchCancel != nil {
+		s.watchCancel()
+	}
+	if s.sweepCancel != nil {
+		s.sweepCancel()
+	}
+
+	// Stop accepting API calls first so no new pushes are started.
+	s.stateMu.RLock()
+	httpServer := s.httpServer
+	s.stateMu.RUnlock()
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("API server shutdown: %w", err))
+		}
+	}
+
+	// Stop accepting new agent connections.
+	s.restartOpampMu.Lock()
+	opampSrv := s.opampServer
+	s.restartOpampMu.Unlock()
+	if err := opampSrv.Stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("OpAMP server shutdown: %w", err))
+	}
+	s.setOpampListening(false)
+
+	// Refuse new messages, then tell connected agents we are going away.
+	s.inflightMu.Lock()
+	s.draining = true
+	s.inflightMu.Unlock()
+
+	closed := 0
+	for _, agent := range s.agentManager.GetAllAgents() {
+		conn, ok := agent.Conn.(opampTypes.Connection)
+		if !ok || conn == nil || agent.Transport == agents.TransportHTTP {
+			continue
+		}
+		if err := closeAgentConnection(ctx, conn); err != nil {
+			log.Debug("Failed to close agent connection", "agent_id", agent.ID, "error", err)
+			continue
+		}
+		closed++
+	}
+	log.Info("Closed agent connections", "closed_connections", closed)
+
+	// Wait for OnMessage handlers that were already running.
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("waiting for in-flight messages: %w", ctx.Err()))
+	}
+
+	s.stateMu.RLock()
+	hooks := append([]namedHook(nil), s.shutdownHooks...)
+	s.stateMu.RUnlock()
+	for _, hook := range hooks {
+		if err := hook.fn(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown hook %s: %w", hook.name, err))
+		}
+	}
+
+	log.Info("Server stopped")
+	return errors.Join(errs...)
+}
+
+// beginMessage registers an in-flight OnMessage handler. It returns false if
+// the server is draining, in which case the message must not be processed.
+func (s *Server) beginMessage() bool {
+	s.inflightMu.Lock()
+	defer s.inflightMu.Unlock()
+	if s.draining {
+		return false
+	}
+	s.inflight.Add(1)
+	return true
+}
+
+// closeAgentConnection tells an agent that the server is going away before
+// closing its connection, so that it reconnects after shutdownRetryAfter
+// instead of treating the disconnect as an error. The message goes through
+// the connection's Send, which serializes writes with any concurrent push.
+// Plain HTTP connections cannot be sent to and return an error.
+func closeAgentConnection(ctx context.Context, conn opampTypes.Connection) error {
+	err := conn.Send(ctx, &protobufs.ServerToAgent{
+		ErrorResponse: &protobufs.ServerErrorResponse{
+			Type:         protobufs.ServerErrorResponseType_ServerErrorResponseType_Unavailable,
+			ErrorMessage: "server is shutting down",
+			Details: &protobufs.ServerErrorResponse_RetryInfo{
+				RetryInfo: &protobufs.RetryInfo{RetryAfterNanoseconds: uint64(shutdownRetryAfter)},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return conn.Disconnect()
+}
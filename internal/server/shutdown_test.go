@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"google.golang.org/protobuf/proto"
+)
+
+const testConfigShutdown = `
+opamp:
+  listen_address: "127.0.0.1:0"
+api:
+  listen_address: "127.0.0.1:0"
+shutdown_timeout: 2s
+`
+
+func TestShutdown_ClosesAgentConnections(t *testing.T) {
+	s := startTestServer(t, testConfigShutdown)
+	wsURL := opampURL(s, "ws")
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to establish WebSocket connection: %v", err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	hookCalled := false
+	s.RegisterShutdownHook("test", func(ctx context.Context) error {
+		hookCalled = true
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected a message before the connection closed, got %v", err)
+	}
+	message := &protobufs.ServerToAgent{}
+	if err := proto.Unmarshal(data[1:], message); err != nil {
+		t.Fatalf("Failed to unmarshal message: %v", err)
+	}
+	if errResp := message.GetErrorResponse(); errResp.GetType() != protobufs.ServerErrorResponseType_ServerErrorResponseType_Unavailable ||
+		errResp.GetRetryInfo().GetRetryAfterNanoseconds() != uint64(shutdownRetryAfter) {
+		t.Errorf("Expected an unavailable error with retry info, got %v", errResp)
+	}
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("Expected the connection to be closed after the message")
+	}
+
+	if !hookCalled {
+		t.Error("Expected shutdown hook to be called")
+	}
+
+	if _, _, err := websocket.DefaultDialer.Dial(wsURL, nil); err == nil {
+		t.Error("Expected OpAMP listener to be closed after shutdown")
+	}
+}